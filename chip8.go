@@ -0,0 +1,135 @@
+// Package chip8 is an embeddable CHIP-8 machine. The host program drives it
+// one 60 Hz frame at a time, passes in key presses, and reads back the display
+// and sound state, so it can be used by bots, servers and test tools as well
+// as graphical frontends. It doesn't depend on SDL or termbox.
+//
+//	m := chip8.New(rom, chip8.ClockSpeed(600))
+//	for {
+//		m.SetKey(0x5, pressed)
+//		if err := m.StepFrame(); err != nil {
+//			break
+//		}
+//		draw(m.Framebuffer())
+//	}
+package chip8
+
+import (
+	"errors"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// Display dimensions in pixels.
+const (
+	Width  = 64
+	Height = 32
+)
+
+// FrameRate is the number of frames StepFrame runs per emulated second.
+const FrameRate = cpu.FrameRate
+
+// ErrHalted is returned by StepFrame once the program has halted by reaching
+// a 0x0000 opcode.
+var ErrHalted = errors.New("Program halted")
+
+// Machine is a CHIP-8 machine with a ROM loaded.
+type Machine struct {
+	cpu *cpu.Cpu
+}
+
+// New returns a machine with the ROM loaded at 0x200, ready to run.
+func New(rom []byte, opts ...Option) *Machine {
+	m := &Machine{cpu: cpu.NewCpu(nil, rom)}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// StepFrame counts the timers down and runs one frame's worth of
+// instructions, carrying any fraction of an instruction over to the next
+// frame. It stops early and returns the error if the CPU faults, or
+// ErrHalted if the program halts.
+func (m *Machine) StepFrame() error {
+	if m.cpu.ShouldHalt {
+		return ErrHalted
+	}
+	for i := m.cpu.StartFrame(); i > 0; i-- {
+		if err := m.cpu.Step(); err != nil {
+			return err
+		}
+		if m.cpu.ShouldHalt {
+			return ErrHalted
+		}
+	}
+	return nil
+}
+
+// Framebuffer returns a copy of the display.
+func (m *Machine) Framebuffer() Framebuffer {
+	return Framebuffer(m.cpu.Vram)
+}
+
+// SetKey presses or releases one of the sixteen keys, 0x0 to 0xF. Other
+// values are ignored.
+func (m *Machine) SetKey(key uint8, down bool) {
+	m.cpu.SetKey(key, down)
+}
+
+// SoundActive reports whether the buzzer should be sounding, i.e. the sound
+// timer is running.
+func (m *Machine) SoundActive() bool {
+	return m.cpu.SoundTimer > 0
+}
+
+// Halted reports whether the program has halted.
+func (m *Machine) Halted() bool {
+	return m.cpu.ShouldHalt
+}
+
+// State returns a snapshot of the machine, which can be saved as JSON and
+// later passed to Restore.
+func (m *Machine) State() cpu.State {
+	return m.cpu.State()
+}
+
+// Restore puts the machine back into a state returned by State. States
+// that no machine could be in are rejected with an error.
+func (m *Machine) Restore(s cpu.State) error {
+	if err := m.cpu.Restore(s); err != nil {
+		return err
+	}
+	m.cpu.ShouldHalt = false
+	return nil
+}
+
+// Reset restarts the program from the beginning.
+func (m *Machine) Reset() {
+	m.cpu.Reset()
+}
+
+// Subscribe calls f for each event of the given kinds, such as
+// cpu.DisplayChanged or cpu.SoundStarted. The returned function removes the
+// subscription.
+func (m *Machine) Subscribe(kinds cpu.EventKind, f func(cpu.Event)) (unsubscribe func()) {
+	return m.cpu.Subscribe(kinds, f)
+}
+
+// CPU returns the underlying CPU, for tools such as the debugger that need
+// direct access to it.
+func (m *Machine) CPU() *cpu.Cpu {
+	return m.cpu
+}
+
+// Framebuffer is a copy of the display. Each row holds 64 pixels, with x = 0
+// in the most significant bit.
+type Framebuffer [Height]int64
+
+// Pixel reports whether the pixel at (x, y) is lit. Coordinates outside the
+// display are never lit.
+func (f Framebuffer) Pixel(x, y int) bool {
+	if x < 0 || x >= Width || y < 0 || y >= Height {
+		return false
+	}
+	return uint64(f[y])>>uint(Width-1-x)&1 != 0
+}
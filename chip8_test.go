@@ -0,0 +1,173 @@
+package chip8
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// drawRom sets the sound timer, draws a single pixel at (5, 0) and then loops
+// forever.
+var drawRom = []byte{
+	0x60, 0x05, // LD V0, 5
+	0xF0, 0x18, // LD ST, V0
+	0xA2, 0x0A, // LD I, 0x20A
+	0xD0, 0x11, // DRW V0, V1, 1
+	0x12, 0x08, // JP 0x208
+	0x80, // sprite
+}
+
+// Test that StepFrame runs one instruction per frame at the default clock
+// speed, and that the display and sound state can be read back.
+func TestStepFrame(t *testing.T) {
+	assert := asrt.New(t)
+
+	m := New(drawRom)
+	assert.False(m.SoundActive())
+
+	assert.Nil(m.StepFrame())
+	assert.Nil(m.StepFrame())
+	assert.True(m.SoundActive())
+	assert.False(m.Framebuffer().Pixel(5, 0))
+
+	assert.Nil(m.StepFrame())
+	assert.Nil(m.StepFrame())
+	fb := m.Framebuffer()
+	assert.True(fb.Pixel(5, 0))
+	assert.False(fb.Pixel(4, 0))
+	assert.False(fb.Pixel(-1, 0))
+	assert.False(fb.Pixel(5, Height))
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(m.StepFrame())
+	}
+	assert.False(m.SoundActive())
+}
+
+// Test that the clock speed option sets the number of instructions run per
+// second, even when it isn't a multiple of the frame rate.
+func TestClockSpeed(t *testing.T) {
+	assert := asrt.New(t)
+
+	m := New(drawRom, ClockSpeed(240))
+	assert.Nil(m.StepFrame())
+	assert.True(m.Framebuffer().Pixel(5, 0))
+	assert.Equal(uint64(4), m.State().Cycles)
+
+	for _, hz := range []int{1, 30, 500, 1000} {
+		m := New(drawRom, ClockSpeed(hz))
+		for i := 0; i < FrameRate; i++ {
+			assert.Nil(m.StepFrame())
+		}
+		assert.Equal(uint64(hz), m.State().Cycles, "%d Hz", hz)
+	}
+
+	// Under the frame rate, some frames run no instructions.
+	m = New(drawRom, ClockSpeed(30))
+	assert.Nil(m.StepFrame())
+	assert.Equal(uint64(0), m.State().Cycles)
+	assert.Nil(m.StepFrame())
+	assert.Equal(uint64(1), m.State().Cycles)
+}
+
+// Test that the timers count down once per frame, whatever the clock speed.
+func TestTimers(t *testing.T) {
+	assert := asrt.New(t)
+
+	m := New([]byte{
+		0x60, 0x3C, // LD V0, 60
+		0xF0, 0x15, // LD DT, V0
+		0x12, 0x04, // JP 0x204
+	}, ClockSpeed(600))
+	assert.Nil(m.StepFrame())
+	assert.Equal(uint8(60), m.State().DelayTimer)
+	for dt := 59; dt >= 0; dt-- {
+		assert.Nil(m.StepFrame())
+		assert.Equal(uint8(dt), m.State().DelayTimer)
+	}
+	assert.Nil(m.StepFrame())
+	assert.Equal(uint8(0), m.State().DelayTimer)
+}
+
+// Test that StepFrame reports a halted program, and keeps doing so.
+func TestHalt(t *testing.T) {
+	assert := asrt.New(t)
+
+	m := New(nil)
+	assert.Equal(ErrHalted, m.StepFrame())
+	assert.True(m.Halted())
+	assert.Equal(ErrHalted, m.StepFrame())
+}
+
+// Test that faults are returned from StepFrame.
+func TestFault(t *testing.T) {
+	assert := asrt.New(t)
+
+	m := New([]byte{0x00, 0xEE})
+	assert.NotNil(m.StepFrame())
+	assert.False(m.Halted())
+}
+
+// Test that keys can be pressed and released, and out of range keys are
+// ignored.
+func TestSetKey(t *testing.T) {
+	assert := asrt.New(t)
+
+	m := New(drawRom)
+	m.SetKey(0xA, true)
+	m.SetKey(0x10, true)
+	assert.Equal(uint8(1), m.State().Keys[0xA])
+
+	m.SetKey(0xA, false)
+	assert.Equal(uint8(0), m.State().Keys[0xA])
+}
+
+// Test that a saved state survives a round trip through JSON and restores
+// the machine to where it was.
+func TestStateRestore(t *testing.T) {
+	assert := asrt.New(t)
+
+	m := New(drawRom)
+	m.StepFrame()
+	m.StepFrame()
+	data, err := json.Marshal(m.State())
+	assert.Nil(err)
+
+	for i := 0; i < 5; i++ {
+		m.StepFrame()
+	}
+	assert.True(m.Framebuffer().Pixel(5, 0))
+
+	s := m.State()
+	assert.Nil(json.Unmarshal(data, &s))
+	assert.Nil(m.Restore(s))
+	assert.Equal(uint16(0x204), m.State().PC)
+	assert.False(m.Framebuffer().Pixel(5, 0))
+	assert.True(m.SoundActive())
+}
+
+// Test that states with the stack pointer, PC or I out of range are
+// rejected, and leave the machine as it was.
+func TestRestoreInvalid(t *testing.T) {
+	assert := asrt.New(t)
+
+	m := New([]byte{0x00, 0xEE}) // RET
+	good := m.State()
+	for _, bad := range []func(s *cpu.State){
+		func(s *cpu.State) { s.StackPointer = 17 },
+		func(s *cpu.State) { s.StackPointer = -1 },
+		func(s *cpu.State) { s.PC = 0x1000 },
+		func(s *cpu.State) { s.IndexRegister = 0x1000 },
+	} {
+		s := good
+		bad(&s)
+		assert.NotNil(m.Restore(s))
+		assert.Equal(good, m.State())
+	}
+
+	// Returning with an empty stack is a fault, not a panic.
+	assert.IsType(&cpu.StackError{}, m.StepFrame())
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cweagans/chip8/pkg/dap"
+)
+
+// runDap implements `chip8 dap [flags]`.
+func runDap(args []string) {
+	fs := flag.NewFlagSet("dap", flag.ExitOnError)
+	listen := fs.String("listen", "", "Accept DAP connections on this address instead of using stdin/stdout.")
+	fs.Parse(args)
+
+	var err error
+	if *listen != "" {
+		fmt.Fprintln(os.Stderr, "Waiting for DAP clients on "+*listen)
+		err = dap.ListenAndServe(*listen)
+	} else {
+		err = dap.NewServer().Serve(os.Stdin, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/debugger"
+)
+
+// runDebugger implements `chip8 debug [flags] rom.ch8`.
+func runDebugger(args []string) {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: chip8 debug [flags] rom.ch8")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	rom, err := loadRom(fs.Arg(0))
+	if err != nil {
+		fmt.Println("Could not open specified ROM file: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Ctrl-C stops a running program instead of exiting the debugger.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	interrupt := make(chan struct{}, 1)
+	go func() {
+		for range sigs {
+			select {
+			case interrupt <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	repl := &debugger.REPL{
+		Debugger:  debugger.New(cpu.NewCpu(nil, rom)),
+		In:        os.Stdin,
+		Out:       os.Stdout,
+		Interrupt: interrupt,
+	}
+	if err := repl.Run(); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
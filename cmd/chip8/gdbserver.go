@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/debugger"
+	"github.com/cweagans/chip8/pkg/gdbstub"
+)
+
+// runGdbServer implements `chip8 gdbserver [flags] rom.ch8`.
+func runGdbServer(args []string) {
+	fs := flag.NewFlagSet("gdbserver", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:1234", "Address to accept GDB remote connections on.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: chip8 gdbserver [flags] rom.ch8")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	rom, err := loadRom(fs.Arg(0))
+	if err != nil {
+		fmt.Println("Could not open specified ROM file: " + err.Error())
+		os.Exit(1)
+	}
+
+	d := debugger.New(cpu.NewCpu(nil, rom))
+	fmt.Println("Waiting for GDB on " + *listen)
+	if err := gdbstub.ListenAndServe(*listen, d); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cweagans/chip8/pkg/golden"
+)
+
+// runGolden implements `chip8 golden [flags] dir`.
+func runGolden(args []string) {
+	fs := flag.NewFlagSet("golden", flag.ExitOnError)
+	opts := golden.Options{}
+	fs.IntVar(&opts.Frames, "frames", 600, "Number of 60 Hz frames to run ROMs without a NAME.frames file for.")
+	fs.IntVar(&opts.ClockSpeed, "clock-speed", 60, "Set the CPU clock speed (in Hertz).")
+	fs.IntVar(&opts.Scale, "scale", 4, "Size of each pixel in golden images written with -update.")
+	fs.BoolVar(&opts.Update, "update", false, "Write the current display of every ROM as its golden image.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: chip8 golden [flags] dir")
+		fmt.Fprintln(os.Stderr, "Runs every NAME.ch8 in dir headlessly, with NAME.keys as the key script and")
+		fmt.Fprintln(os.Stderr, "NAME.frames as the frame count if present, and compares the display to NAME.png.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	outcomes, err := golden.RunDir(fs.Arg(0), opts)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(2)
+	}
+
+	failed := 0
+	for _, o := range outcomes {
+		fmt.Println(o)
+		if !o.Passed() {
+			failed++
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", len(outcomes)-failed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -3,36 +3,117 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 
+	"github.com/cweagans/chip8"
+	"github.com/cweagans/chip8/pkg/coverage"
 	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/phosphor"
+	"github.com/cweagans/chip8/pkg/profile"
+	"github.com/cweagans/chip8/pkg/srcmap"
+	"github.com/cweagans/chip8/pkg/trace"
 	"github.com/cweagans/chip8/pkg/ui"
 )
 
 var (
-	RomFile    string
-	UIMode     string
-	Debug      bool
-	ClockSpeed int
+	RomFile     string
+	UIMode      string
+	Debug       bool
+	ClockSpeed  int
+	TraceFile   string
+	TraceFormat string
+	TraceRange  string
+	TraceOps    string
+	ProfileFile string
+	HeatmapFile string
+	CoverFile   string
+	CoverSrcmap string
+	KeymapFile  string
+	AudioMode   string
+	Pitch       float64
+	Volume      float64
+	WaveformArg string
+	AudioOut    string
+	PaletteArg  string
+	ScalingArg  string
+	Grid        bool
+	Fullscreen  bool
+	PhosphorArg string
+	EffectsArg  string
+	TextModeArg string
 )
 
 func init() {
+	// SDL must be driven from the main OS thread, which is the one running
+	// init and main.
+	runtime.LockOSThread()
+
 	flag.StringVar(&UIMode, "ui", "sdl", "Which UI should the emulator use? Options: sdl (default), termbox.")
-	flag.BoolVar(&Debug, "debug", false, "Set debug to true if you want to log CPU internals")
+	flag.BoolVar(&Debug, "debug", false, "Set debug to true if you want to log CPU internals (same as -trace -).")
 	flag.IntVar(&ClockSpeed, "clock-speed", 60, "Set the CPU clock speed (in Hertz).")
+	flag.StringVar(&KeymapFile, "keymap", "", "Read the keyboard layout from this file instead of using 1234/QWER/ASDF/ZXCV.")
+	flag.StringVar(&AudioMode, "audio", "auto", "How to play the buzzer, or several ways separated by commas. Options: auto (default: sdl with the SDL UI, bell with termbox), sdl, bell, flash (termbox only), none.")
+	flag.Float64Var(&Pitch, "pitch", ui.DefaultTone.Pitch, "Buzzer pitch in Hz.")
+	flag.Float64Var(&Volume, "volume", ui.DefaultTone.Volume, "Buzzer volume, from 0 to 1.")
+	flag.StringVar(&WaveformArg, "waveform", ui.DefaultTone.Waveform.String(), "Buzzer waveform. Options: square (default), triangle, sine, sawtooth.")
+	flag.StringVar(&AudioOut, "audio-out", "", "Record the buzzer to this WAV file, timed by the emulated clock.")
+	flag.StringVar(&PaletteArg, "palette", "", "Display colours: a palette file, or one of "+strings.Join(ui.PaletteNames(), ", ")+" (default classic).")
+	flag.StringVar(&ScalingArg, "scaling", ui.ScaleInteger.String(), "How the SDL UI fits the display to the window. Options: integer (default, whole-number pixel sizes), fit.")
+	flag.BoolVar(&Grid, "grid", false, "Draw a grid between pixels in the SDL UI.")
+	flag.BoolVar(&Fullscreen, "fullscreen", false, "Start the SDL UI fullscreen. F11 or Alt+Enter toggles it.")
+	flag.StringVar(&EffectsArg, "crt", "none", "CRT effects for the SDL UI, separated by commas: scanlines, bloom, curvature, vignette, all or none (default). F5 to F8 toggle them while running.")
+	flag.StringVar(&TextModeArg, "text-mode", "auto", "How the termbox UI draws pixels. Options: auto (default: the roomiest that fits the terminal), wide (two spaces per pixel), half (half blocks, 1x2 pixels per character), braille (2x4 pixels per character).")
+	flag.StringVar(&PhosphorArg, "phosphor", "", "Blend frames to hide flicker: off, or[:FRAMES] or fade[:FRAMES]. Defaults to the ROM's .phosphor file if it has one, and off if not.")
 	flag.StringVar(&RomFile, "rom", "", "Set the ROM filename that the emulator will load.")
+	flag.StringVar(&TraceFile, "trace", "", "Write an execution trace to this file (- for stdout).")
+	flag.StringVar(&TraceFormat, "trace-format", "text", "Execution trace format. Options: text (default), json, binary, common.")
+	flag.StringVar(&TraceRange, "trace-range", "", "Only trace instructions in this address range, e.g. 0x200-0x2FF.")
+	flag.StringVar(&TraceOps, "trace-ops", "", "Only trace these opcode classes (first hex digit), e.g. 1,2,D.")
+	flag.StringVar(&ProfileFile, "profile", "", "Write a pprof profile to this file when the emulator exits.")
+	flag.StringVar(&HeatmapFile, "heatmap", "", "Write an execution heatmap to this file when the emulator exits (HTML if it ends in .html).")
+	flag.StringVar(&CoverFile, "coverage", "", "Write a coverage report to this file when the emulator exits (HTML if it ends in .html).")
+	flag.StringVar(&CoverSrcmap, "coverage-srcmap", "", "Report coverage against the assembler source in this source map instead of a disassembly.")
+}
+
+func main() {
+	// Subcommands take over the rest of the command line.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "debug":
+			runDebugger(os.Args[2:])
+			return
+		case "gdbserver":
+			runGdbServer(os.Args[2:])
+			return
+		case "dap":
+			runDap(os.Args[2:])
+			return
+		case "tracediff":
+			runTraceDiff(os.Args[2:])
+			return
+		case "run":
+			runCommand(os.Args[2:])
+			return
+		case "golden":
+			runGolden(os.Args[2:])
+			return
+		case "selftest":
+			runSelftest(os.Args[2:])
+			return
+		}
+	}
+
 	flag.Parse()
 
 	if RomFile == "" {
 		fmt.Println("-rom flag is required.")
 		os.Exit(1)
 	}
-}
-
-func main() {
-	// Get a UI object.
-	u := ui.GetUI(UIMode)
 
 	// Load ROM to pass to CPU.
 	rom, err := loadRom(RomFile)
@@ -40,14 +121,368 @@ func main() {
 		fmt.Println("Could not open specified ROM file: " + err.Error())
 	}
 
-	// Create a new CPU.
-	c := cpu.NewCpu(u, rom, Debug)
+	runRom(rom)
+}
+
+// runRom runs the ROM in the UI chosen by -ui until it halts or the user
+// quits.
+func runRom(rom []byte) {
+	keymap := ui.DefaultKeymap
+	if KeymapFile != "" {
+		var err error
+		keymap, err = ui.LoadKeymap(KeymapFile)
+		if err != nil {
+			fmt.Println("Could not load keymap: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	palette, err := loadPalette()
+	if err != nil {
+		fmt.Println("Could not load palette: " + err.Error())
+		os.Exit(1)
+	}
 
-	// Set the clock speed based on input.
-	c.SetClockSpeed(ClockSpeed)
+	scaling, err := ui.ParseScaling(ScalingArg)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	effects, err := ui.ParseEffects(EffectsArg)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	textMode, err := ui.ParseTextMode(TextModeArg)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
 
-	// Run the CPU.
-	c.Run()
+	filter, err := loadPhosphor()
+	if err != nil {
+		fmt.Println("Could not load phosphor settings: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Get a UI object, and something to play the buzzer with.
+	u := ui.GetUI(UIMode)
+	ui.SetKeymap(u, keymap)
+	ui.SetPalette(u, palette)
+	ui.SetTextMode(u, textMode)
+	ui.SetVideo(u, ui.VideoOptions{Scaling: scaling, Grid: Grid, Fullscreen: Fullscreen, Effects: effects})
+	audio, err := openAudio(u)
+	if err != nil {
+		u.Shutdown()
+		fmt.Println("Could not open audio: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Create a new machine, with the clock speed based on input.
+	m := chip8.New(rom, chip8.ClockSpeed(ClockSpeed))
+	c := m.CPU()
+
+	// Attach a tracer and profiler if they were asked for.
+	finish := attachTracers(c, rom)
+
+	// Run the machine. The UI is driven from here, on the main thread.
+	err = chip8.Run(m, ui.Host{UI: u, Audio: audio, Phosphor: filter})
+
+	if audio != nil {
+		audio.Close()
+	}
+	u.Shutdown()
+	finish()
+
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
+
+// loadPalette returns the palette chosen by -palette.
+func loadPalette() (ui.Palette, error) {
+	if PaletteArg == "" {
+		return ui.DefaultPalette, nil
+	}
+	return ui.FindPalette(PaletteArg)
+}
+
+// loadPhosphor returns the filter chosen by -phosphor, or kept next to the
+// ROM, or nil if frames shouldn't be blended.
+func loadPhosphor() (*phosphor.Filter, error) {
+	var s phosphor.Settings
+	if PhosphorArg != "" {
+		var err error
+		if s, err = phosphor.Parse(PhosphorArg); err != nil {
+			return nil, err
+		}
+	} else if RomFile != "" {
+		var err error
+		if s, _, err = phosphor.ROMSettings(RomFile); err != nil {
+			return nil, err
+		}
+	}
+	if s.Mode == phosphor.Off {
+		return nil, nil
+	}
+	return phosphor.NewFilter(s), nil
+}
+
+// openAudio opens the audio drivers chosen by -audio, or returns nil if the
+// buzzer shouldn't make a sound. It must be called after the UI has been set
+// up.
+func openAudio(u ui.UI) (ui.Audio, error) {
+	tone, err := buzzerTone()
+	if err != nil {
+		return nil, err
+	}
+
+	mode := AudioMode
+	if mode == "auto" {
+		switch u.(type) {
+		case *ui.Sdl:
+			mode = "sdl"
+		case *ui.Termbox:
+			mode = "bell"
+		default:
+			mode = "none"
+		}
+	}
+
+	var audios ui.Audios
+	for _, name := range strings.Split(mode, ",") {
+		switch strings.TrimSpace(name) {
+		case "sdl":
+			a, err := ui.NewSdlAudio(tone)
+			if err != nil {
+				audios.Close()
+				return nil, err
+			}
+			audios = append(audios, a)
+		case "bell":
+			audios = append(audios, ui.Bell{})
+		case "flash":
+			t, ok := u.(*ui.Termbox)
+			if !ok {
+				audios.Close()
+				return nil, fmt.Errorf("flash only works with the termbox UI")
+			}
+			audios = append(audios, ui.Flash{UI: t})
+		case "none":
+		default:
+			audios.Close()
+			return nil, fmt.Errorf("unknown audio driver %q", name)
+		}
+	}
+
+	switch len(audios) {
+	case 0:
+		return nil, nil
+	case 1:
+		return audios[0], nil
+	}
+	return audios, nil
+}
+
+// buzzerTone returns the tone set by -pitch, -volume and -waveform.
+func buzzerTone() (ui.Tone, error) {
+	waveform, err := ui.ParseWaveform(WaveformArg)
+	if err != nil {
+		return ui.Tone{}, err
+	}
+	tone := ui.Tone{Pitch: Pitch, Volume: Volume, Waveform: waveform}
+	return tone, tone.Validate()
+}
+
+// attachTracers attaches the tracers asked for by the -trace, -profile,
+// -heatmap, -coverage and -audio-out flags to the CPU. The returned function
+// writes out their results once the CPU has stopped.
+func attachTracers(c *cpu.Cpu, rom []byte) func() {
+	if Debug && TraceFile == "" {
+		TraceFile = "-"
+	}
+	var tracers cpu.Tracers
+	var sink trace.Sink
+	var traceFile *os.File
+	if TraceFile != "" {
+		var err error
+		sink, traceFile, err = openTrace()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		tracers = append(tracers, sink)
+	}
+	var prof *profile.Profiler
+	if ProfileFile != "" || HeatmapFile != "" {
+		prof = profile.New(c.ClockSpeed)
+		tracers = append(tracers, prof)
+	}
+	var cov *coverage.Coverage
+	if CoverFile != "" {
+		cov = coverage.New()
+		tracers = append(tracers, cov)
+	}
+	var rec *ui.Recorder
+	if AudioOut != "" {
+		tone, err := buzzerTone()
+		if err != nil {
+			fmt.Println("Could not record audio: " + err.Error())
+			os.Exit(1)
+		}
+		rec = ui.NewRecorder(tone)
+		rec.Attach(c)
+	}
+	if len(tracers) == 1 {
+		c.Tracer = tracers[0]
+	} else if len(tracers) > 1 {
+		c.Tracer = tracers
+	}
+
+	return func() {
+		if sink != nil {
+			if err := sink.Flush(); err != nil {
+				fmt.Println("Could not write trace: " + err.Error())
+			}
+		}
+		if traceFile != nil {
+			if err := traceFile.Close(); err != nil {
+				fmt.Println("Could not write trace: " + err.Error())
+			}
+		}
+		if prof != nil {
+			if err := writeProfile(prof); err != nil {
+				fmt.Println("Could not write profile: " + err.Error())
+			}
+		}
+		if cov != nil {
+			cov.Flush(c.PC)
+			if err := writeCoverage(cov, rom); err != nil {
+				fmt.Println("Could not write coverage report: " + err.Error())
+			}
+		}
+		if rec != nil {
+			rec.Finish(c.Frames)
+			if err := writeAudio(rec); err != nil {
+				fmt.Println("Could not write audio: " + err.Error())
+			}
+		}
+	}
+}
+
+// writeAudio writes the WAV file asked for by -audio-out.
+func writeAudio(rec *ui.Recorder) error {
+	f, err := os.Create(AudioOut)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return rec.WriteWAV(f)
+}
+
+// writeCoverage writes the report asked for by -coverage.
+func writeCoverage(cov *coverage.Coverage, rom []byte) error {
+	var files []*coverage.File
+	if CoverSrcmap != "" {
+		m, err := srcmap.Load(CoverSrcmap)
+		if err != nil {
+			return err
+		}
+		files, err = cov.Source(rom, m, filepath.Dir(CoverSrcmap), ioutil.ReadFile)
+		if err != nil {
+			return err
+		}
+	} else {
+		files = []*coverage.File{cov.Disassembly(filepath.Base(RomFile), rom)}
+	}
+
+	f, err := os.Create(CoverFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if strings.HasSuffix(CoverFile, ".html") {
+		return coverage.WriteHTML(f, files)
+	}
+	return coverage.WriteText(f, files)
+}
+
+// writeProfile writes the files asked for by -profile and -heatmap.
+func writeProfile(p *profile.Profiler) error {
+	if ProfileFile != "" {
+		f, err := os.Create(ProfileFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := p.WritePprof(f, nil); err != nil {
+			return err
+		}
+	}
+
+	if HeatmapFile != "" {
+		f, err := os.Create(HeatmapFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if strings.HasSuffix(HeatmapFile, ".html") {
+			return p.WriteHeatmapHTML(f)
+		}
+		if err := p.WriteHeatmap(f); err != nil {
+			return err
+		}
+		fmt.Fprintln(f)
+		p.WriteReport(f, 20)
+	}
+	return nil
+}
+
+// openTrace creates a trace sink from the -trace flags, along with the file
+// it writes to, which is nil for standard output.
+func openTrace() (trace.Sink, *os.File, error) {
+	if TraceFile == "-" {
+		sink, err := newTraceSink(os.Stdout)
+		return sink, nil, err
+	}
+
+	f, err := os.Create(TraceFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not create trace file: %s", err.Error())
+	}
+	sink, err := newTraceSink(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return sink, f, nil
+}
+
+// newTraceSink returns a sink writing to w in the format and with the filters
+// chosen by -trace-format, -trace-range and -trace-ops.
+func newTraceSink(w io.Writer) (trace.Sink, error) {
+	sink, err := trace.New(TraceFormat, w)
+	if err != nil {
+		return nil, err
+	}
+
+	if TraceRange == "" && TraceOps == "" {
+		return sink, nil
+	}
+
+	f := &trace.Filtered{Sink: sink, Filter: trace.AllAddresses()}
+	if TraceRange != "" {
+		if err := f.Filter.ParseRange(TraceRange); err != nil {
+			return nil, err
+		}
+	}
+	if TraceOps != "" {
+		if err := f.Filter.ParseClasses(TraceOps); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
 }
 
 func loadRom(filename string) ([]byte, error) {
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/headless"
+	"github.com/cweagans/chip8/pkg/phosphor"
+)
+
+// runCommand implements `chip8 run [flags] rom.ch8`. It accepts all of the
+// emulator's usual flags, and with -headless runs the ROM for a fixed number
+// of frames without a display. The exit status is 1 if the CPU faulted.
+func runCommand(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	headlessMode := fs.Bool("headless", false, "Run without a display, as fast as possible, for -frames frames.")
+	frames := fs.Int("frames", 600, "Number of 60 Hz frames to run in headless mode.")
+	keysFile := fs.String("keys", "", "Key script to play back in headless mode.")
+	pngFile := fs.String("png", "", "Write the final display to this file as a PNG image.")
+	scale := fs.Int("scale", 8, "Size of each pixel in the PNG image.")
+	textFile := fs.String("text", "", "Write the final display to this file as text (- for stdout).")
+	stateFile := fs.String("state", "", "Write the final memory and register state to this file as JSON (- for stdout).")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: chip8 run [flags] rom.ch8")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 1 {
+		RomFile = fs.Arg(0)
+	}
+	if RomFile == "" || fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	rom, err := loadRom(RomFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not open specified ROM file: "+err.Error())
+		os.Exit(2)
+	}
+
+	if !*headlessMode {
+		// Without -headless this is the same as running chip8 -rom.
+		runRom(rom)
+		return
+	}
+
+	var keys headless.KeyScript
+	if *keysFile != "" {
+		keys, err = headless.LoadKeyScript(*keysFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not read key script: "+err.Error())
+			os.Exit(2)
+		}
+	}
+
+	palette, err := loadPalette()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load palette: "+err.Error())
+		os.Exit(2)
+	}
+
+	filter, err := loadPhosphor()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not load phosphor settings: "+err.Error())
+		os.Exit(2)
+	}
+
+	// The phosphor filter sees every frame, so that the final image shows
+	// what would have been on screen.
+	var shades phosphor.Shades
+	var each func(int, [32]int64)
+	if filter != nil {
+		each = func(_ int, vram [32]int64) {
+			shades = filter.Apply(vram)
+		}
+	}
+
+	c := cpu.NewCpu(nil, rom)
+	c.SetClockSpeed(ClockSpeed)
+	finish := attachTracers(c, rom)
+	result := headless.RunEach(c, *frames, keys, each)
+	finish()
+
+	if *pngFile != "" {
+		err = writeOutput(*pngFile, func(f *os.File) error {
+			if filter != nil {
+				return png.Encode(f, headless.ShadeImage(shades, *scale, palette.Shade))
+			}
+			if PaletteArg == "" {
+				return headless.WritePNG(f, c.Vram, *scale)
+			}
+			return png.Encode(f, headless.PaletteImage(c.Vram, *scale, palette.ColorPalette()))
+		})
+	}
+	if err == nil && *textFile != "" {
+		err = writeOutput(*textFile, func(f *os.File) error {
+			return headless.WriteText(f, c.Vram)
+		})
+	}
+	if err == nil && *stateFile != "" {
+		err = writeOutput(*stateFile, func(f *os.File) error {
+			return json.NewEncoder(f).Encode(c.State())
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+
+	if result.Err != nil {
+		fmt.Fprintf(os.Stderr, "Fault after %d frames: %s\n", result.Frames, result.Err.Error())
+		os.Exit(1)
+	}
+}
+
+// writeOutput creates the named file, or uses stdout for -, and passes it to
+// write.
+func writeOutput(filename string, write func(*os.File) error) error {
+	if filename == "-" {
+		return write(os.Stdout)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
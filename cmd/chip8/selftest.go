@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cweagans/chip8/pkg/selftest"
+)
+
+// runSelftest implements `chip8 selftest`.
+func runSelftest(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: chip8 selftest")
+		fmt.Fprintln(os.Stderr, "Runs the built-in conformance programs under every quirks profile.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	failed := 0
+	results := selftest.RunAll()
+	for _, r := range results {
+		fmt.Println(r)
+		if r.Status != selftest.Pass {
+			failed++
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/cweagans/chip8/pkg/trace"
+)
+
+// runTraceDiff implements `chip8 tracediff [flags] ours.trace reference.trace`.
+func runTraceDiff(args []string) {
+	fs := flag.NewFlagSet("tracediff", flag.ExitOnError)
+	context := fs.Int("context", 5, "Number of instructions to show either side of the first difference.")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: chip8 tracediff [flags] ours.trace reference.trace")
+		fmt.Fprintln(os.Stderr, "Traces may be json, binary or common format; text traces can't be compared.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ours, err := readTrace(fs.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(2)
+	}
+	theirs, err := readTrace(fs.Arg(1))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(2)
+	}
+
+	m := trace.Diff(ours, theirs)
+	if m == nil {
+		fmt.Printf("Traces match (%d instructions)\n", len(ours))
+		return
+	}
+	trace.WriteReport(os.Stdout, ours, theirs, m, *context)
+	os.Exit(1)
+}
+
+func readTrace(filename string) ([]trace.Record, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := trace.ReadRecords(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	return records, nil
+}
@@ -0,0 +1,81 @@
+package chip8
+
+import "time"
+
+// Host is a frontend that a machine runs under with Run.
+type Host interface {
+	// Frame shows the display. It's called for the first frame and whenever
+	// the display changes after that.
+	Frame(fb Framebuffer)
+
+	// Sound starts or stops the buzzer.
+	Sound(on bool)
+
+	// Input returns the keys that are held down, and whether the user has
+	// asked to quit. It must not block.
+	Input() (keys [16]bool, quit bool)
+}
+
+// Animator is a Host that can keep changing what it shows while the display
+// stays the same, e.g. by fading out pixels that have gone dark. Run calls
+// Frame every frame while Animating returns true.
+type Animator interface {
+	Animating() bool
+}
+
+// Run runs the machine at FrameRate frames per second until the program
+// halts, the CPU faults or the host asks to quit. It returns nil unless the
+// CPU faulted.
+//
+// The host is only ever called from the goroutine that called Run, so hosts
+// that must stay on the main OS thread, such as SDL, work as long as Run is
+// called from main.
+func Run(m *Machine, h Host) error {
+	ticker := time.NewTicker(time.Second / FrameRate)
+	defer ticker.Stop()
+
+	var shown Framebuffer
+	drawn := false
+	sound := false
+	defer func() {
+		if sound {
+			h.Sound(false)
+		}
+	}()
+
+	for range ticker.C {
+		keys, quit := h.Input()
+		if quit {
+			return nil
+		}
+		for k, down := range keys {
+			m.SetKey(uint8(k), down)
+		}
+
+		err := m.StepFrame()
+
+		if fb := m.Framebuffer(); !drawn || fb != shown || animating(h) {
+			h.Frame(fb)
+			shown = fb
+			drawn = true
+		}
+		if on := m.SoundActive(); on != sound {
+			h.Sound(on)
+			sound = on
+		}
+
+		if err == ErrHalted {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// animating reports whether the host wants to be shown every frame.
+func animating(h Host) bool {
+	a, ok := h.(Animator)
+	return ok && a.Animating()
+}
@@ -0,0 +1,93 @@
+package chip8
+
+import (
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// testHost records what Run shows it, and asks to quit after a number of
+// frames.
+type testHost struct {
+	frames  []Framebuffer
+	sound   []bool
+	polls   int
+	quitAt  int
+	keyDown uint8
+}
+
+func (h *testHost) Frame(fb Framebuffer) { h.frames = append(h.frames, fb) }
+func (h *testHost) Sound(on bool)        { h.sound = append(h.sound, on) }
+
+func (h *testHost) Input() (keys [16]bool, quit bool) {
+	h.polls++
+	keys[h.keyDown] = true
+	return keys, h.polls > h.quitAt
+}
+
+// Test that Run shows frames only when the display changes, reports the
+// sound starting and stopping, passes keys in and stops when asked to.
+func TestRun(t *testing.T) {
+	assert := asrt.New(t)
+
+	m := New(drawRom)
+	h := &testHost{quitAt: 12, keyDown: 0x3}
+	assert.Nil(Run(m, h))
+
+	assert.Equal(13, h.polls)
+	assert.Len(h.frames, 2)
+	assert.False(h.frames[0].Pixel(5, 0))
+	assert.True(h.frames[1].Pixel(5, 0))
+	assert.Equal([]bool{true, false}, h.sound)
+	assert.Equal(uint8(1), m.State().Keys[0x3])
+}
+
+// fadingHost is a testHost that animates for a number of frames after each
+// change.
+type fadingHost struct {
+	testHost
+	fade int
+	left int
+}
+
+func (h *fadingHost) Frame(fb Framebuffer) {
+	if len(h.frames) == 0 || h.frames[len(h.frames)-1] != fb {
+		h.left = h.fade
+	} else if h.left > 0 {
+		h.left--
+	}
+	h.testHost.Frame(fb)
+}
+
+func (h *fadingHost) Animating() bool { return h.left > 0 }
+
+// Test that Run keeps showing frames to hosts that are animating.
+func TestRunAnimator(t *testing.T) {
+	assert := asrt.New(t)
+
+	h := &fadingHost{testHost: testHost{quitAt: 12, keyDown: 0x3}, fade: 3}
+	assert.Nil(Run(New(drawRom), h))
+	// The blank display is shown until the pixel is drawn by the fourth
+	// instruction, and then the lit one until it has finished animating.
+	var lit []bool
+	for _, fb := range h.frames {
+		lit = append(lit, fb.Pixel(5, 0))
+	}
+	assert.Equal([]bool{false, false, false, true, true, true, true}, lit)
+}
+
+// Test that Run returns when the program halts or faults, and turns the
+// sound off on the way out.
+func TestRunStops(t *testing.T) {
+	assert := asrt.New(t)
+
+	h := &testHost{quitAt: 100}
+	assert.Nil(Run(New(nil), h))
+	assert.Equal(1, h.polls)
+
+	// LD V0, 9; LD ST, V0; RET with an empty stack.
+	h = &testHost{quitAt: 100}
+	assert.NotNil(Run(New([]byte{0x60, 0x09, 0xF0, 0x18, 0x00, 0xEE}), h))
+	assert.Equal(3, h.polls)
+	assert.Equal([]bool{true, false}, h.sound)
+}
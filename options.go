@@ -0,0 +1,34 @@
+package chip8
+
+import (
+	"math/rand"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// Option configures a machine created by New.
+type Option func(*Machine)
+
+// ClockSpeed sets the number of instructions run per second (60 by default).
+// StepFrame runs a sixtieth of them, or none in some frames when that isn't
+// a whole number.
+func ClockSpeed(hz int) Option {
+	return func(m *Machine) {
+		m.cpu.SetClockSpeed(hz)
+	}
+}
+
+// Random sets the source of random numbers for CXNN, e.g. to make runs
+// repeatable. The math/rand package's default source is used otherwise.
+func Random(r *rand.Rand) Option {
+	return func(m *Machine) {
+		m.cpu.Random = r
+	}
+}
+
+// Tracer attaches a tracer that sees every instruction the machine runs.
+func Tracer(t cpu.Tracer) Option {
+	return func(m *Machine) {
+		m.cpu.Tracer = t
+	}
+}
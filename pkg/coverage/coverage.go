@@ -0,0 +1,220 @@
+// Package coverage records which instructions of a ROM were executed, and
+// which way each conditional skip went, and reports the result against a
+// disassembly or the assembler source given by a source map.
+package coverage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/srcmap"
+)
+
+// Coverage is a cpu.Tracer that counts executions per address. For skip
+// instructions (3XNN, 4XNN, 5XY0, 9XY0, EX9E and EXA1) it also counts how
+// often the skip was taken, which is only known once the next instruction
+// runs.
+type Coverage struct {
+	Hits     [4096]uint64
+	Taken    [4096]uint64
+	NotTaken [4096]uint64
+
+	// pending is the address of the last skip instruction, or -1.
+	pending int
+}
+
+// New returns an empty coverage collector.
+func New() *Coverage {
+	return &Coverage{pending: -1}
+}
+
+func (c *Coverage) Trace(e *cpu.TraceEvent) {
+	pc := e.PC & 0x0FFF
+	c.Flush(pc)
+
+	c.Hits[pc]++
+	if IsSkip(e.Op) {
+		c.pending = int(pc)
+	}
+}
+
+// Flush counts which way the last skip went if no instruction has run since,
+// given the address the CPU went on to. Call it with the CPU's PC once the
+// program has stopped, so that a skip just before a halt or fault counts.
+func (c *Coverage) Flush(pc uint16) {
+	if c.pending < 0 {
+		return
+	}
+	if pc&0x0FFF == uint16(c.pending+4)&0x0FFF {
+		c.Taken[c.pending]++
+	} else {
+		c.NotTaken[c.pending]++
+	}
+	c.pending = -1
+}
+
+// IsSkip reports whether op conditionally skips the next instruction.
+func IsSkip(op uint16) bool {
+	switch op & 0xF000 {
+	case 0x3000, 0x4000:
+		return true
+	case 0x5000, 0x9000:
+		return op&0x000F == 0
+	case 0xE000:
+		return op&0x00FF == 0x9E || op&0x00FF == 0xA1
+	}
+	return false
+}
+
+// Line is a single line of a report.
+type Line struct {
+	Number int
+	Text   string
+
+	// Instructions is the number of instructions assembled from the line,
+	// and Covered how many of them were executed. Lines without
+	// instructions aren't code.
+	Instructions int
+	Covered      int
+	// Hits is the execution count of the most executed instruction.
+	Hits uint64
+
+	// Branches counts both directions of every skip on the line, and
+	// BranchesCovered the directions that were exercised.
+	Branches        int
+	BranchesCovered int
+	Taken           uint64
+	NotTaken        uint64
+}
+
+// File is the coverage report for a single listing or source file.
+type File struct {
+	Name  string
+	Lines []Line
+}
+
+// Totals summarises the coverage of one or more files.
+type Totals struct {
+	Instructions    int
+	Covered         int
+	Branches        int
+	BranchesCovered int
+}
+
+func (t Totals) String() string {
+	return fmt.Sprintf("instructions: %d/%d (%s), branches: %d/%d (%s)",
+		t.Covered, t.Instructions, percent(t.Covered, t.Instructions),
+		t.BranchesCovered, t.Branches, percent(t.BranchesCovered, t.Branches))
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+}
+
+// Sum adds up the coverage of the files.
+func Sum(files []*File) Totals {
+	var t Totals
+	for _, f := range files {
+		for _, l := range f.Lines {
+			t.Instructions += l.Instructions
+			t.Covered += l.Covered
+			t.Branches += l.Branches
+			t.BranchesCovered += l.BranchesCovered
+		}
+	}
+	return t
+}
+
+// Disassembly reports coverage against a disassembly of the ROM, one line per
+// instruction.
+func (c *Coverage) Disassembly(name string, rom []byte) *File {
+	text, m := srcmap.Listing(name, rom)
+	return c.annotate(name, text, m.Entries, rom)
+}
+
+// Source reports coverage against the assembler source files named in the
+// source map. readFile is used to load them, with paths resolved relative to
+// dir.
+func (c *Coverage) Source(rom []byte, m *srcmap.Map, dir string, readFile func(string) ([]byte, error)) ([]*File, error) {
+	byFile := map[string][]srcmap.Entry{}
+	for _, e := range m.Entries {
+		byFile[e.File] = append(byFile[e.File], e)
+	}
+
+	names := make([]string, 0, len(byFile))
+	for name := range byFile {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var files []*File
+	for _, name := range names {
+		path := name
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		text, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, c.annotate(name, string(text), byFile[name], rom))
+	}
+	return files, nil
+}
+
+// annotate attaches the counts for the given entries to the lines of text.
+func (c *Coverage) annotate(name, text string, entries []srcmap.Entry, rom []byte) *File {
+	byLine := map[int][]uint16{}
+	for _, e := range entries {
+		byLine[e.Line] = append(byLine[e.Line], e.Address)
+	}
+
+	f := &File{Name: name}
+	for i, t := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		l := Line{Number: i + 1, Text: t}
+		for _, addr := range byLine[l.Number] {
+			l.Instructions++
+			hits := c.Hits[addr&0x0FFF]
+			if hits > 0 {
+				l.Covered++
+			}
+			if hits > l.Hits {
+				l.Hits = hits
+			}
+
+			if IsSkip(opAt(rom, addr)) {
+				taken, notTaken := c.Taken[addr&0x0FFF], c.NotTaken[addr&0x0FFF]
+				l.Branches += 2
+				l.Taken += taken
+				l.NotTaken += notTaken
+				if taken > 0 {
+					l.BranchesCovered++
+				}
+				if notTaken > 0 {
+					l.BranchesCovered++
+				}
+			}
+		}
+		f.Lines = append(f.Lines, l)
+	}
+	return f
+}
+
+// opAt returns the opcode at addr in a ROM loaded at 0x200.
+func opAt(rom []byte, addr uint16) uint16 {
+	i := int(addr) - 0x200
+	if i < 0 || i >= len(rom) {
+		return 0
+	}
+	op := uint16(rom[i]) << 8
+	if i+1 < len(rom) {
+		op |= uint16(rom[i+1])
+	}
+	return op
+}
@@ -0,0 +1,123 @@
+package coverage
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/srcmap"
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// 0x200: LD V0, 0x05
+// 0x202: SE V0, 0x05
+// 0x204: LD V1, 0x01
+// 0x206: SNE V0, 0x05
+// 0x208: JP 0x208
+var testRom = []byte{0x60, 0x05, 0x30, 0x05, 0x61, 0x01, 0x40, 0x05, 0x12, 0x08}
+
+func collect(t *testing.T) *Coverage {
+	cov := New()
+	c := cpu.NewCpu(nil, testRom)
+	c.Tracer = cov
+	for i := 0; i < 6; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cov
+}
+
+// Test that instructions and skip directions are counted.
+func TestCoverage(t *testing.T) {
+	assert := asrt.New(t)
+
+	cov := collect(t)
+	assert.Equal(uint64(1), cov.Hits[0x200])
+	assert.Equal(uint64(0), cov.Hits[0x204])
+	assert.Equal(uint64(3), cov.Hits[0x208])
+	assert.Equal(uint64(1), cov.Taken[0x202])
+	assert.Equal(uint64(0), cov.NotTaken[0x202])
+	assert.Equal(uint64(0), cov.Taken[0x206])
+	assert.Equal(uint64(1), cov.NotTaken[0x206])
+
+	assert.True(IsSkip(0x9120))
+	assert.False(IsSkip(0x9121))
+	assert.True(IsSkip(0xE1A1))
+	assert.False(IsSkip(0xE1A2))
+}
+
+// Test that a skip is counted when the program stops right after it, and
+// when it skips past the end of memory.
+func TestCoverageFlush(t *testing.T) {
+	assert := asrt.New(t)
+
+	// LD V0, 0x05; SE V0, 0x05; LD V1, 0x01; then nothing, which halts.
+	cov := New()
+	c := cpu.NewCpu(nil, []byte{0x60, 0x05, 0x30, 0x05, 0x61, 0x01})
+	c.Tracer = cov
+	for !c.ShouldHalt {
+		assert.NoError(c.Step())
+	}
+	assert.Equal(uint64(0), cov.Taken[0x202])
+	cov.Flush(c.PC)
+	assert.Equal(uint64(1), cov.Taken[0x202])
+	cov.Flush(c.PC)
+	assert.Equal(uint64(1), cov.Taken[0x202])
+
+	cov = New()
+	cov.Trace(&cpu.TraceEvent{PC: 0xFFE, Op: 0x3000})
+	cov.Trace(&cpu.TraceEvent{PC: 0x002, Op: 0x6000})
+	assert.Equal(uint64(1), cov.Taken[0xFFE])
+	assert.Equal(uint64(0), cov.NotTaken[0xFFE])
+}
+
+// Test the report against a disassembly.
+func TestDisassembly(t *testing.T) {
+	assert := asrt.New(t)
+
+	f := collect(t).Disassembly("test.ch8", testRom)
+	assert.Equal(Totals{Instructions: 5, Covered: 4, Branches: 4, BranchesCovered: 2}, Sum([]*File{f}))
+
+	buf := &bytes.Buffer{}
+	assert.NoError(WriteText(buf, []*File{f}))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal("test.ch8: instructions: 4/5 (80.0%), branches: 2/4 (50.0%)", lines[0])
+	assert.Equal("        1:    2:0x202  3005  SE V0, 0x05  [taken 1, not taken 0]", lines[2])
+	assert.Equal("    #####:    3:0x204  6101  LD V1, 0x01", lines[3])
+	assert.Equal("        3:    5:0x208  1208  JP 0x208", lines[5])
+
+	buf.Reset()
+	assert.NoError(WriteHTML(buf, []*File{f}))
+	assert.Contains(buf.String(), `<tr class="partial"><td class="num">2</td>`)
+	assert.Contains(buf.String(), `<tr class="missed"><td class="num">3</td>`)
+	assert.Contains(buf.String(), `<tr class="covered"><td class="num">5</td>`)
+}
+
+// Test the report against assembler source.
+func TestSource(t *testing.T) {
+	assert := asrt.New(t)
+
+	m, err := srcmap.Parse(strings.NewReader("0x200 game.asm:2\n0x202 game.asm:3\n0x204 game.asm:4\n0x206 game.asm:6\n0x208 game.asm:7\n"))
+	assert.NoError(err)
+	source := "; test\nld v0, 5\nse v0, 5\nld v1, 1\nloop:\nsne v0, 5\njp loop\n"
+
+	files, err := collect(t).Source(testRom, m, "src", func(path string) ([]byte, error) {
+		if path != "src/game.asm" {
+			return nil, fmt.Errorf("unexpected path %q", path)
+		}
+		return []byte(source), nil
+	})
+	assert.NoError(err)
+	assert.Len(files, 1)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(WriteText(buf, files))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal("        -:    1:; test", lines[1])
+	assert.Equal("    #####:    4:ld v1, 1", lines[4])
+	assert.Equal("        -:    5:loop:", lines[5])
+	assert.Equal("        1:    6:sne v0, 5  [taken 0, not taken 1]", lines[6])
+}
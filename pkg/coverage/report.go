@@ -0,0 +1,104 @@
+package coverage
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+)
+
+// WriteText writes the files in the style of gcov: each line is prefixed by
+// its execution count, "#####" if it was never executed, or "-" if it isn't
+// code. Lines with skips also show how often the skip was taken.
+func WriteText(w io.Writer, files []*File) error {
+	for _, f := range files {
+		fmt.Fprintf(w, "%s: %s\n", f.Name, Sum([]*File{f}))
+		for _, l := range f.Lines {
+			count := "-"
+			if l.Instructions > 0 {
+				count = "#####"
+				if l.Covered > 0 {
+					count = fmt.Sprint(l.Hits)
+				}
+			}
+			line := fmt.Sprintf("%9s:%5d:%s", count, l.Number, l.Text)
+			if l.Branches > 0 {
+				line += fmt.Sprintf("  [taken %d, not taken %d]", l.Taken, l.NotTaken)
+			}
+			fmt.Fprintln(w, line)
+		}
+		fmt.Fprintln(w)
+	}
+	_, err := fmt.Fprintf(w, "Total: %s\n", Sum(files))
+	return err
+}
+
+type htmlLine struct {
+	Line
+	Class  string
+	Count  string
+	Branch string
+}
+
+type htmlFile struct {
+	Name   string
+	Totals Totals
+	Lines  []htmlLine
+}
+
+var htmlTemplate = template.Must(template.New("coverage").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CHIP-8 coverage</title>
+<style>
+body { font-family: monospace; }
+table { border-collapse: collapse; }
+td { padding: 0 8px; white-space: pre; }
+td.count, td.num { text-align: right; color: #666; }
+tr.covered { background: #dfd; }
+tr.partial { background: #ffd; }
+tr.missed { background: #fdd; }
+</style>
+</head>
+<body>
+<p>Total: {{.Totals}}</p>
+{{range .Files}}<h2>{{.Name}}</h2>
+<p>{{.Totals}}</p>
+<table>
+{{range .Lines}}<tr class="{{.Class}}"><td class="num">{{.Number}}</td><td class="count">{{.Count}}</td><td>{{.Text}}</td><td>{{.Branch}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes the files as an HTML page. Executed lines are green, lines
+// that were never executed are red, and lines with an instruction or skip
+// direction that wasn't exercised are yellow.
+func WriteHTML(w io.Writer, files []*File) error {
+	var hfs []htmlFile
+	for _, f := range files {
+		hf := htmlFile{Name: f.Name, Totals: Sum([]*File{f})}
+		for _, l := range f.Lines {
+			hl := htmlLine{Line: l}
+			switch {
+			case l.Instructions == 0:
+			case l.Covered == 0:
+				hl.Class, hl.Count = "missed", "0"
+			case l.Covered < l.Instructions || l.BranchesCovered < l.Branches:
+				hl.Class, hl.Count = "partial", fmt.Sprint(l.Hits)
+			default:
+				hl.Class, hl.Count = "covered", fmt.Sprint(l.Hits)
+			}
+			if l.Branches > 0 {
+				hl.Branch = fmt.Sprintf("taken %d, not taken %d", l.Taken, l.NotTaken)
+			}
+			hf.Lines = append(hf.Lines, hl)
+		}
+		hfs = append(hfs, hf)
+	}
+
+	return htmlTemplate.Execute(w, struct {
+		Totals Totals
+		Files  []htmlFile
+	}{Sum(files), hfs})
+}
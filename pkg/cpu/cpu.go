@@ -3,15 +3,23 @@ package cpu
 import (
 	"fmt"
 	"math/rand"
-	"time"
-
-	"github.com/cweagans/chip8/pkg/ui"
 )
 
+// FrameRate is the number of frames per emulated second. The timers count
+// down once a frame.
+const FrameRate = 60
+
+// Display shows the contents of Vram while a tool such as the debugger is
+// stepping the CPU. Any ui.UI will do. Frontends that run programs normally
+// use the chip8 package instead, which leaves drawing to the host.
+type Display interface {
+	Draw([32]int64)
+}
+
 // Cpu is the core model of the system.
 type Cpu struct {
 	// Vram          [64 * 32]bool
-	UI            ui.UI
+	UI            Display
 	Vram          [32]int64
 	ShouldDraw    bool
 	ClockSpeed    int
@@ -21,14 +29,60 @@ type Cpu struct {
 	ShouldHalt    bool
 	Stack         [16]uint16
 	StackPointer  int
-	Debug         bool
 	Registers     [16]uint8
 	IndexRegister uint16
 	DelayTimer    uint8
 	SoundTimer    uint8
 	Keys          [16]uint8
+	Cycles        uint64
+	Tracer        Tracer
+
+	// Frames is the number of frames started by StartFrame. Unlike Cycles,
+	// Reset leaves it alone, so it always measures emulated time.
+	Frames uint64
+
+	// Quirks choose between the behaviours of different interpreters.
+	// NewCpu sets them to VIPQuirks.
+	Quirks Quirks
+
+	// Random is the source of random numbers for CXNN. If it is nil, the
+	// math/rand package's default source is used.
+	Random *rand.Rand
+
+	// memoryWrites collects the writes made by the current instruction while
+	// a tracer or a MemoryWritten subscriber is attached.
+	memoryWrites []MemoryWrite
+
+	// clockCarry is the part of a frame's instructions that StartFrame
+	// hasn't run yet, and soundOn is whether SoundStarted was last reported.
+	clockCarry int
+	soundOn    bool
+
+	// subscribers are the functions registered with Subscribe, and events
+	// is the set of kinds they have asked for.
+	subscribers    []subscriber
+	nextSubscriber int
+	events         EventKind
 }
 
+// Quirks are the behaviours that differ between CHIP-8 interpreters, for
+// programs that depend on one or the other.
+type Quirks struct {
+	// VFReset makes 8XY1, 8XY2 and 8XY3 reset VF to 0, as a side effect of
+	// how the COSMAC VIP ran them. Later interpreters leave VF alone.
+	VFReset bool
+	// IncrementI makes FX55 and FX65 leave I just past the last register
+	// they store or load, as the COSMAC VIP does. SUPER-CHIP leaves I alone.
+	IncrementI bool
+}
+
+// VIPQuirks are the quirks of the original COSMAC VIP interpreter.
+var VIPQuirks = Quirks{VFReset: true, IncrementI: true}
+
+// SuperChipQuirks are the quirks of SUPER-CHIP 1.1, which most games written
+// for the HP48 calculators expect.
+var SuperChipQuirks = Quirks{}
+
 // UnknownOpcodeError is returned when the CPU encounters an opcode that it does
 // not know how to process.
 type UnknownOpcodeError struct {
@@ -40,16 +94,30 @@ func (uoe *UnknownOpcodeError) Error() string {
 	return fmt.Sprintf("Unknown opcode 0x%X at address 0x%X", uoe.Opcode, uoe.Address)
 }
 
+// StackError is returned when a subroutine call overflows the stack, or a
+// return is made with an empty stack.
+type StackError struct {
+	Address  uint16
+	Overflow bool
+}
+
+func (se *StackError) Error() string {
+	if se.Overflow {
+		return fmt.Sprintf("Stack overflow at address 0x%X", se.Address)
+	}
+	return fmt.Sprintf("Stack underflow at address 0x%X", se.Address)
+}
+
 // NewCpu() sets up a new CPU and loads the rom into memory.
-func NewCpu(u ui.UI, r []byte, debug bool) *Cpu {
+func NewCpu(u Display, r []byte) *Cpu {
 	cpu := &Cpu{}
 	cpu.UI = u
 	cpu.PC = 0x200
 	cpu.ShouldDraw = false
 	cpu.ShouldHalt = false
-	cpu.Debug = debug
 	cpu.ClockSpeed = 60
 	cpu.IndexRegister = 0x0000
+	cpu.Quirks = VIPQuirks
 
 	cpu.LoadRom(r)
 
@@ -68,10 +136,9 @@ func (c *Cpu) LoadRom(r []byte) {
 		c.Memory[m] = 0x00
 	}
 
-	// Copy program into memory starting at 0x200.
-	for index, b := range r {
-		c.Memory[index+0x200] = b
-	}
+	// Copy program into memory starting at 0x200. Anything that doesn't fit
+	// is dropped.
+	copy(c.Memory[0x200:], r)
 }
 
 // Clear Vram.
@@ -83,49 +150,114 @@ func (c *Cpu) ClearVram() {
 	c.ShouldDraw = true
 }
 
-// Runs the CPU until halted.
-func (c *Cpu) Run() {
-
-	// c.ClockSpeed defaults to 60 Hz, but this can be adjusted as needed for debugging.
-	for range time.Tick(time.Duration(1000/c.ClockSpeed) * time.Millisecond) {
-		// Get the next opcode.
-		c.GetOp()
-
-		// If GetOp() couldn't find another opcode, then it will set the ShouldHalt flag.
-		if c.ShouldHalt {
-			break
+// Step fetches and executes a single instruction. If the fetched opcode is
+// 0x0000, ShouldHalt is set and nothing is executed. The timers aren't
+// touched; they count down in StartFrame.
+func (c *Cpu) Step() error {
+	// Get the next opcode.
+	c.GetOp()
+
+	// If GetOp() couldn't find another opcode, then it will set the ShouldHalt flag.
+	if c.ShouldHalt {
+		if c.events&Halted != 0 {
+			c.emit(Event{Kind: Halted, PC: c.PC})
 		}
+		return nil
+	}
 
-		// Process the current opcode.
-		err := c.ProcessOpcode()
-		if err != nil {
-			// @TODO: Is there a better way to handle this? It's not really something
-			// that can be recovered from gracefully. Does it need to bring down the
-			// entire emulator though?
-			panic(err.Error())
-		}
+	// Remember the state before the instruction so the tracer can be told
+	// what changed.
+	pc := c.PC
+	var before registerSnapshot
+	if c.Tracer != nil {
+		before = c.snapshotRegisters()
+	}
+	var vram [32]int64
+	if c.events&DisplayChanged != 0 {
+		vram = c.Vram
+	}
 
-		// If ShouldDraw has been set, we need to update the screen.
-		if c.ShouldDraw {
-			c.UI.Draw(c.Vram)
+	// Process the current opcode.
+	err := c.ProcessOpcode()
+	if err != nil {
+		c.memoryWrites = nil
+		if c.events&Faulted != 0 {
+			c.emit(Event{Kind: Faulted, PC: pc, Op: c.Op, Err: err})
 		}
+		return err
+	}
+	c.Cycles += 1
 
-		// Process input.
-		// go func() {
-		// 	i := c.UI.GetInput()
-		// 	if i.KeyEsc {
-		// 		c.ShouldHalt = true
-		// 	}
-		// }()
-
-		// If either timer is > 0, decrease them by 1.
-		if c.DelayTimer > 0 {
-			c.DelayTimer -= 1
+	if c.Tracer != nil {
+		c.trace(pc, before)
+	}
+	if c.events != 0 {
+		if c.events&InstructionExecuted != 0 {
+			c.emit(Event{Kind: InstructionExecuted, PC: pc, Op: c.Op})
 		}
-		if c.SoundTimer > 0 {
-			c.SoundTimer -= 1
+		if c.events&MemoryWritten != 0 {
+			for _, w := range c.memoryWrites {
+				c.emit(Event{Kind: MemoryWritten, PC: pc, Op: c.Op, Address: w.Address, Value: w.Value})
+			}
 		}
+		if c.events&DisplayChanged != 0 && vram != c.Vram {
+			c.emit(Event{Kind: DisplayChanged, PC: pc, Op: c.Op})
+		}
+	}
+	c.memoryWrites = nil
+
+	return nil
+}
+
+// StartFrame begins a 60 Hz frame. It counts the delay and sound timers down
+// by one, sending SoundStarted and SoundStopped as the sound timer starts and
+// stops, and returns how many instructions to run before the next frame.
+// That's ClockSpeed/FrameRate on average: the remainder is carried from frame
+// to frame, so a clock speed of 1 runs an instruction every sixtieth frame.
+func (c *Cpu) StartFrame() int {
+	// Report a sound started by the last frame's instructions before it's
+	// counted down.
+	c.reportSound()
+	c.Frames += 1
+	if c.DelayTimer > 0 {
+		c.DelayTimer -= 1
+	}
+	if c.SoundTimer > 0 {
+		c.SoundTimer -= 1
+	}
+	c.reportSound()
+
+	speed := c.ClockSpeed
+	if speed < 1 {
+		speed = 1
+	}
+	c.clockCarry += speed
+	steps := c.clockCarry / FrameRate
+	c.clockCarry -= steps * FrameRate
+	return steps
+}
+
+// reportSound sends SoundStarted or SoundStopped if the sound timer has
+// started or stopped running since it was last reported.
+func (c *Cpu) reportSound() {
+	on := c.SoundTimer > 0
+	if on == c.soundOn {
+		return
 	}
+	c.soundOn = on
+	if on && c.events&SoundStarted != 0 {
+		c.emit(Event{Kind: SoundStarted})
+	} else if !on && c.events&SoundStopped != 0 {
+		c.emit(Event{Kind: SoundStopped})
+	}
+}
+
+// flag returns 1 if b is true and 0 otherwise, for setting VF.
+func flag(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 func (c *Cpu) DumpMemory() {
@@ -137,17 +269,10 @@ func (c *Cpu) DumpMemory() {
 
 // Get next opcode.
 func (c *Cpu) GetOp() {
-	oldOp := c.Op
-
 	// An opcode is two bytes, starting at c.PC. The first byte is bitshift-ed to the left,
 	// and then ORed with the second byte. The end result is a 16 bit opcode.
-	c.Op = (uint16(c.Memory[c.PC]) << 8) | uint16(c.Memory[c.PC+1])
-
-	if c.Debug && (oldOp != c.Op) {
-		fmt.Printf("New opcode: 0x%X\n", c.Op)
-		fmt.Printf("First byte: 0x%X\n", c.Memory[c.PC])
-		fmt.Printf("Second byte: 0x%X\n", c.Memory[c.PC+1])
-	}
+	// Addresses wrap around at the end of memory.
+	c.Op = (uint16(c.Memory[c.PC&0x0FFF]) << 8) | uint16(c.Memory[(c.PC+1)&0x0FFF])
 
 	if c.Op == 0x0000 {
 		c.ShouldHalt = true
@@ -163,19 +288,24 @@ func (c *Cpu) ProcessOpcode() error {
 	switch c.Op & 0xF000 {
 
 	case 0x0000:
-		switch c.Op & 0x000F {
-		case 0x0000:
+		switch c.Op {
+		case 0x00E0:
 			// 0x00E0: Clear the screen.
 			opcodeFound = true
 			c.ClearVram()
 			c.PC += 2
 			break
 
-		case 0x000E:
+		case 0x00EE:
 			// 0x00EE: Returns from a subroutine.
 			opcodeFound = true
+			if c.StackPointer <= 0 {
+				return &StackError{Address: c.PC}
+			}
+			// The stack holds the address of the call, so resume at the
+			// instruction after it.
 			c.StackPointer -= 1
-			c.PC = c.Stack[c.StackPointer]
+			c.PC = c.Stack[c.StackPointer] + 2
 			c.Stack[c.StackPointer] = 0
 			break
 		}
@@ -189,6 +319,9 @@ func (c *Cpu) ProcessOpcode() error {
 	case 0x2000:
 		// 0x2NNN: Call subroutine at 0xNNN
 		opcodeFound = true
+		if c.StackPointer >= len(c.Stack) {
+			return &StackError{Address: c.PC, Overflow: true}
+		}
 		c.Stack[c.StackPointer] = c.PC
 		c.StackPointer += 1
 		c.PC = c.Op & 0x0FFF
@@ -220,6 +353,9 @@ func (c *Cpu) ProcessOpcode() error {
 
 	case 0x5000:
 		// 0x5XY0: Skip next instruction if VX == VY.
+		if c.Op&0x000F != 0 {
+			break
+		}
 		opcodeFound = true
 		r1 := int((c.Op >> 8) & 0x0F)
 		r2 := int((c.Op >> 4) & 0xF)
@@ -262,29 +398,101 @@ func (c *Cpu) ProcessOpcode() error {
 			break
 
 		case 0x0001:
-			// 0x8XY1: Set VX to VX | VY (bitwise OR)
+			// 0x8XY1: Set VX to VX | VY (bitwise OR), and reset VF if the
+			// quirk is on.
 			opcodeFound = true
 			r1 := int((c.Op >> 8) & 0x0F)
 			r2 := int((c.Op >> 4) & 0xF)
 			c.Registers[r1] = (c.Registers[r1] | c.Registers[r2])
+			if c.Quirks.VFReset {
+				c.Registers[0xF] = 0
+			}
 			c.PC += 2
 			break
 
 		case 0x0002:
-			// 0x8XY2: Set VX to VX & VY (bitwise AND)
+			// 0x8XY2: Set VX to VX & VY (bitwise AND), and reset VF if the
+			// quirk is on.
 			opcodeFound = true
 			r1 := int((c.Op >> 8) & 0x0F)
 			r2 := int((c.Op >> 4) & 0xF)
 			c.Registers[r1] = (c.Registers[r1] & c.Registers[r2])
+			if c.Quirks.VFReset {
+				c.Registers[0xF] = 0
+			}
 			c.PC += 2
 			break
 
 		case 0x0003:
-			// 0x8XY3: Set VX to VX xor VY
+			// 0x8XY3: Set VX to VX xor VY, and reset VF if the
+			// quirk is on.
 			opcodeFound = true
 			r1 := int((c.Op >> 8) & 0x0F)
 			r2 := int((c.Op >> 4) & 0xF)
 			c.Registers[r1] = (c.Registers[r1] ^ c.Registers[r2])
+			if c.Quirks.VFReset {
+				c.Registers[0xF] = 0
+			}
+			c.PC += 2
+			break
+
+		case 0x0004:
+			// 0x8XY4: Add VY to VX. VF is set to 1 on a carry, 0 otherwise.
+			// VF is written last, so the flag wins when VF is VX.
+			opcodeFound = true
+			r1 := int((c.Op >> 8) & 0x0F)
+			r2 := int((c.Op >> 4) & 0xF)
+			sum := int(c.Registers[r1]) + int(c.Registers[r2])
+			c.Registers[r1] = uint8(sum)
+			c.Registers[0xF] = flag(sum > 0xFF)
+			c.PC += 2
+			break
+
+		case 0x0005:
+			// 0x8XY5: Subtract VY from VX. VF is set to 0 on a borrow, 1
+			// otherwise.
+			opcodeFound = true
+			r1 := int((c.Op >> 8) & 0x0F)
+			r2 := int((c.Op >> 4) & 0xF)
+			vx, vy := c.Registers[r1], c.Registers[r2]
+			c.Registers[r1] = vx - vy
+			c.Registers[0xF] = flag(vx >= vy)
+			c.PC += 2
+			break
+
+		case 0x0006:
+			// 0x8XY6: Set VX to VY shifted right by one. VF is set to the
+			// bit that was shifted out.
+			opcodeFound = true
+			r1 := int((c.Op >> 8) & 0x0F)
+			r2 := int((c.Op >> 4) & 0xF)
+			vy := c.Registers[r2]
+			c.Registers[r1] = vy >> 1
+			c.Registers[0xF] = vy & 1
+			c.PC += 2
+			break
+
+		case 0x0007:
+			// 0x8XY7: Set VX to VY - VX. VF is set to 0 on a borrow, 1
+			// otherwise.
+			opcodeFound = true
+			r1 := int((c.Op >> 8) & 0x0F)
+			r2 := int((c.Op >> 4) & 0xF)
+			vx, vy := c.Registers[r1], c.Registers[r2]
+			c.Registers[r1] = vy - vx
+			c.Registers[0xF] = flag(vy >= vx)
+			c.PC += 2
+			break
+
+		case 0x000E:
+			// 0x8XYE: Set VX to VY shifted left by one. VF is set to the
+			// bit that was shifted out.
+			opcodeFound = true
+			r1 := int((c.Op >> 8) & 0x0F)
+			r2 := int((c.Op >> 4) & 0xF)
+			vy := c.Registers[r2]
+			c.Registers[r1] = vy << 1
+			c.Registers[0xF] = vy >> 7
 			c.PC += 2
 			break
 
@@ -292,6 +500,9 @@ func (c *Cpu) ProcessOpcode() error {
 
 	case 0x9000:
 		// 0x9XY0: Skip the next instruction if VX != VY
+		if c.Op&0x000F != 0 {
+			break
+		}
 		opcodeFound = true
 		r1 := int((c.Op >> 8) & 0x0F)
 		r2 := int((c.Op >> 4) & 0xF)
@@ -310,18 +521,30 @@ func (c *Cpu) ProcessOpcode() error {
 		c.PC += 2
 		break
 
+	case 0xB000:
+		// 0xBNNN: Jump to 0xNNN plus V0.
+		opcodeFound = true
+		c.PC = (c.Op & 0x0FFF) + uint16(c.Registers[0])
+		break
+
 	case 0xC000:
 		// 0xCXNN: Set VX to the result of a bitwise AND on a random number and NN.
 		opcodeFound = true
 		reg := int((c.Op >> 8) & 0x0F)
 		val := uint8(c.Op & 0x00FF)
-		r := rand.Intn(255)
+		var r int
+		if c.Random != nil {
+			r = c.Random.Intn(256)
+		} else {
+			r = rand.Intn(256)
+		}
 		c.Registers[reg] = val & uint8(r)
 		c.PC += 2
 		break
 
 	case 0xD000:
-		// 0xDXYN: Draw a sprite at (VX, VY) that is N rows tall.
+		// 0xDXYN: Draw a sprite at (VX, VY) that is N rows tall. VF is set if
+		// the sprite collides with pixels that are already lit.
 		opcodeFound = true
 
 		// This is needed so that the CPU knows to draw on this cycle.
@@ -332,18 +555,22 @@ func (c *Cpu) ProcessOpcode() error {
 		yreg := int((c.Op >> 4) & 0xF)
 		rows := int(c.Op & 0x000F)
 
-		// Get the values of the registers.
-		xval := c.Registers[xreg]
-		yval := c.Registers[yreg]
-
-		// x = 0 should be 8 bits shifted 56 digits left
-		xshift := uint(56 - int(xval))
-
-		// "Draw" the sprite to vram by OR'ing a bitshifted byte into place.
-		for b := 0; b < rows; b += 1 {
-			spriteByte := int64(c.Memory[c.IndexRegister+uint16(b)])
-			spriteByte = spriteByte << xshift
-			c.Vram[yval+uint8(b)] = c.Vram[yval+uint8(b)] | spriteByte
+		// Get the values of the registers. The starting coordinates wrap
+		// around the screen, but the sprite itself is clipped at the edges.
+		xval := int(c.Registers[xreg]) % 64
+		yval := int(c.Registers[yreg]) % 32
+
+		// Draw the sprite by XOR'ing it into vram, setting VF if any pixel is
+		// turned off. x = 0 is the most significant bit of the row, so the
+		// byte is moved to the top of the row and then shifted right,
+		// dropping anything past the right edge.
+		c.Registers[0xF] = 0
+		for b := 0; b < rows && yval+b < 32; b += 1 {
+			spriteByte := int64(uint64(c.Memory[(c.IndexRegister+uint16(b))&0x0FFF]) << 56 >> uint(xval))
+			if c.Vram[yval+b]&spriteByte != 0 {
+				c.Registers[0xF] = 1
+			}
+			c.Vram[yval+b] ^= spriteByte
 		}
 
 		// Finally, increment the program counter.
@@ -351,8 +578,51 @@ func (c *Cpu) ProcessOpcode() error {
 
 		break
 
+	case 0xE000:
+		key := c.Registers[(c.Op>>8)&0x0F] & 0x0F
+		switch c.Op & 0x00FF {
+		case 0x009E:
+			// 0xEX9E: Skip the next instruction if the key in VX is down.
+			opcodeFound = true
+			if c.Keys[key] != 0 {
+				c.PC += 4
+			} else {
+				c.PC += 2
+			}
+			break
+		case 0x00A1:
+			// 0xEXA1: Skip the next instruction if the key in VX is up.
+			opcodeFound = true
+			if c.Keys[key] == 0 {
+				c.PC += 4
+			} else {
+				c.PC += 2
+			}
+			break
+		}
+
 	case 0xF000:
 		switch c.Op & 0x00FF {
+		case 0x0007:
+			// 0xFX07: Set VX to the value of the delay timer.
+			opcodeFound = true
+			reg := int((c.Op >> 8) & 0x0F)
+			c.Registers[reg] = c.DelayTimer
+			c.PC += 2
+			break
+		case 0x000A:
+			// 0xFX0A: Wait for a key and store it in VX. Until one is down,
+			// this instruction runs again; the lowest key down wins.
+			opcodeFound = true
+			reg := int((c.Op >> 8) & 0x0F)
+			for key, down := range c.Keys {
+				if down != 0 {
+					c.Registers[reg] = uint8(key)
+					c.PC += 2
+					break
+				}
+			}
+			break
 		case 0x0015:
 			// 0xFX15: Set the delay timer to the value of VX.
 			opcodeFound = true
@@ -367,6 +637,41 @@ func (c *Cpu) ProcessOpcode() error {
 			c.SoundTimer = c.Registers[reg]
 			c.PC += 2
 			break
+		case 0x0033:
+			// 0xFX33: Store the decimal digits of VX at I, I+1 and I+2.
+			opcodeFound = true
+			v := c.Registers[(c.Op>>8)&0x0F]
+			c.writeMemory(c.IndexRegister&0x0FFF, v/100)
+			c.writeMemory((c.IndexRegister+1)&0x0FFF, v/10%10)
+			c.writeMemory((c.IndexRegister+2)&0x0FFF, v%10)
+			c.PC += 2
+			break
+		case 0x0055:
+			// 0xFX55: Store V0 to VX in memory starting at I, and leave I
+			// pointing after the last one if the quirk is on.
+			opcodeFound = true
+			x := (c.Op >> 8) & 0x0F
+			for r := uint16(0); r <= x; r++ {
+				c.writeMemory((c.IndexRegister+r)&0x0FFF, c.Registers[r])
+			}
+			if c.Quirks.IncrementI {
+				c.IndexRegister = (c.IndexRegister + x + 1) & 0x0FFF
+			}
+			c.PC += 2
+			break
+		case 0x0065:
+			// 0xFX65: Load V0 to VX from memory starting at I, and leave I
+			// pointing after the last one if the quirk is on.
+			opcodeFound = true
+			x := (c.Op >> 8) & 0x0F
+			for r := uint16(0); r <= x; r++ {
+				c.Registers[r] = c.Memory[(c.IndexRegister+r)&0x0FFF]
+			}
+			if c.Quirks.IncrementI {
+				c.IndexRegister = (c.IndexRegister + x + 1) & 0x0FFF
+			}
+			c.PC += 2
+			break
 		}
 		break
 
@@ -380,5 +685,8 @@ func (c *Cpu) ProcessOpcode() error {
 		}
 	}
 
+	// Skips near the end of memory wrap around to the start.
+	c.PC &= 0x0FFF
+
 	return nil
 }
@@ -1,9 +1,9 @@
 package cpu
 
 import (
+	"math/rand"
 	"testing"
 
-	"github.com/cweagans/chip8/pkg/ui"
 	asrt "github.com/stretchr/testify/assert"
 )
 
@@ -13,9 +13,8 @@ import (
 func TestNewCpu(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0xff, 0xff}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	assert.Equal(uint8(0xff), cpu.Memory[0x200])
 	assert.Equal(uint8(0xff), cpu.Memory[0x201])
@@ -26,9 +25,8 @@ func TestNewCpu(t *testing.T) {
 func TestClearGfx(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	// CPU should init with an empty Gfx buffer
 	for g := 0; g < 32; g++ {
@@ -60,9 +58,8 @@ func TestClearGfx(t *testing.T) {
 func TestGetOp(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x00, 0xE0}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	// The CPU shouldn't have an opcode loaded before running.
 	assert.Equal(uint16(0x0000), cpu.Op)
@@ -91,9 +88,8 @@ func TestGetOp(t *testing.T) {
 func Test00e0(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x00, 0xE0}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	// CPU should init with ShouldDraw = false
 	assert.False(cpu.ShouldDraw)
@@ -120,9 +116,8 @@ func Test00e0(t *testing.T) {
 func Test00ee(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x00, 0xE0, 0x00, 0xEE}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	cpu.PC = 0x202
 	cpu.Stack[0] = 0x200
@@ -134,7 +129,8 @@ func Test00ee(t *testing.T) {
 
 	assert.NoError(err)
 
-	assert.Equal(uint16(0x200), cpu.PC)
+	// Execution resumes after the call.
+	assert.Equal(uint16(0x202), cpu.PC)
 	assert.Equal(0, cpu.StackPointer)
 	assert.Equal(uint16(0), cpu.Stack[0])
 }
@@ -143,9 +139,8 @@ func Test00ee(t *testing.T) {
 func Test1nnn(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x12, 0x34}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	// Make sure that the CPU state is good before processing the opcode.
 	assert.Equal(uint16(0x200), cpu.PC)
@@ -161,9 +156,8 @@ func Test1nnn(t *testing.T) {
 func Test2nnn(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x22, 0x34}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	// Make sure that the CPU state is good before processing the opcode.
 	assert.Equal(0, cpu.StackPointer)
@@ -182,9 +176,8 @@ func Test2nnn(t *testing.T) {
 func Test3xnn(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x3A, 0x22}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	// Check that the program counter advances as normal if the register is not
 	// set to the specified value.
@@ -209,9 +202,8 @@ func Test3xnn(t *testing.T) {
 func Test4xnn(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x4A, 0x22}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	// Check that the program counter advances by 4 bytes if the register is not
 	// set to the specified value.
@@ -236,9 +228,8 @@ func Test4xnn(t *testing.T) {
 func Test5xnn(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x5A, 0x10}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	// Check that the program counter advances by 4 bytes since the registers
 	// match by default (0x00)
@@ -263,9 +254,8 @@ func Test5xnn(t *testing.T) {
 func Test6xnn(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x6A, 0xFF}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	cpu.GetOp()
 	err := cpu.ProcessOpcode()
@@ -278,9 +268,8 @@ func Test6xnn(t *testing.T) {
 func Test7xnn(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x7A, 0x12}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 	cpu.Registers[0xA] = uint8(0x12)
 
 	cpu.GetOp()
@@ -294,9 +283,8 @@ func Test7xnn(t *testing.T) {
 func Test8XY0(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x8A, 0x10}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 	cpu.Registers[0x1] = uint8(0x55)
 
 	cpu.GetOp()
@@ -310,26 +298,33 @@ func Test8XY0(t *testing.T) {
 func Test8XY1(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x8A, 0xB1}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 	cpu.Registers[0xA] = uint8(0x10)
 	cpu.Registers[0xB] = uint8(0x01)
+	cpu.Registers[0xF] = uint8(0x05)
 
 	cpu.GetOp()
 	err := cpu.ProcessOpcode()
 	assert.NoError(err)
 	assert.Equal(uint8(0x11), cpu.Registers[0xA])
+	assert.Equal(uint8(0x00), cpu.Registers[0xF])
 	assert.Equal(uint16(0x202), cpu.PC)
+
+	// VF is left alone without the VF reset quirk.
+	cpu.Quirks.VFReset = false
+	cpu.PC = 0x200
+	cpu.Registers[0xF] = uint8(0x05)
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint8(0x05), cpu.Registers[0xF])
 }
 
 // Test 0x8XY2: Set VX to VX & VY (bitwise AND)
 func Test8XY2(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x8A, 0xB2}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 	cpu.Registers[0xA] = uint8(0x10)
 	cpu.Registers[0xB] = uint8(0x01)
 
@@ -344,9 +339,8 @@ func Test8XY2(t *testing.T) {
 func Test8XY3(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x8A, 0xB3}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 	cpu.Registers[0xA] = uint8(0x10)
 	cpu.Registers[0xB] = uint8(0x11)
 
@@ -357,13 +351,115 @@ func Test8XY3(t *testing.T) {
 	assert.Equal(uint16(0x202), cpu.PC)
 }
 
+// Test 0x8XY4: Add VY to VX, setting VF on a carry.
+func Test8XY4(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0x8A, 0xB4}
+	cpu := NewCpu(nil, r)
+	cpu.Registers[0xA] = uint8(0xF0)
+	cpu.Registers[0xB] = uint8(0x20)
+
+	cpu.GetOp()
+	err := cpu.ProcessOpcode()
+	assert.NoError(err)
+	assert.Equal(uint8(0x10), cpu.Registers[0xA])
+	assert.Equal(uint8(0x01), cpu.Registers[0xF])
+	assert.Equal(uint16(0x202), cpu.PC)
+
+	// VF is cleared without a carry.
+	cpu.PC = 0x200
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint8(0x30), cpu.Registers[0xA])
+	assert.Equal(uint8(0x00), cpu.Registers[0xF])
+}
+
+// Test 0x8XY5: Subtract VY from VX, setting VF when there's no borrow.
+func Test8XY5(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0x8A, 0xB5}
+	cpu := NewCpu(nil, r)
+	cpu.Registers[0xA] = uint8(0x30)
+	cpu.Registers[0xB] = uint8(0x20)
+
+	cpu.GetOp()
+	err := cpu.ProcessOpcode()
+	assert.NoError(err)
+	assert.Equal(uint8(0x10), cpu.Registers[0xA])
+	assert.Equal(uint8(0x01), cpu.Registers[0xF])
+	assert.Equal(uint16(0x202), cpu.PC)
+
+	// VF is cleared on a borrow.
+	cpu.PC = 0x200
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint8(0xF0), cpu.Registers[0xA])
+	assert.Equal(uint8(0x00), cpu.Registers[0xF])
+}
+
+// Test 0x8XY6: Set VX to VY shifted right, with the bit shifted out in VF.
+func Test8XY6(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0x8A, 0xB6}
+	cpu := NewCpu(nil, r)
+	cpu.Registers[0xA] = uint8(0xFF)
+	cpu.Registers[0xB] = uint8(0x05)
+
+	cpu.GetOp()
+	err := cpu.ProcessOpcode()
+	assert.NoError(err)
+	assert.Equal(uint8(0x02), cpu.Registers[0xA])
+	assert.Equal(uint8(0x01), cpu.Registers[0xF])
+	assert.Equal(uint16(0x202), cpu.PC)
+}
+
+// Test 0x8XY7: Set VX to VY - VX, setting VF when there's no borrow.
+func Test8XY7(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0x8A, 0xB7}
+	cpu := NewCpu(nil, r)
+	cpu.Registers[0xA] = uint8(0x20)
+	cpu.Registers[0xB] = uint8(0x30)
+
+	cpu.GetOp()
+	err := cpu.ProcessOpcode()
+	assert.NoError(err)
+	assert.Equal(uint8(0x10), cpu.Registers[0xA])
+	assert.Equal(uint8(0x01), cpu.Registers[0xF])
+	assert.Equal(uint16(0x202), cpu.PC)
+
+	// VF is cleared on a borrow.
+	cpu.PC = 0x200
+	cpu.Registers[0xA] = uint8(0x40)
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint8(0xF0), cpu.Registers[0xA])
+	assert.Equal(uint8(0x00), cpu.Registers[0xF])
+}
+
+// Test 0x8XYE: Set VX to VY shifted left, with the bit shifted out in VF.
+func Test8XYE(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0x8A, 0xBE}
+	cpu := NewCpu(nil, r)
+	cpu.Registers[0xB] = uint8(0x81)
+
+	cpu.GetOp()
+	err := cpu.ProcessOpcode()
+	assert.NoError(err)
+	assert.Equal(uint8(0x02), cpu.Registers[0xA])
+	assert.Equal(uint8(0x01), cpu.Registers[0xF])
+	assert.Equal(uint16(0x202), cpu.PC)
+}
+
 // Test 0x9XY0: Skip next instruction if VX != VY.
 func Test9xnn(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0x9A, 0x10}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	// Check that the program counter advances by 4 bytes since the registers
 	// match by default (0x00)
@@ -388,9 +484,8 @@ func Test9xnn(t *testing.T) {
 func TestAnnn(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0xA2, 0x34}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 
 	// Make sure that the CPU state is good before processing the opcode.
 	assert.Equal(uint16(0x0000), cpu.IndexRegister)
@@ -402,28 +497,107 @@ func TestAnnn(t *testing.T) {
 	assert.Equal(uint16(0x234), cpu.IndexRegister)
 }
 
+// Test 0xBNNN: Jump to 0xNNN + V0.
+func TestBnnn(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0xB3, 0x00}
+	cpu := NewCpu(nil, r)
+	cpu.Registers[0x0] = uint8(0x12)
+
+	cpu.GetOp()
+	err := cpu.ProcessOpcode()
+	assert.NoError(err)
+	assert.Equal(uint16(0x312), cpu.PC)
+}
+
 // Test 0xCXNN: Set VX to rand & NN.
 func TestCxnn(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0xCA, 0x12}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
+
+	// Use a fixed seed so that the result is known.
+	cpu.Random = rand.New(rand.NewSource(4))
+	want := uint8(rand.New(rand.NewSource(4)).Intn(256)) & 0x12
 
 	cpu.GetOp()
 	err := cpu.ProcessOpcode()
 	assert.NoError(err)
 	assert.NotEqual(uint8(0x0), cpu.Registers[0xA])
+	assert.Equal(want, cpu.Registers[0xA])
+	assert.Equal(uint16(0x202), cpu.PC)
+}
+
+// Test 0xEX9E and 0xEXA1: Skip the next instruction if the key in VX is
+// down, or up.
+func TestEx9eExa1(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0xEA, 0x9E, 0xEA, 0xA1}
+	cpu := NewCpu(nil, r)
+	cpu.Registers[0xA] = uint8(0x05)
+
+	cpu.GetOp()
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint16(0x202), cpu.PC)
+	cpu.GetOp()
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint16(0x206), cpu.PC)
+
+	// Press the key and try again.
+	cpu.Keys[0x5] = 1
+	cpu.PC = 0x200
+	cpu.GetOp()
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint16(0x204), cpu.PC)
+	cpu.PC = 0x202
+	cpu.GetOp()
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint16(0x204), cpu.PC)
+}
+
+// Test 0xFX07: Set VX to the value of the delay timer.
+func TestFx07(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0xFA, 0x07}
+	cpu := NewCpu(nil, r)
+	cpu.DelayTimer = uint8(0x42)
+
+	cpu.GetOp()
+	err := cpu.ProcessOpcode()
+	assert.NoError(err)
+	assert.Equal(uint16(0x202), cpu.PC)
+	assert.Equal(uint8(0x42), cpu.Registers[0xA])
+}
+
+// Test 0xFX0A: Wait for a key press and store it in VX.
+func TestFx0a(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0xFA, 0x0A}
+	cpu := NewCpu(nil, r)
+
+	// With no key down, the instruction runs again.
+	cpu.GetOp()
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint16(0x200), cpu.PC)
+
+	cpu.Keys[0x9] = 1
+	cpu.Keys[0x3] = 1
+	assert.NoError(cpu.ProcessOpcode())
 	assert.Equal(uint16(0x202), cpu.PC)
+	assert.Equal(uint8(0x3), cpu.Registers[0xA])
 }
 
 // Test 0xFX15: Set delay timer to value of VX.
 func TestFx15(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0xFA, 0x15}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 	cpu.Registers[0xA] = uint8(0xFF)
 
 	cpu.GetOp()
@@ -437,9 +611,8 @@ func TestFx15(t *testing.T) {
 func TestFx18(t *testing.T) {
 	assert := asrt.New(t)
 
-	g := &ui.Noop{}
 	r := []byte{0xFA, 0x18}
-	cpu := NewCpu(g, r, false)
+	cpu := NewCpu(nil, r)
 	cpu.Registers[0xA] = uint8(0xFF)
 
 	cpu.GetOp()
@@ -448,3 +621,68 @@ func TestFx18(t *testing.T) {
 	assert.Equal(uint16(0x202), cpu.PC)
 	assert.Equal(uint8(0xFF), cpu.SoundTimer)
 }
+
+// Test 0xFX33: Store the decimal digits of VX at I, I+1 and I+2.
+func TestFx33(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0xFA, 0x33}
+	cpu := NewCpu(nil, r)
+	cpu.Registers[0xA] = uint8(254)
+	cpu.IndexRegister = 0x300
+
+	cpu.GetOp()
+	err := cpu.ProcessOpcode()
+	assert.NoError(err)
+	assert.Equal(uint16(0x202), cpu.PC)
+	assert.Equal([]byte{2, 5, 4}, cpu.Memory[0x300:0x303])
+	assert.Equal(uint16(0x300), cpu.IndexRegister)
+}
+
+// Test 0xFX55: Store V0 to VX in memory starting at I.
+func TestFx55(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0xF2, 0x55}
+	cpu := NewCpu(nil, r)
+	cpu.Registers[0], cpu.Registers[1], cpu.Registers[2], cpu.Registers[3] = 1, 2, 3, 4
+	cpu.IndexRegister = 0x300
+
+	cpu.GetOp()
+	err := cpu.ProcessOpcode()
+	assert.NoError(err)
+	assert.Equal(uint16(0x202), cpu.PC)
+	assert.Equal([]byte{1, 2, 3, 0}, cpu.Memory[0x300:0x304])
+	assert.Equal(uint16(0x303), cpu.IndexRegister)
+
+	// I is left alone without the IncrementI quirk.
+	cpu.Quirks.IncrementI = false
+	cpu.PC = 0x200
+	cpu.IndexRegister = 0x300
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint16(0x300), cpu.IndexRegister)
+}
+
+// Test 0xFX65: Load V0 to VX from memory starting at I.
+func TestFx65(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0xF2, 0x65}
+	cpu := NewCpu(nil, r)
+	copy(cpu.Memory[0x300:], []byte{1, 2, 3, 4})
+	cpu.IndexRegister = 0x300
+
+	cpu.GetOp()
+	err := cpu.ProcessOpcode()
+	assert.NoError(err)
+	assert.Equal(uint16(0x202), cpu.PC)
+	assert.Equal([]uint8{1, 2, 3, 0}, cpu.Registers[0:4])
+	assert.Equal(uint16(0x303), cpu.IndexRegister)
+
+	// I is left alone without the IncrementI quirk.
+	cpu.Quirks.IncrementI = false
+	cpu.PC = 0x200
+	cpu.IndexRegister = 0x300
+	assert.NoError(cpu.ProcessOpcode())
+	assert.Equal(uint16(0x300), cpu.IndexRegister)
+}
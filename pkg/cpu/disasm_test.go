@@ -0,0 +1,75 @@
+package cpu
+
+import (
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that opcodes are disassembled into Cowgod-style mnemonics.
+func TestDisassemble(t *testing.T) {
+	assert := asrt.New(t)
+
+	cases := map[uint16]string{
+		0x00E0: "CLS",
+		0x00EE: "RET",
+		0x0123: "SYS 0x123",
+		0x1234: "JP 0x234",
+		0x2345: "CALL 0x345",
+		0x3A22: "SE VA, 0x22",
+		0x4A22: "SNE VA, 0x22",
+		0x5AB0: "SE VA, VB",
+		0x6AFF: "LD VA, 0xFF",
+		0x7A01: "ADD VA, 0x01",
+		0x8AB0: "LD VA, VB",
+		0x8AB4: "ADD VA, VB",
+		0x8ABE: "SHL VA, VB",
+		0x9AB0: "SNE VA, VB",
+		0xA234: "LD I, 0x234",
+		0xB234: "JP V0, 0x234",
+		0xCA12: "RND VA, 0x12",
+		0xD125: "DRW V1, V2, 5",
+		0xE19E: "SKP V1",
+		0xE1A1: "SKNP V1",
+		0xF107: "LD V1, DT",
+		0xF10A: "LD V1, K",
+		0xF115: "LD DT, V1",
+		0xF118: "LD ST, V1",
+		0xF11E: "ADD I, V1",
+		0xF129: "LD F, V1",
+		0xF133: "LD B, V1",
+		0xF155: "LD [I], V1",
+		0xF165: "LD V1, [I]",
+	}
+
+	for op, expected := range cases {
+		assert.Equal(expected, Disassemble(op), "opcode 0x%04X", op)
+	}
+
+	// Invalid opcodes are shown as data.
+	assert.Equal("DW 0x5AB1", Disassemble(0x5AB1))
+	assert.Equal("DW 0x8AB8", Disassemble(0x8AB8))
+	assert.Equal("DW 0xF1FF", Disassemble(0xF1FF))
+}
+
+// Test that Step() executes an instruction and leaves the timers alone.
+func TestStep(t *testing.T) {
+	assert := asrt.New(t)
+
+	cpu := NewCpu(nil, []byte{0x6A, 0x02, 0x00, 0x00})
+	cpu.DelayTimer = 2
+	cpu.SoundTimer = 1
+
+	assert.NoError(cpu.Step())
+	assert.Equal(uint8(0x02), cpu.Registers[0xA])
+	assert.Equal(uint16(0x202), cpu.PC)
+	assert.Equal(uint8(2), cpu.DelayTimer)
+	assert.Equal(uint8(1), cpu.SoundTimer)
+	assert.False(cpu.ShouldHalt)
+
+	// The next opcode is 0x0000, so the CPU should halt without moving on.
+	assert.NoError(cpu.Step())
+	assert.True(cpu.ShouldHalt)
+	assert.Equal(uint16(0x202), cpu.PC)
+	assert.Equal(uint8(2), cpu.DelayTimer)
+}
@@ -0,0 +1,173 @@
+package cpu
+
+// EventKind identifies what happened in an Event. Kinds are bit flags, so
+// they can be combined to choose which events a subscriber receives.
+type EventKind uint16
+
+const (
+	// InstructionExecuted is sent after each instruction runs.
+	InstructionExecuted EventKind = 1 << iota
+	// MemoryWritten is sent for each byte an instruction writes to memory.
+	MemoryWritten
+	// DisplayChanged is sent after an instruction that changed Vram.
+	DisplayChanged
+	// SoundStarted and SoundStopped are sent by StartFrame when the sound
+	// timer starts and stops running.
+	SoundStarted
+	SoundStopped
+	// KeyPressed and KeyReleased are sent by SetKey when a key changes.
+	KeyPressed
+	KeyReleased
+	// Faulted is sent when an instruction returns an error.
+	Faulted
+	// Halted is sent each time Step finds a 0x0000 opcode.
+	Halted
+	// Reset is sent by Reset.
+	Reset
+
+	// AllEvents subscribes to every kind of event.
+	AllEvents EventKind = 1<<iota - 1
+)
+
+var eventNames = map[EventKind]string{
+	InstructionExecuted: "instruction",
+	MemoryWritten:       "write",
+	DisplayChanged:      "display",
+	SoundStarted:        "sound-start",
+	SoundStopped:        "sound-stop",
+	KeyPressed:          "key-down",
+	KeyReleased:         "key-up",
+	Faulted:             "fault",
+	Halted:              "halt",
+	Reset:               "reset",
+}
+
+func (k EventKind) String() string {
+	if name, ok := eventNames[k]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Event describes something that happened to the CPU. Only the fields that
+// make sense for the kind of event are set:
+//
+//	InstructionExecuted  PC, Op
+//	MemoryWritten        PC, Op, Address, Value
+//	DisplayChanged       PC, Op
+//	Faulted              PC, Op, Err
+//	Halted               PC
+//	KeyPressed/Released  Key
+//
+// Cycle and Frame are always the CPU's Cycles and Frames counts when the
+// event was sent.
+type Event struct {
+	Kind    EventKind
+	Cycle   uint64
+	Frame   uint64
+	PC      uint16
+	Op      uint16
+	Address uint16
+	Value   uint8
+	Key     uint8
+	Err     error
+}
+
+// subscriber is a function registered with Subscribe.
+type subscriber struct {
+	id    int
+	kinds EventKind
+	f     func(Event)
+}
+
+// Subscribe calls f for each event of the given kinds, from whichever
+// goroutine is stepping the CPU. The returned function removes the
+// subscription. Events that nobody has subscribed to cost next to nothing.
+func (c *Cpu) Subscribe(kinds EventKind, f func(Event)) (unsubscribe func()) {
+	c.nextSubscriber++
+	id := c.nextSubscriber
+
+	// The list is copied on change so that subscribers can unsubscribe
+	// while an event is being delivered.
+	subs := make([]subscriber, len(c.subscribers), len(c.subscribers)+1)
+	copy(subs, c.subscribers)
+	c.subscribers = append(subs, subscriber{id, kinds, f})
+	c.updateEvents()
+
+	return func() {
+		var subs []subscriber
+		for _, s := range c.subscribers {
+			if s.id != id {
+				subs = append(subs, s)
+			}
+		}
+		c.subscribers = subs
+		c.updateEvents()
+	}
+}
+
+// updateEvents recalculates the kinds of event that have subscribers.
+func (c *Cpu) updateEvents() {
+	c.events = 0
+	for _, s := range c.subscribers {
+		c.events |= s.kinds
+	}
+}
+
+// emit delivers an event to its subscribers. Callers check c.events first,
+// so that they don't build events nobody wants.
+func (c *Cpu) emit(e Event) {
+	e.Cycle = c.Cycles
+	e.Frame = c.Frames
+	for _, s := range c.subscribers {
+		if s.kinds&e.Kind != 0 {
+			s.f(e)
+		}
+	}
+}
+
+// SetKey presses or releases one of the sixteen keys, 0x0 to 0xF. Other
+// values are ignored.
+func (c *Cpu) SetKey(key uint8, down bool) {
+	if key > 0xF {
+		return
+	}
+	var v uint8
+	if down {
+		v = 1
+	}
+	if c.Keys[key] == v {
+		return
+	}
+	c.Keys[key] = v
+
+	if down && c.events&KeyPressed != 0 {
+		c.emit(Event{Kind: KeyPressed, Key: key})
+	} else if !down && c.events&KeyReleased != 0 {
+		c.emit(Event{Kind: KeyReleased, Key: key})
+	}
+}
+
+// Reset puts the CPU back into its power-on state, with the program still
+// in memory: the registers, stack, timers, keys and display are cleared and
+// execution starts again at 0x200.
+func (c *Cpu) Reset() {
+	c.PC = 0x200
+	c.Op = 0
+	c.Registers = [16]uint8{}
+	c.IndexRegister = 0
+	c.Stack = [16]uint16{}
+	c.StackPointer = 0
+	c.DelayTimer = 0
+	c.SoundTimer = 0
+	c.Keys = [16]uint8{}
+	c.Cycles = 0
+	c.ShouldHalt = false
+	c.clockCarry = 0
+	c.ClearVram()
+	c.reportSound()
+
+	if c.events&Reset != 0 {
+		c.emit(Event{Kind: Reset})
+	}
+}
@@ -0,0 +1,150 @@
+package cpu
+
+import (
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// eventRom sets the sound timer, draws a sprite and then returns with an
+// empty stack.
+var eventRom = []byte{
+	0x60, 0x02, // LD V0, 2
+	0xF0, 0x18, // LD ST, V0
+	0xA2, 0x00, // LD I, 0x200
+	0xD0, 0x01, // DRW V0, V0, 1
+	0x00, 0xEE, // RET
+}
+
+// Test that each step and frame sends the events that were subscribed to, in
+// order.
+func TestEvents(t *testing.T) {
+	assert := asrt.New(t)
+
+	cpu := NewCpu(nil, eventRom)
+	var kinds []EventKind
+	var last Event
+	cpu.Subscribe(AllEvents, func(e Event) {
+		kinds = append(kinds, e.Kind)
+		last = e
+	})
+
+	assert.Nil(cpu.Step())
+	assert.Nil(cpu.Step())
+	cpu.StartFrame()
+	cpu.StartFrame()
+	assert.Nil(cpu.Step())
+	assert.Nil(cpu.Step())
+	assert.NotNil(cpu.Step())
+	assert.Equal([]EventKind{
+		InstructionExecuted,
+		InstructionExecuted,
+		SoundStarted,
+		SoundStopped,
+		InstructionExecuted,
+		InstructionExecuted, DisplayChanged,
+		Faulted,
+	}, kinds)
+	assert.Equal(uint16(0x208), last.PC)
+	assert.Equal(uint16(0x00EE), last.Op)
+	assert.IsType(&StackError{}, last.Err)
+	assert.Equal(uint64(4), last.Cycle)
+}
+
+// Test that a sound timer set to 1 still sounds for a frame.
+func TestShortSound(t *testing.T) {
+	assert := asrt.New(t)
+
+	cpu := NewCpu(nil, []byte{0x60, 0x01, 0xF0, 0x18}) // LD V0, 1; LD ST, V0
+	var kinds []EventKind
+	cpu.Subscribe(SoundStarted|SoundStopped, func(e Event) { kinds = append(kinds, e.Kind) })
+
+	assert.Nil(cpu.Step())
+	assert.Nil(cpu.Step())
+	cpu.StartFrame()
+	assert.Equal([]EventKind{SoundStarted, SoundStopped}, kinds)
+	assert.Equal(uint8(0), cpu.SoundTimer)
+
+	cpu.StartFrame()
+	assert.Len(kinds, 2)
+}
+
+// Test that subscribers only get the kinds they asked for, and nothing once
+// they unsubscribe.
+func TestSubscribe(t *testing.T) {
+	assert := asrt.New(t)
+
+	cpu := NewCpu(nil, []byte{0x60, 0x01, 0x12, 0x00})
+	instructions, displays := 0, 0
+	stop := cpu.Subscribe(InstructionExecuted, func(e Event) { instructions++ })
+	cpu.Subscribe(DisplayChanged|Halted, func(e Event) { displays++ })
+
+	cpu.Step()
+	cpu.Step()
+	assert.Equal(2, instructions)
+	assert.Equal(0, displays)
+
+	stop()
+	cpu.Step()
+	assert.Equal(2, instructions)
+	assert.Equal(DisplayChanged|Halted, cpu.events)
+
+	// Unsubscribing from inside a subscriber is allowed.
+	var stopSelf func()
+	calls := 0
+	stopSelf = cpu.Subscribe(InstructionExecuted, func(e Event) {
+		calls++
+		stopSelf()
+	})
+	cpu.Step()
+	cpu.Step()
+	assert.Equal(1, calls)
+}
+
+// Test that memory writes, halts, key changes and resets are reported.
+func TestOtherEvents(t *testing.T) {
+	assert := asrt.New(t)
+
+	// LD I, 0x300; LD V1, 0xAB; LD [I], V1
+	cpu := NewCpu(nil, []byte{0xA3, 0x00, 0x61, 0xAB, 0xF1, 0x55})
+	var events []Event
+	cpu.Subscribe(AllEvents&^InstructionExecuted, func(e Event) { events = append(events, e) })
+
+	for i := 0; i < 4; i++ {
+		cpu.Step()
+	}
+	cpu.SetKey(0x5, true)
+	cpu.SetKey(0x5, true)
+	cpu.SetKey(0x5, false)
+	cpu.SetKey(0x10, true)
+	cpu.Reset()
+
+	// Writes are counted as part of the instruction that made them.
+	assert.Len(events, 6)
+	assert.Equal(Event{Kind: MemoryWritten, Cycle: 3, Address: 0x300, Value: 0x00, PC: 0x204, Op: 0xF155}, events[0])
+	assert.Equal(Event{Kind: MemoryWritten, Cycle: 3, Address: 0x301, Value: 0xAB, PC: 0x204, Op: 0xF155}, events[1])
+	assert.Equal(Event{Kind: Halted, Cycle: 3, PC: 0x206}, events[2])
+	assert.Equal(Event{Kind: KeyPressed, Cycle: 3, Key: 0x5}, events[3])
+	assert.Equal(Event{Kind: KeyReleased, Cycle: 3, Key: 0x5}, events[4])
+	assert.Equal(Reset, events[5].Kind)
+	assert.Equal("key-down", KeyPressed.String())
+}
+
+// Test that Reset restarts the program without clearing memory.
+func TestReset(t *testing.T) {
+	assert := asrt.New(t)
+
+	cpu := NewCpu(nil, eventRom)
+	for i := 0; i < 4; i++ {
+		cpu.Step()
+	}
+	cpu.SetKey(0x1, true)
+	cpu.Reset()
+
+	assert.Equal(uint16(0x200), cpu.PC)
+	assert.Equal([16]uint8{}, cpu.Registers)
+	assert.Equal([16]uint8{}, cpu.Keys)
+	assert.Equal([32]int64{}, cpu.Vram)
+	assert.Equal(uint64(0), cpu.Cycles)
+	assert.Equal(uint8(0x60), cpu.Memory[0x200])
+}
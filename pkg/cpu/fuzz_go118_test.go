@@ -0,0 +1,19 @@
+//go:build go1.18
+// +build go1.18
+
+package cpu
+
+import "testing"
+
+// FuzzCpu feeds arbitrary ROMs and CPU states through the CPU. Run it with
+// go test -fuzz FuzzCpu ./pkg/cpu.
+func FuzzCpu(f *testing.F) {
+	for _, data := range fuzzRegressions {
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := fuzzRun(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package cpu
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// fuzzHeaderSize is the number of input bytes used by fuzzCpu to set up the
+// CPU state before the rest is loaded as the ROM.
+const fuzzHeaderSize = 16 + 2 + 2 + 1 + 1 + 1 + 2*16 + 8
+
+// fuzzCycles bounds the number of instructions run for each input.
+const fuzzCycles = 1000
+
+// fuzzCpu builds a CPU from fuzzer input. The header sets V0-VF, I, PC, SP,
+// DT, ST, the stack and the random seed, each brought into range, and the
+// remaining bytes are the ROM.
+func fuzzCpu(data []byte) *Cpu {
+	header := make([]byte, fuzzHeaderSize)
+	n := copy(header, data)
+
+	c := NewCpu(nil, data[n:])
+	copy(c.Registers[:], header[0:16])
+	c.IndexRegister = (uint16(header[16])<<8 | uint16(header[17])) & 0x0FFF
+	c.PC = (uint16(header[18])<<8 | uint16(header[19])) & 0x0FFF
+	c.StackPointer = int(header[20]) % (len(c.Stack) + 1)
+	c.DelayTimer = header[21]
+	c.SoundTimer = header[22]
+	for i := range c.Stack {
+		c.Stack[i] = (uint16(header[23+2*i])<<8 | uint16(header[24+2*i])) & 0x0FFF
+	}
+
+	var seed int64
+	for _, b := range header[55:63] {
+		seed = seed<<8 | int64(b)
+	}
+	c.Random = rand.New(rand.NewSource(seed))
+	return c
+}
+
+// fuzzRun runs a CPU built from fuzzer input for a bounded number of cycles.
+// Errors from the program, like unknown opcodes, are fine, but panics and
+// broken invariants are not. The same input is also run with a tracer
+// attached, which must not change the outcome.
+func fuzzRun(data []byte) error {
+	plain := fuzzCpu(data)
+	plainErr, err := fuzzExecute(plain)
+	if err != nil {
+		return err
+	}
+
+	traced := fuzzCpu(data)
+	traced.Tracer = discardTracer{}
+	tracedErr, err := fuzzExecute(traced)
+	if err != nil {
+		return err
+	}
+
+	if fmt.Sprint(plainErr) != fmt.Sprint(tracedErr) {
+		return fmt.Errorf("tracing changed the result: %v vs %v", plainErr, tracedErr)
+	}
+	if plain.State() != traced.State() {
+		return fmt.Errorf("tracing changed the final state")
+	}
+	return nil
+}
+
+// fuzzExecute steps the CPU until it halts, returns an error, or runs out of
+// cycles. It returns the program's error, if any, and an error if the CPU
+// panicked or broke an invariant.
+func fuzzExecute(c *Cpu) (progErr error, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic at 0x%03X executing 0x%04X: %v", c.PC, c.Op, r)
+		}
+	}()
+
+	for i := 0; i < fuzzCycles && !c.ShouldHalt; i++ {
+		if progErr = c.Step(); progErr != nil {
+			return progErr, nil
+		}
+
+		switch {
+		case c.PC > 0x0FFF:
+			return nil, fmt.Errorf("PC out of range after 0x%04X: 0x%X", c.Op, c.PC)
+		case c.IndexRegister > 0x0FFF:
+			return nil, fmt.Errorf("I out of range after 0x%04X: 0x%X", c.Op, c.IndexRegister)
+		case c.StackPointer < 0 || c.StackPointer > len(c.Stack):
+			return nil, fmt.Errorf("SP out of range after 0x%04X: %d", c.Op, c.StackPointer)
+		}
+	}
+	return nil, nil
+}
+
+type discardTracer struct{}
+
+func (discardTracer) Trace(e *TraceEvent) {}
+
+// fuzzRegressions are inputs that used to panic.
+var fuzzRegressions = map[string][]byte{
+	// RET with an empty stack.
+	"stack underflow": romAt(0x200, 0x00, 0xEE),
+	// 17 nested calls.
+	"stack overflow": romAt(0x200, 0x22, 0x00),
+	// Fetching the opcode at 0xFFF reads past the end of memory.
+	"fetch at 0xFFF": romAt(0xFFF, 0x12),
+	// A skip at 0xFFE moves PC past the end of memory.
+	"skip at 0xFFE": romAt(0xFFE, 0x30, 0x00),
+	// Sprites that run off the bottom of the screen.
+	"draw off bottom": romAt(0x200, 0x61, 0x1F, 0xD0, 0x1F),
+	// Sprites read from past the end of memory.
+	"draw past memory": romAt(0x200, 0xAF, 0xFF, 0xD0, 0x0F),
+	// ROMs too big to fit in memory.
+	"huge rom": romAt(0x200, make([]byte, 8192)...),
+}
+
+// romAt returns fuzzer input that starts executing code at addr, which must
+// be at least 0x200.
+func romAt(addr uint16, code ...byte) []byte {
+	data := make([]byte, fuzzHeaderSize+int(addr)-0x200)
+	data[18], data[19] = byte(addr>>8), byte(addr)
+	return append(data, code...)
+}
+
+// Test inputs that used to panic.
+func TestFuzzRegressions(t *testing.T) {
+	assert := asrt.New(t)
+
+	for name, data := range fuzzRegressions {
+		assert.NoError(fuzzRun(data), name)
+	}
+
+	err, _ := fuzzExecute(fuzzCpu(fuzzRegressions["stack overflow"]))
+	assert.IsType(&StackError{}, err)
+	assert.Equal("Stack overflow at address 0x200", err.Error())
+
+	err, _ = fuzzExecute(fuzzCpu(fuzzRegressions["stack underflow"]))
+	assert.Equal("Stack underflow at address 0x200", err.Error())
+}
+
+// Test a fixed set of random inputs, so that plain `go test` gets some of
+// the fuzzer's coverage.
+func TestFuzzRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		data := make([]byte, fuzzHeaderSize+r.Intn(64))
+		r.Read(data)
+		if err := fuzzRun(data); err != nil {
+			t.Fatalf("input %x: %s", data, err.Error())
+		}
+	}
+}
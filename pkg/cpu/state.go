@@ -0,0 +1,64 @@
+package cpu
+
+import "fmt"
+
+// State is a copy of everything that makes up the machine, suitable for
+// saving as JSON.
+type State struct {
+	PC            uint16      `json:"pc"`
+	IndexRegister uint16      `json:"i"`
+	Registers     [16]uint8   `json:"v"`
+	Stack         [16]uint16  `json:"stack"`
+	StackPointer  int         `json:"sp"`
+	DelayTimer    uint8       `json:"dt"`
+	SoundTimer    uint8       `json:"st"`
+	Keys          [16]uint8   `json:"keys"`
+	Cycles        uint64      `json:"cycles"`
+	Vram          [32]int64   `json:"vram"`
+	Memory        [4096]uint8 `json:"memory"`
+}
+
+// State returns a copy of the machine state.
+func (c *Cpu) State() State {
+	return State{
+		PC:            c.PC,
+		IndexRegister: c.IndexRegister,
+		Registers:     c.Registers,
+		Stack:         c.Stack,
+		StackPointer:  c.StackPointer,
+		DelayTimer:    c.DelayTimer,
+		SoundTimer:    c.SoundTimer,
+		Keys:          c.Keys,
+		Cycles:        c.Cycles,
+		Vram:          c.Vram,
+		Memory:        c.Memory,
+	}
+}
+
+// Restore replaces the machine state with a copy of s. It returns an error,
+// and leaves the machine alone, if the stack pointer, PC or I is out of
+// range.
+func (c *Cpu) Restore(s State) error {
+	if s.StackPointer < 0 || s.StackPointer > len(s.Stack) {
+		return fmt.Errorf("Stack pointer %d is out of range", s.StackPointer)
+	}
+	if s.PC > 0x0FFF {
+		return fmt.Errorf("PC 0x%X is out of range", s.PC)
+	}
+	if s.IndexRegister > 0x0FFF {
+		return fmt.Errorf("I 0x%X is out of range", s.IndexRegister)
+	}
+
+	c.PC = s.PC
+	c.IndexRegister = s.IndexRegister
+	c.Registers = s.Registers
+	c.Stack = s.Stack
+	c.StackPointer = s.StackPointer
+	c.DelayTimer = s.DelayTimer
+	c.SoundTimer = s.SoundTimer
+	c.Keys = s.Keys
+	c.Cycles = s.Cycles
+	c.Vram = s.Vram
+	c.Memory = s.Memory
+	return nil
+}
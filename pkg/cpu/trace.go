@@ -0,0 +1,112 @@
+package cpu
+
+// Tracer is notified of every instruction the CPU executes.
+type Tracer interface {
+	Trace(e *TraceEvent)
+}
+
+// TraceEvent describes a single executed instruction. Register values are
+// the state after the instruction ran.
+type TraceEvent struct {
+	Cycle         uint64
+	PC            uint16
+	Op            uint16
+	Mnemonic      string
+	Registers     [16]uint8
+	IndexRegister uint16
+	StackPointer  int
+	DelayTimer    uint8
+	SoundTimer    uint8
+	Changes       []RegisterChange
+	Writes        []MemoryWrite
+}
+
+// RegisterChange records a register that was modified by an instruction.
+type RegisterChange struct {
+	Register string
+	Old      uint16
+	New      uint16
+}
+
+// MemoryWrite records a byte written to memory by an instruction.
+type MemoryWrite struct {
+	Address uint16
+	Value   uint8
+}
+
+// registerSnapshot is the part of the CPU state that is compared to find
+// register changes.
+type registerSnapshot struct {
+	registers     [16]uint8
+	indexRegister uint16
+	stackPointer  int
+	delayTimer    uint8
+	soundTimer    uint8
+}
+
+var registerNames = [16]string{"V0", "V1", "V2", "V3", "V4", "V5", "V6", "V7", "V8", "V9", "VA", "VB", "VC", "VD", "VE", "VF"}
+
+func (c *Cpu) snapshotRegisters() registerSnapshot {
+	return registerSnapshot{
+		registers:     c.Registers,
+		indexRegister: c.IndexRegister,
+		stackPointer:  c.StackPointer,
+		delayTimer:    c.DelayTimer,
+		soundTimer:    c.SoundTimer,
+	}
+}
+
+// trace reports the instruction that was just executed to the tracer.
+func (c *Cpu) trace(pc uint16, before registerSnapshot) {
+	e := &TraceEvent{
+		Cycle:         c.Cycles,
+		PC:            pc,
+		Op:            c.Op,
+		Mnemonic:      Disassemble(c.Op),
+		Registers:     c.Registers,
+		IndexRegister: c.IndexRegister,
+		StackPointer:  c.StackPointer,
+		DelayTimer:    c.DelayTimer,
+		SoundTimer:    c.SoundTimer,
+		Writes:        c.memoryWrites,
+	}
+
+	for r := range c.Registers {
+		if before.registers[r] != c.Registers[r] {
+			e.Changes = append(e.Changes, RegisterChange{registerNames[r], uint16(before.registers[r]), uint16(c.Registers[r])})
+		}
+	}
+	if before.indexRegister != c.IndexRegister {
+		e.Changes = append(e.Changes, RegisterChange{"I", before.indexRegister, c.IndexRegister})
+	}
+	if before.stackPointer != c.StackPointer {
+		e.Changes = append(e.Changes, RegisterChange{"SP", uint16(before.stackPointer), uint16(c.StackPointer)})
+	}
+	if before.delayTimer != c.DelayTimer {
+		e.Changes = append(e.Changes, RegisterChange{"DT", uint16(before.delayTimer), uint16(c.DelayTimer)})
+	}
+	if before.soundTimer != c.SoundTimer {
+		e.Changes = append(e.Changes, RegisterChange{"ST", uint16(before.soundTimer), uint16(c.SoundTimer)})
+	}
+
+	c.Tracer.Trace(e)
+}
+
+// writeMemory stores a byte on behalf of an instruction. Opcodes that modify
+// memory should go through here so that the write shows up in traces and
+// events, which Step sends once the instruction has finished.
+func (c *Cpu) writeMemory(addr uint16, v uint8) {
+	c.Memory[addr] = v
+	if c.Tracer != nil || c.events&MemoryWritten != 0 {
+		c.memoryWrites = append(c.memoryWrites, MemoryWrite{addr, v})
+	}
+}
+
+// Tracers attaches several tracers to a CPU at once.
+type Tracers []Tracer
+
+func (ts Tracers) Trace(e *TraceEvent) {
+	for _, t := range ts {
+		t.Trace(e)
+	}
+}
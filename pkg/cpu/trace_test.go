@@ -0,0 +1,59 @@
+package cpu
+
+import (
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+type recordingTracer struct {
+	events []*TraceEvent
+}
+
+func (r *recordingTracer) Trace(e *TraceEvent) {
+	r.events = append(r.events, e)
+}
+
+// Test that the tracer sees every executed instruction along with the
+// registers it changed.
+func TestTracer(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := []byte{0x6A, 0x02, 0xA3, 0x00, 0x6A, 0x02, 0x00, 0x00}
+	cpu := NewCpu(nil, r)
+	tracer := &recordingTracer{}
+	cpu.Tracer = tracer
+
+	for !cpu.ShouldHalt {
+		assert.NoError(cpu.Step())
+	}
+
+	assert.Len(tracer.events, 3)
+	assert.Equal(uint64(3), cpu.Cycles)
+
+	e := tracer.events[0]
+	assert.Equal(uint64(1), e.Cycle)
+	assert.Equal(uint16(0x200), e.PC)
+	assert.Equal(uint16(0x6A02), e.Op)
+	assert.Equal("LD VA, 0x02", e.Mnemonic)
+	assert.Equal(uint8(0x02), e.Registers[0xA])
+	assert.Equal([]RegisterChange{{"VA", 0x00, 0x02}}, e.Changes)
+
+	e = tracer.events[1]
+	assert.Equal(uint16(0x202), e.PC)
+	assert.Equal([]RegisterChange{{"I", 0x000, 0x300}}, e.Changes)
+
+	// Setting a register to the value it already has isn't a change.
+	assert.Empty(tracer.events[2].Changes)
+
+	// Memory writes made by an instruction are reported too.
+	cpu = NewCpu(nil, []byte{0xA3, 0x00, 0x60, 0xFE, 0xF0, 0x33})
+	tracer = &recordingTracer{}
+	cpu.Tracer = tracer
+	for i := 0; i < 3; i++ {
+		assert.NoError(cpu.Step())
+	}
+	assert.Empty(tracer.events[1].Writes)
+	assert.Equal([]MemoryWrite{{0x300, 2}, {0x301, 5}, {0x302, 4}}, tracer.events[2].Writes)
+	assert.Equal([]uint8{2, 5, 4}, cpu.Memory[0x300:0x303])
+}
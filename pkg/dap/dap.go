@@ -0,0 +1,730 @@
+// Package dap implements the Debug Adapter Protocol, so that editors such as
+// VS Code can launch and debug ROMs.
+//
+// Breakpoints can be set on assembler source lines when the launch request
+// names a source map (see package srcmap). Without one, the adapter serves a
+// disassembly listing of the ROM as a virtual source, and breakpoints are set
+// on its lines instead. Instruction breakpoints work in both cases.
+package dap
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/debugger"
+	"github.com/cweagans/chip8/pkg/srcmap"
+)
+
+const (
+	threadID         = 1
+	listingReference = 1
+
+	registersReference = 1
+	timersReference    = 2
+	stackReference     = 3
+)
+
+type request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+type event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// Source identifies a file, or a virtual source served by the adapter.
+type Source struct {
+	Name            string `json:"name,omitempty"`
+	Path            string `json:"path,omitempty"`
+	SourceReference int    `json:"sourceReference,omitempty"`
+}
+
+// LaunchArguments are the adapter specific arguments to the launch request.
+type LaunchArguments struct {
+	Program     string `json:"program"`
+	SourceMap   string `json:"sourceMap"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+}
+
+// Server is a debug adapter for a single session. The program runs on a
+// goroutine of its own while the server handles requests, so it has no
+// display.
+type Server struct {
+	out   *bufio.Writer
+	outMu sync.Mutex
+	seq   int
+
+	mu          sync.Mutex
+	d           *debugger.Debugger
+	running     bool
+	done        chan struct{}
+	interrupt   chan struct{}
+	stopOnEntry bool
+	listing     string
+	listingMap  *srcmap.Map
+	sourceMap   *srcmap.Map
+	program     string
+	breakpoints map[string][]*debugger.Breakpoint
+}
+
+// NewServer returns a debug adapter.
+func NewServer() *Server {
+	return &Server{
+		interrupt:   make(chan struct{}, 1),
+		breakpoints: map[string][]*debugger.Breakpoint{},
+	}
+}
+
+// ListenAndServe accepts connections on addr, starting a new session for each
+// one in turn.
+func ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = NewServer().Serve(conn, conn)
+		conn.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+}
+
+// Serve reads requests from r and writes responses and events to w until the
+// client disconnects. The debugged program is stopped when it returns.
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	s.out = bufio.NewWriter(w)
+	tp := textproto.NewReader(bufio.NewReader(r))
+	defer s.stop()
+
+	for {
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return err
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			return fmt.Errorf("invalid Content-Length header")
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(tp.R, body); err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			return err
+		}
+		if req.Type != "request" {
+			continue
+		}
+
+		if quit := s.handle(req); quit {
+			return nil
+		}
+	}
+}
+
+func (s *Server) send(msg interface{}) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+
+	s.seq++
+	switch m := msg.(type) {
+	case *response:
+		m.Seq = s.seq
+	case *event:
+		m.Seq = s.seq
+	}
+
+	data, _ := json.Marshal(msg)
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n", len(data))
+	s.out.Write(data)
+	s.out.Flush()
+}
+
+func (s *Server) respond(req request, body interface{}) {
+	s.send(&response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *Server) fail(req request, format string, args ...interface{}) {
+	s.send(&response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: fmt.Sprintf(format, args...)})
+}
+
+func (s *Server) emit(name string, body interface{}) {
+	s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+// handle dispatches a single request. It returns true when the session is
+// over.
+func (s *Server) handle(req request) bool {
+	switch req.Command {
+	case "initialize":
+		s.respond(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsInstructionBreakpoints":   true,
+			"supportsReadMemoryRequest":        true,
+			"supportsDisassembleRequest":       true,
+			"supportsSetVariable":              true,
+			"supportsTerminateRequest":         true,
+		})
+		return false
+
+	case "disconnect", "terminate":
+		s.stop()
+		s.respond(req, nil)
+		if req.Command == "terminate" {
+			s.emit("terminated", nil)
+		}
+		return req.Command == "disconnect"
+
+	case "threads":
+		s.respond(req, map[string]interface{}{
+			"threads": []map[string]interface{}{{"id": threadID, "name": "CHIP-8"}},
+		})
+		return false
+
+	case "pause":
+		select {
+		case s.interrupt <- struct{}{}:
+		default:
+		}
+		s.respond(req, nil)
+		return false
+	}
+
+	s.mu.Lock()
+	running := s.running
+	launched := s.d != nil
+	s.mu.Unlock()
+
+	if req.Command == "launch" {
+		if launched {
+			s.fail(req, "a program has already been launched")
+			return false
+		}
+		s.launch(req)
+		return false
+	}
+	if !launched {
+		s.fail(req, "no program has been launched")
+		return false
+	}
+	if running {
+		s.fail(req, "the program is running")
+		return false
+	}
+
+	switch req.Command {
+	case "setBreakpoints":
+		s.setBreakpoints(req)
+
+	case "setInstructionBreakpoints":
+		s.setInstructionBreakpoints(req)
+
+	case "configurationDone":
+		s.respond(req, nil)
+		if s.stopOnEntry {
+			s.emit("stopped", map[string]interface{}{"reason": "entry", "threadId": threadID, "allThreadsStopped": true})
+		} else {
+			s.resume(func() debugger.Stop { return s.d.Continue(s.interrupt) })
+		}
+
+	case "continue":
+		s.respond(req, map[string]interface{}{"allThreadsContinued": true})
+		s.resume(func() debugger.Stop { return s.d.Continue(s.interrupt) })
+
+	case "next":
+		s.respond(req, nil)
+		s.resume(func() debugger.Stop { return s.d.Next(s.interrupt) })
+
+	case "stepIn":
+		s.respond(req, nil)
+		s.resume(s.d.Step)
+
+	case "stepOut":
+		if s.d.Cpu.StackPointer == 0 {
+			s.fail(req, "not inside a subroutine")
+			return false
+		}
+		s.respond(req, nil)
+		s.resume(func() debugger.Stop {
+			stop, _ := s.d.Finish(s.interrupt)
+			return stop
+		})
+
+	case "stackTrace":
+		s.stackTrace(req)
+
+	case "scopes":
+		s.respond(req, map[string]interface{}{
+			"scopes": []map[string]interface{}{
+				{"name": "Registers", "variablesReference": registersReference, "expensive": false},
+				{"name": "Timers", "variablesReference": timersReference, "expensive": false},
+				{"name": "Stack", "variablesReference": stackReference, "expensive": false},
+			},
+		})
+
+	case "variables":
+		s.variables(req)
+
+	case "setVariable":
+		s.setVariable(req)
+
+	case "readMemory":
+		s.readMemory(req)
+
+	case "disassemble":
+		s.disassemble(req)
+
+	case "source":
+		var args struct {
+			SourceReference int `json:"sourceReference"`
+		}
+		json.Unmarshal(req.Arguments, &args)
+		if args.SourceReference != listingReference {
+			s.fail(req, "unknown source")
+			return false
+		}
+		s.respond(req, map[string]interface{}{"content": s.listing, "mimeType": "text/x-chip8-disassembly"})
+
+	default:
+		s.fail(req, "unsupported request %q", req.Command)
+	}
+
+	return false
+}
+
+func (s *Server) launch(req request) {
+	var args LaunchArguments
+	if err := json.Unmarshal(req.Arguments, &args); err != nil || args.Program == "" {
+		s.fail(req, "launch requires a program")
+		return
+	}
+
+	rom, err := ioutil.ReadFile(args.Program)
+	if err != nil {
+		s.fail(req, "could not open ROM: %s", err.Error())
+		return
+	}
+
+	var sm *srcmap.Map
+	if args.SourceMap != "" {
+		sm, err = srcmap.Load(args.SourceMap)
+		if err != nil {
+			s.fail(req, "could not load source map: %s", err.Error())
+			return
+		}
+	}
+
+	s.mu.Lock()
+	s.d = debugger.New(cpu.NewCpu(nil, rom))
+	s.program = args.Program
+	s.stopOnEntry = args.StopOnEntry
+	s.sourceMap = sm
+	s.listing, s.listingMap = srcmap.Listing(s.listingName(), rom)
+	s.mu.Unlock()
+
+	s.respond(req, nil)
+	s.emit("initialized", nil)
+}
+
+func (s *Server) listingName() string {
+	return filepath.Base(s.program) + ".dis"
+}
+
+// resume runs fn in the background, reporting how it stopped once it returns.
+func (s *Server) resume(fn func() debugger.Stop) {
+	s.mu.Lock()
+	s.running = true
+	s.done = make(chan struct{})
+	done := s.done
+	s.mu.Unlock()
+
+	// Drop any pause that arrived while the program was already stopped.
+	select {
+	case <-s.interrupt:
+	default:
+	}
+
+	go func() {
+		stop := fn()
+
+		s.mu.Lock()
+		s.running = false
+		s.mu.Unlock()
+		close(done)
+
+		body := map[string]interface{}{"threadId": threadID, "allThreadsStopped": true}
+		switch stop.Reason {
+		case debugger.StopHalt:
+			s.emit("exited", map[string]interface{}{"exitCode": 0})
+			s.emit("terminated", nil)
+			return
+		case debugger.StopStep:
+			body["reason"] = "step"
+		case debugger.StopBreakpoint:
+			body["reason"] = "breakpoint"
+			body["hitBreakpointIds"] = []int{stop.Breakpoint.ID}
+		case debugger.StopWatchpoint:
+			body["reason"] = "data breakpoint"
+			body["description"] = stop.String()
+		case debugger.StopInterrupt:
+			body["reason"] = "pause"
+		case debugger.StopFault:
+			body["reason"] = "exception"
+			body["text"] = stop.Err.Error()
+			s.emit("output", map[string]interface{}{"category": "stderr", "output": stop.String() + "\n"})
+		}
+		s.emit("stopped", body)
+	}()
+}
+
+// stop interrupts a running program and waits for it to stop.
+func (s *Server) stop() {
+	s.mu.Lock()
+	running, done := s.running, s.done
+	s.mu.Unlock()
+
+	if running {
+		select {
+		case s.interrupt <- struct{}{}:
+		default:
+		}
+		<-done
+	}
+}
+
+// lookup returns the source and line for an address, preferring the source
+// map over the disassembly listing.
+func (s *Server) lookup(addr uint16) (*Source, int) {
+	if s.sourceMap != nil {
+		if e, ok := s.sourceMap.Lookup(addr); ok {
+			return &Source{Name: filepath.Base(e.File), Path: e.File}, e.Line
+		}
+	}
+	if e, ok := s.listingMap.Lookup(addr); ok {
+		return &Source{Name: s.listingName(), SourceReference: listingReference}, e.Line
+	}
+	return nil, 0
+}
+
+type sourceBreakpoint struct {
+	Line      int    `json:"line"`
+	Condition string `json:"condition"`
+}
+
+func (s *Server) setBreakpoints(req request) {
+	var args struct {
+		Source      Source             `json:"source"`
+		Breakpoints []sourceBreakpoint `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "invalid arguments")
+		return
+	}
+
+	key := args.Source.Path
+	m := s.sourceMap
+	if args.Source.SourceReference == listingReference || (key != "" && filepath.Base(key) == s.listingName()) {
+		key = s.listingName()
+		m = s.listingMap
+	}
+	s.clearBreakpoints(key)
+
+	result := []map[string]interface{}{}
+	for _, sb := range args.Breakpoints {
+		var addrs []uint16
+		if m != nil {
+			addrs = m.Addresses(key, sb.Line)
+		}
+		bp, msg := s.addBreakpoints(key, addrs, sb.Condition)
+		entry := map[string]interface{}{"verified": bp != nil, "line": sb.Line}
+		if bp != nil {
+			entry["id"] = bp.ID
+		} else {
+			entry["message"] = msg
+		}
+		result = append(result, entry)
+	}
+
+	s.respond(req, map[string]interface{}{"breakpoints": result})
+}
+
+func (s *Server) setInstructionBreakpoints(req request) {
+	var args struct {
+		Breakpoints []struct {
+			InstructionReference string `json:"instructionReference"`
+			Offset               int    `json:"offset"`
+			Condition            string `json:"condition"`
+		} `json:"breakpoints"`
+	}
+	if err := json.Unmarshal(req.Arguments, &args); err != nil {
+		s.fail(req, "invalid arguments")
+		return
+	}
+
+	const key = "<instructions>"
+	s.clearBreakpoints(key)
+
+	result := []map[string]interface{}{}
+	for _, ib := range args.Breakpoints {
+		var addrs []uint16
+		addr, err := strconv.ParseUint(ib.InstructionReference, 0, 16)
+		if err == nil && int(addr)+ib.Offset >= 0 && int(addr)+ib.Offset <= 0x0FFF {
+			addrs = []uint16{uint16(int(addr) + ib.Offset)}
+		}
+		bp, msg := s.addBreakpoints(key, addrs, ib.Condition)
+		entry := map[string]interface{}{"verified": bp != nil}
+		if bp != nil {
+			entry["id"] = bp.ID
+			entry["instructionReference"] = fmt.Sprintf("0x%03X", bp.Address)
+		} else {
+			entry["message"] = msg
+		}
+		result = append(result, entry)
+	}
+
+	s.respond(req, map[string]interface{}{"breakpoints": result})
+}
+
+func (s *Server) clearBreakpoints(key string) {
+	for _, bp := range s.breakpoints[key] {
+		s.d.Delete(bp.ID)
+	}
+	delete(s.breakpoints, key)
+}
+
+// addBreakpoints sets a breakpoint on each address, returning the first one
+// or a message explaining why none could be set.
+func (s *Server) addBreakpoints(key string, addrs []uint16, condition string) (*debugger.Breakpoint, string) {
+	if len(addrs) == 0 {
+		return nil, "no code at this location"
+	}
+
+	var cond *debugger.Condition
+	if condition != "" {
+		var err error
+		cond, err = debugger.ParseCondition(condition)
+		if err != nil {
+			return nil, err.Error()
+		}
+	}
+
+	var first *debugger.Breakpoint
+	for _, addr := range addrs {
+		bp := s.d.AddBreakpoint(addr, cond)
+		s.breakpoints[key] = append(s.breakpoints[key], bp)
+		if first == nil {
+			first = bp
+		}
+	}
+	return first, ""
+}
+
+func (s *Server) stackTrace(req request) {
+	c := s.d.Cpu
+	frames := []map[string]interface{}{}
+
+	addrs := []uint16{c.PC}
+	for i := c.StackPointer - 1; i >= 0; i-- {
+		addrs = append(addrs, c.Stack[i])
+	}
+
+	for i, addr := range addrs {
+		op := uint16(c.Memory[addr&0x0FFF])<<8 | uint16(c.Memory[(addr+1)&0x0FFF])
+		frame := map[string]interface{}{
+			"id":                          i,
+			"name":                        fmt.Sprintf("0x%03X %s", addr, cpu.Disassemble(op)),
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": fmt.Sprintf("0x%03X", addr),
+		}
+		if src, line := s.lookup(addr); src != nil {
+			frame["source"] = src
+			frame["line"] = line
+			frame["column"] = 1
+		}
+		frames = append(frames, frame)
+	}
+
+	s.respond(req, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)})
+}
+
+func variable(name, value string) map[string]interface{} {
+	return map[string]interface{}{"name": name, "value": value, "variablesReference": 0}
+}
+
+func (s *Server) variables(req request) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+
+	c := s.d.Cpu
+	vars := []map[string]interface{}{}
+	switch args.VariablesReference {
+	case registersReference:
+		for i, v := range c.Registers {
+			vars = append(vars, variable(fmt.Sprintf("V%X", i), fmt.Sprintf("0x%02X", v)))
+		}
+		i := variable("I", fmt.Sprintf("0x%03X", c.IndexRegister))
+		i["memoryReference"] = fmt.Sprintf("0x%03X", c.IndexRegister)
+		vars = append(vars, i)
+		pc := variable("PC", fmt.Sprintf("0x%03X", c.PC))
+		pc["memoryReference"] = fmt.Sprintf("0x%03X", c.PC)
+		vars = append(vars, pc)
+		vars = append(vars, variable("SP", strconv.Itoa(c.StackPointer)))
+
+	case timersReference:
+		vars = append(vars, variable("DT", fmt.Sprintf("0x%02X", c.DelayTimer)))
+		vars = append(vars, variable("ST", fmt.Sprintf("0x%02X", c.SoundTimer)))
+
+	case stackReference:
+		for i := 0; i < c.StackPointer; i++ {
+			vars = append(vars, variable(fmt.Sprintf("[%d]", i), fmt.Sprintf("0x%03X", c.Stack[i])))
+		}
+	}
+
+	s.respond(req, map[string]interface{}{"variables": vars})
+}
+
+func (s *Server) setVariable(req request) {
+	var args struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+
+	l, err := debugger.ParseLocation(args.Name)
+	if err != nil {
+		s.fail(req, err.Error())
+		return
+	}
+	v, err := debugger.ParseValue(args.Value)
+	if err != nil {
+		s.fail(req, err.Error())
+		return
+	}
+	if err := s.d.Write(l, v); err != nil {
+		s.fail(req, err.Error())
+		return
+	}
+	s.respond(req, map[string]interface{}{"value": fmt.Sprintf("0x%02X", s.d.Read(l))})
+}
+
+func (s *Server) readMemory(req request) {
+	var args struct {
+		MemoryReference string `json:"memoryReference"`
+		Offset          int    `json:"offset"`
+		Count           int    `json:"count"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+
+	base, err := strconv.ParseUint(args.MemoryReference, 0, 16)
+	if err != nil {
+		s.fail(req, "invalid memory reference %q", args.MemoryReference)
+		return
+	}
+
+	if args.Count < 0 {
+		args.Count = 0
+	}
+	start := int(base) + args.Offset
+	end := start + args.Count
+	if start < 0 {
+		start = 0
+	}
+	if start > len(s.d.Cpu.Memory) {
+		start = len(s.d.Cpu.Memory)
+	}
+	if end > len(s.d.Cpu.Memory) {
+		end = len(s.d.Cpu.Memory)
+	}
+	if end < start {
+		end = start
+	}
+
+	s.respond(req, map[string]interface{}{
+		"address":         fmt.Sprintf("0x%03X", start),
+		"data":            base64.StdEncoding.EncodeToString(s.d.Cpu.Memory[start:end]),
+		"unreadableBytes": args.Count - (end - start),
+	})
+}
+
+func (s *Server) disassemble(req request) {
+	var args struct {
+		MemoryReference   string `json:"memoryReference"`
+		Offset            int    `json:"offset"`
+		InstructionOffset int    `json:"instructionOffset"`
+		InstructionCount  int    `json:"instructionCount"`
+	}
+	json.Unmarshal(req.Arguments, &args)
+
+	base, err := strconv.ParseUint(args.MemoryReference, 0, 16)
+	if err != nil {
+		s.fail(req, "invalid memory reference %q", args.MemoryReference)
+		return
+	}
+
+	c := s.d.Cpu
+	instructions := []map[string]interface{}{}
+	addr := int(base) + args.Offset + 2*args.InstructionOffset
+	for i := 0; i < args.InstructionCount; i, addr = i+1, addr+2 {
+		if addr < 0 || addr > 0x0FFE {
+			instructions = append(instructions, map[string]interface{}{
+				"address":     fmt.Sprintf("0x%X", addr&0xFFFF),
+				"instruction": "??",
+			})
+			continue
+		}
+
+		op := uint16(c.Memory[addr])<<8 | uint16(c.Memory[addr+1])
+		ins := map[string]interface{}{
+			"address":          fmt.Sprintf("0x%03X", addr),
+			"instructionBytes": fmt.Sprintf("%02X %02X", c.Memory[addr], c.Memory[addr+1]),
+			"instruction":      cpu.Disassemble(op),
+		}
+		if src, line := s.lookup(uint16(addr)); src != nil {
+			ins["location"] = src
+			ins["line"] = line
+		}
+		instructions = append(instructions, ins)
+	}
+
+	s.respond(req, map[string]interface{}{"instructions": instructions})
+}
@@ -0,0 +1,268 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// 0x200: LD V1, 0x05
+// 0x202: CALL 0x208
+// 0x204: JP 0x204
+// 0x206: (data)
+// 0x208: ADD V1, 0x01
+// 0x20A: RET
+var testRom = []byte{0x61, 0x05, 0x22, 0x08, 0x12, 0x04, 0x00, 0x00, 0x71, 0x01, 0x00, 0xEE}
+
+// client is a scripted DAP client. Messages from the adapter are read in the
+// background, so that the adapter never blocks on writing an event.
+type client struct {
+	t        *testing.T
+	w        io.Writer
+	messages chan message
+	seq      int
+}
+
+type message struct {
+	Type       string                 `json:"type"`
+	Command    string                 `json:"command"`
+	Event      string                 `json:"event"`
+	RequestSeq int                    `json:"request_seq"`
+	Success    bool                   `json:"success"`
+	Message    string                 `json:"message"`
+	Body       map[string]interface{} `json:"body"`
+}
+
+func (c *client) send(command string, args interface{}) int {
+	c.seq++
+	data, _ := json.Marshal(map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args})
+	fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(data), data)
+	return c.seq
+}
+
+func (c *client) read() message {
+	m, ok := <-c.messages
+	if !ok {
+		c.t.Fatal("adapter closed the connection")
+	}
+	return m
+}
+
+func readMessages(r *textproto.Reader, messages chan message) {
+	defer close(messages)
+	for {
+		header, err := r.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+		length, _ := strconv.Atoi(header.Get("Content-Length"))
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r.R, body); err != nil {
+			return
+		}
+		var m message
+		json.Unmarshal(body, &m)
+		messages <- m
+	}
+}
+
+// call sends a request and waits for its response.
+func (c *client) call(command string, args interface{}) message {
+	seq := c.send(command, args)
+	for {
+		m := c.read()
+		if m.Type == "response" && m.RequestSeq == seq {
+			return m
+		}
+	}
+}
+
+// wait reads messages until the given event arrives.
+func (c *client) wait(name string) message {
+	for {
+		m := c.read()
+		if m.Type == "event" && m.Event == name {
+			return m
+		}
+	}
+}
+
+func start(t *testing.T) (*client, chan error) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewServer().Serve(inR, outW)
+		outW.Close()
+	}()
+	messages := make(chan message, 100)
+	go readMessages(textproto.NewReader(bufio.NewReader(outR)), messages)
+	return &client{t: t, w: inW, messages: messages}, done
+}
+
+func writeRom(t *testing.T, dir string) string {
+	path := filepath.Join(dir, "test.ch8")
+	if err := ioutil.WriteFile(path, testRom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Test a session using the generated disassembly listing.
+func TestListingSession(t *testing.T) {
+	assert := asrt.New(t)
+
+	dir, _ := ioutil.TempDir("", "dap")
+	defer os.RemoveAll(dir)
+	rom := writeRom(t, dir)
+
+	c, done := start(t)
+
+	m := c.call("initialize", map[string]interface{}{"adapterID": "chip8"})
+	assert.True(m.Success)
+	assert.Equal(true, m.Body["supportsReadMemoryRequest"])
+
+	assert.False(c.call("stackTrace", nil).Success)
+
+	assert.True(c.call("launch", map[string]interface{}{"program": rom, "stopOnEntry": true}).Success)
+	c.wait("initialized")
+
+	// Line 5 of the listing is 0x208.
+	m = c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"name": "test.ch8.dis", "sourceReference": 1},
+		"breakpoints": []map[string]interface{}{{"line": 5}, {"line": 99}},
+	})
+	bps := m.Body["breakpoints"].([]interface{})
+	assert.Equal(true, bps[0].(map[string]interface{})["verified"])
+	assert.Equal(false, bps[1].(map[string]interface{})["verified"])
+
+	assert.True(c.call("configurationDone", nil).Success)
+	assert.Equal("entry", c.wait("stopped").Body["reason"])
+
+	m = c.call("source", map[string]interface{}{"sourceReference": 1})
+	assert.Contains(m.Body["content"], "0x208  7101  ADD V1, 0x01")
+
+	c.call("continue", map[string]interface{}{"threadId": 1})
+	assert.Equal("breakpoint", c.wait("stopped").Body["reason"])
+
+	m = c.call("stackTrace", map[string]interface{}{"threadId": 1})
+	frames := m.Body["stackFrames"].([]interface{})
+	assert.Len(frames, 2)
+	top := frames[0].(map[string]interface{})
+	assert.Equal("0x208", top["instructionPointerReference"])
+	assert.Equal(float64(5), top["line"])
+	assert.Equal("0x202", frames[1].(map[string]interface{})["instructionPointerReference"])
+
+	m = c.call("variables", map[string]interface{}{"variablesReference": registersReference})
+	vars := m.Body["variables"].([]interface{})
+	assert.Equal("0x05", vars[1].(map[string]interface{})["value"])
+
+	m = c.call("setVariable", map[string]interface{}{"variablesReference": registersReference, "name": "V1", "value": "0x10"})
+	assert.True(m.Success)
+	assert.False(c.call("setVariable", map[string]interface{}{"name": "V1", "value": "0x100"}).Success)
+
+	m = c.call("readMemory", map[string]interface{}{"memoryReference": "0x200", "count": 4})
+	assert.Equal("YQUiCA==", m.Body["data"])
+	m = c.call("readMemory", map[string]interface{}{"memoryReference": "0xFFE", "count": 4})
+	assert.Equal(float64(2), m.Body["unreadableBytes"])
+	m = c.call("readMemory", map[string]interface{}{"memoryReference": "0x200", "count": -4})
+	assert.Equal(float64(0), m.Body["unreadableBytes"])
+
+	m = c.call("disassemble", map[string]interface{}{"memoryReference": "0x200", "instructionCount": 2})
+	ins := m.Body["instructions"].([]interface{})
+	assert.Equal("LD V1, 0x05", ins[0].(map[string]interface{})["instruction"])
+
+	c.call("stepIn", map[string]interface{}{"threadId": 1})
+	assert.Equal("step", c.wait("stopped").Body["reason"])
+	m = c.call("variables", map[string]interface{}{"variablesReference": registersReference})
+	assert.Equal("0x11", m.Body["variables"].([]interface{})[1].(map[string]interface{})["value"])
+
+	c.call("stepOut", map[string]interface{}{"threadId": 1})
+	assert.Equal("step", c.wait("stopped").Body["reason"])
+	assert.False(c.call("stepOut", map[string]interface{}{"threadId": 1}).Success)
+
+	// Pause a running program.
+	c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"sourceReference": 1},
+		"breakpoints": []map[string]interface{}{},
+	})
+	c.call("continue", map[string]interface{}{"threadId": 1})
+	c.call("pause", map[string]interface{}{"threadId": 1})
+	assert.Equal("pause", c.wait("stopped").Body["reason"])
+
+	assert.True(c.call("disconnect", nil).Success)
+	assert.NoError(<-done)
+}
+
+// Test breakpoints on assembler source lines through a source map.
+func TestSourceMapSession(t *testing.T) {
+	assert := asrt.New(t)
+
+	dir, _ := ioutil.TempDir("", "dap")
+	defer os.RemoveAll(dir)
+	rom := writeRom(t, dir)
+	sm := filepath.Join(dir, "test.map")
+	ioutil.WriteFile(sm, []byte("0x200 src/test.asm:3\n0x202 src/test.asm:4\n0x208 src/test.asm:10\n"), 0644)
+
+	c, done := start(t)
+	c.call("initialize", nil)
+	assert.True(c.call("launch", map[string]interface{}{"program": rom, "sourceMap": sm}).Success)
+
+	m := c.call("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": "/home/user/project/src/test.asm"},
+		"breakpoints": []map[string]interface{}{{"line": 10, "condition": "V1 == 5"}},
+	})
+	assert.Equal(true, m.Body["breakpoints"].([]interface{})[0].(map[string]interface{})["verified"])
+
+	m = c.call("setInstructionBreakpoints", map[string]interface{}{
+		"breakpoints": []map[string]interface{}{{"instructionReference": "0x202"}},
+	})
+	assert.Equal(true, m.Body["breakpoints"].([]interface{})[0].(map[string]interface{})["verified"])
+
+	c.call("configurationDone", nil)
+	assert.Equal("breakpoint", c.wait("stopped").Body["reason"])
+
+	m = c.call("stackTrace", map[string]interface{}{"threadId": 1})
+	top := m.Body["stackFrames"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(float64(4), top["line"])
+	assert.Equal("src/test.asm", top["source"].(map[string]interface{})["path"])
+
+	c.call("continue", nil)
+	assert.Equal("breakpoint", c.wait("stopped").Body["reason"])
+	m = c.call("stackTrace", map[string]interface{}{"threadId": 1})
+	top = m.Body["stackFrames"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(float64(10), top["line"])
+
+	c.call("disconnect", nil)
+	assert.NoError(<-done)
+}
+
+// Test that a program that runs to completion terminates the session.
+func TestHalt(t *testing.T) {
+	assert := asrt.New(t)
+
+	dir, _ := ioutil.TempDir("", "dap")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "halt.ch8")
+	ioutil.WriteFile(path, []byte{0x61, 0x05}, 0644)
+
+	c, done := start(t)
+	c.call("initialize", nil)
+	assert.False(c.call("launch", map[string]interface{}{"program": filepath.Join(dir, "missing.ch8")}).Success)
+	assert.True(c.call("launch", map[string]interface{}{"program": path}).Success)
+	c.call("configurationDone", nil)
+	assert.Equal(float64(0), c.wait("exited").Body["exitCode"])
+	c.wait("terminated")
+
+	c.call("disconnect", nil)
+	assert.NoError(<-done)
+}
@@ -0,0 +1,416 @@
+package debugger
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// Debugger controls execution of a CPU one instruction at a time, stopping at
+// breakpoints and watchpoints along the way.
+type Debugger struct {
+	Cpu         *cpu.Cpu
+	Breakpoints map[uint16]*Breakpoint
+	Watchpoints []*Watchpoint
+	nextID      int
+
+	// frameSteps is the number of instructions left in the current 60 Hz
+	// frame. The timers count down when the next one starts.
+	frameSteps int
+}
+
+// Breakpoint stops execution before the instruction at Address is executed.
+// If Condition is set, the breakpoint only stops when it evaluates to true.
+type Breakpoint struct {
+	ID        int
+	Address   uint16
+	Condition *Condition
+	Hits      int
+}
+
+// Watchpoint stops execution after an instruction changes the value at
+// Location.
+type Watchpoint struct {
+	ID       int
+	Location Location
+	last     uint16
+}
+
+// StopReason describes why the debugger handed control back to the user.
+type StopReason int
+
+const (
+	StopStep StopReason = iota
+	StopBreakpoint
+	StopWatchpoint
+	StopHalt
+	StopFault
+	StopInterrupt
+)
+
+// Stop is returned by the run control methods to describe where and why
+// execution stopped.
+type Stop struct {
+	Reason     StopReason
+	Breakpoint *Breakpoint
+	Watchpoint *Watchpoint
+	Old        uint16
+	New        uint16
+	Err        error
+}
+
+func (s Stop) String() string {
+	switch s.Reason {
+	case StopBreakpoint:
+		return fmt.Sprintf("Breakpoint %d at 0x%03X", s.Breakpoint.ID, s.Breakpoint.Address)
+	case StopWatchpoint:
+		return fmt.Sprintf("Watchpoint %d: %s changed from 0x%X to 0x%X", s.Watchpoint.ID, s.Watchpoint.Location, s.Old, s.New)
+	case StopHalt:
+		return "Program halted"
+	case StopFault:
+		return "Fault: " + s.Err.Error()
+	case StopInterrupt:
+		return "Interrupted"
+	}
+	return ""
+}
+
+// New returns a debugger attached to the given CPU.
+func New(c *cpu.Cpu) *Debugger {
+	return &Debugger{
+		Cpu:         c,
+		Breakpoints: map[uint16]*Breakpoint{},
+	}
+}
+
+// AddBreakpoint sets a breakpoint at the given address, replacing any
+// breakpoint that was already there.
+func (d *Debugger) AddBreakpoint(addr uint16, cond *Condition) *Breakpoint {
+	d.nextID++
+	bp := &Breakpoint{ID: d.nextID, Address: addr, Condition: cond}
+	d.Breakpoints[addr] = bp
+	return bp
+}
+
+// AddWatchpoint starts watching the given location for changes.
+func (d *Debugger) AddWatchpoint(l Location) *Watchpoint {
+	d.nextID++
+	wp := &Watchpoint{ID: d.nextID, Location: l, last: d.Read(l)}
+	d.Watchpoints = append(d.Watchpoints, wp)
+	return wp
+}
+
+// Delete removes the breakpoint or watchpoint with the given ID.
+func (d *Debugger) Delete(id int) bool {
+	for addr, bp := range d.Breakpoints {
+		if bp.ID == id {
+			delete(d.Breakpoints, addr)
+			return true
+		}
+	}
+	for i, wp := range d.Watchpoints {
+		if wp.ID == id {
+			d.Watchpoints = append(d.Watchpoints[:i], d.Watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// SortedBreakpoints returns all breakpoints ordered by ID.
+func (d *Debugger) SortedBreakpoints() []*Breakpoint {
+	bps := make([]*Breakpoint, 0, len(d.Breakpoints))
+	for _, bp := range d.Breakpoints {
+		bps = append(bps, bp)
+	}
+	sort.Slice(bps, func(i, j int) bool { return bps[i].ID < bps[j].ID })
+	return bps
+}
+
+// Step executes a single instruction.
+func (d *Debugger) Step() Stop {
+	return d.runUntil(func() bool { return true }, nil)
+}
+
+// Next executes a single instruction, running subroutine calls to completion.
+func (d *Debugger) Next(interrupt <-chan struct{}) Stop {
+	c := d.Cpu
+	if c.Memory[c.PC&0x0FFF]&0xF0 != 0x20 {
+		return d.Step()
+	}
+
+	// The call has returned once the stack is back to its current depth.
+	sp := c.StackPointer
+	return d.runUntil(func() bool {
+		return c.StackPointer <= sp
+	}, interrupt)
+}
+
+// Finish runs until the current subroutine returns.
+func (d *Debugger) Finish(interrupt <-chan struct{}) (Stop, error) {
+	c := d.Cpu
+	if c.StackPointer == 0 {
+		return Stop{}, fmt.Errorf("not inside a subroutine")
+	}
+
+	sp := c.StackPointer
+	return d.runUntil(func() bool {
+		return c.StackPointer < sp
+	}, interrupt), nil
+}
+
+// Continue runs until a breakpoint or watchpoint is hit, the program halts or
+// faults, or the interrupt channel is signalled.
+func (d *Debugger) Continue(interrupt <-chan struct{}) Stop {
+	return d.runUntil(func() bool { return false }, interrupt)
+}
+
+// runUntil steps the CPU until done returns true or something else stops
+// execution. A breakpoint at the starting PC is ignored so that continuing
+// from a breakpoint makes progress.
+func (d *Debugger) runUntil(done func() bool, interrupt <-chan struct{}) Stop {
+	c := d.Cpu
+	for {
+		if interrupt != nil {
+			select {
+			case <-interrupt:
+				return Stop{Reason: StopInterrupt}
+			default:
+			}
+		}
+
+		for d.frameSteps == 0 {
+			d.frameSteps = c.StartFrame()
+		}
+		d.frameSteps--
+
+		c.ShouldHalt = false
+		err := c.Step()
+		if c.ShouldHalt {
+			return Stop{Reason: StopHalt}
+		}
+		if err != nil {
+			return Stop{Reason: StopFault, Err: err}
+		}
+
+		if c.ShouldDraw && c.UI != nil {
+			c.UI.Draw(c.Vram)
+			c.ShouldDraw = false
+		}
+
+		for _, wp := range d.Watchpoints {
+			v := d.Read(wp.Location)
+			if v != wp.last {
+				old := wp.last
+				wp.last = v
+				return Stop{Reason: StopWatchpoint, Watchpoint: wp, Old: old, New: v}
+			}
+		}
+
+		if done() {
+			return Stop{Reason: StopStep}
+		}
+
+		if bp, ok := d.Breakpoints[c.PC]; ok {
+			if bp.Condition == nil || bp.Condition.Eval(d) {
+				bp.Hits++
+				return Stop{Reason: StopBreakpoint, Breakpoint: bp}
+			}
+		}
+	}
+}
+
+// LocationKind identifies the kind of storage a Location refers to.
+type LocationKind int
+
+const (
+	LocRegister LocationKind = iota
+	LocIndex
+	LocPC
+	LocSP
+	LocDelayTimer
+	LocSoundTimer
+	LocMemory
+)
+
+// Location names a register or memory byte that can be read, written or
+// watched.
+type Location struct {
+	Kind  LocationKind
+	Index uint16
+}
+
+func (l Location) String() string {
+	switch l.Kind {
+	case LocRegister:
+		return fmt.Sprintf("V%X", l.Index)
+	case LocIndex:
+		return "I"
+	case LocPC:
+		return "PC"
+	case LocSP:
+		return "SP"
+	case LocDelayTimer:
+		return "DT"
+	case LocSoundTimer:
+		return "ST"
+	}
+	return fmt.Sprintf("[0x%03X]", l.Index)
+}
+
+// ParseLocation parses a register name (V0-VF, I, PC, SP, DT, ST) or a memory
+// address, optionally wrapped in brackets.
+func ParseLocation(s string) (Location, error) {
+	u := strings.ToUpper(strings.TrimSpace(s))
+	switch u {
+	case "I":
+		return Location{Kind: LocIndex}, nil
+	case "PC":
+		return Location{Kind: LocPC}, nil
+	case "SP":
+		return Location{Kind: LocSP}, nil
+	case "DT":
+		return Location{Kind: LocDelayTimer}, nil
+	case "ST":
+		return Location{Kind: LocSoundTimer}, nil
+	}
+
+	if len(u) == 2 && u[0] == 'V' {
+		r, err := strconv.ParseUint(u[1:], 16, 8)
+		if err == nil {
+			return Location{Kind: LocRegister, Index: uint16(r)}, nil
+		}
+	}
+
+	u = strings.TrimSuffix(strings.TrimPrefix(u, "["), "]")
+	addr, err := ParseValue(u)
+	if err != nil || addr > 0x0FFF {
+		return Location{}, fmt.Errorf("unknown location %q", s)
+	}
+	return Location{Kind: LocMemory, Index: addr}, nil
+}
+
+// ParseValue parses a number in decimal, or hex/octal/binary with the usual
+// Go prefixes.
+func ParseValue(s string) (uint16, error) {
+	v, err := strconv.ParseUint(strings.ToLower(s), 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	return uint16(v), nil
+}
+
+// Read returns the current value at the given location.
+func (d *Debugger) Read(l Location) uint16 {
+	c := d.Cpu
+	switch l.Kind {
+	case LocRegister:
+		return uint16(c.Registers[l.Index])
+	case LocIndex:
+		return c.IndexRegister
+	case LocPC:
+		return c.PC
+	case LocSP:
+		return uint16(c.StackPointer)
+	case LocDelayTimer:
+		return uint16(c.DelayTimer)
+	case LocSoundTimer:
+		return uint16(c.SoundTimer)
+	}
+	return uint16(c.Memory[l.Index])
+}
+
+// Write sets the value at the given location.
+func (d *Debugger) Write(l Location, v uint16) error {
+	c := d.Cpu
+	switch l.Kind {
+	case LocIndex:
+		c.IndexRegister = v
+	case LocPC:
+		if v > 0x0FFE {
+			return fmt.Errorf("PC must be below 0xFFF")
+		}
+		c.PC = v
+	case LocSP:
+		if int(v) > len(c.Stack) {
+			return fmt.Errorf("SP must be at most %d", len(c.Stack))
+		}
+		c.StackPointer = int(v)
+	default:
+		if v > 0xFF {
+			return fmt.Errorf("%s only holds a single byte", l)
+		}
+		switch l.Kind {
+		case LocRegister:
+			c.Registers[l.Index] = uint8(v)
+		case LocDelayTimer:
+			c.DelayTimer = uint8(v)
+		case LocSoundTimer:
+			c.SoundTimer = uint8(v)
+		case LocMemory:
+			c.Memory[l.Index] = uint8(v)
+		}
+	}
+
+	// Keep watchpoints from firing on changes made by the user.
+	for _, wp := range d.Watchpoints {
+		wp.last = d.Read(wp.Location)
+	}
+	return nil
+}
+
+// Condition compares a location against a constant, e.g. "V3 == 0x10".
+type Condition struct {
+	Location Location
+	Op       string
+	Value    uint16
+}
+
+func (c *Condition) String() string {
+	return fmt.Sprintf("%s %s 0x%X", c.Location, c.Op, c.Value)
+}
+
+var conditionOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// ParseCondition parses an expression of the form "LOCATION OP VALUE".
+func ParseCondition(s string) (*Condition, error) {
+	for _, op := range conditionOps {
+		i := strings.Index(s, op)
+		if i < 0 {
+			continue
+		}
+		l, err := ParseLocation(s[:i])
+		if err != nil {
+			return nil, err
+		}
+		v, err := ParseValue(strings.TrimSpace(s[i+len(op):]))
+		if err != nil {
+			return nil, err
+		}
+		return &Condition{Location: l, Op: op, Value: v}, nil
+	}
+	return nil, fmt.Errorf("invalid condition %q", s)
+}
+
+// Eval reports whether the condition currently holds.
+func (c *Condition) Eval(d *Debugger) bool {
+	v := d.Read(c.Location)
+	switch c.Op {
+	case "==":
+		return v == c.Value
+	case "!=":
+		return v != c.Value
+	case "<":
+		return v < c.Value
+	case "<=":
+		return v <= c.Value
+	case ">":
+		return v > c.Value
+	case ">=":
+		return v >= c.Value
+	}
+	return false
+}
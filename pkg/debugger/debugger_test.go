@@ -0,0 +1,208 @@
+package debugger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// A short program with a subroutine:
+//
+//	0x200: CALL 0x206
+//	0x202: JP 0x202
+//	0x204: (data)
+//	0x206: ADD V1, 0x02
+//	0x208: RET
+var testRom = []byte{
+	0x22, 0x06,
+	0x12, 0x02,
+	0x00, 0x00,
+	0x71, 0x02,
+	0x00, 0xEE,
+}
+
+func newTestDebugger() *Debugger {
+	return New(cpu.NewCpu(nil, testRom))
+}
+
+// Test that continue stops at breakpoints, and conditional breakpoints only
+// stop when their condition holds.
+func TestBreakpoints(t *testing.T) {
+	assert := asrt.New(t)
+	d := newTestDebugger()
+
+	d.AddBreakpoint(0x206, nil)
+	stop := d.Continue(nil)
+	assert.Equal(StopBreakpoint, stop.Reason)
+	assert.Equal(uint16(0x206), d.Cpu.PC)
+	assert.Equal(1, stop.Breakpoint.Hits)
+
+	// A loop that counts V0 up, with a breakpoint that only fires at 5.
+	//	0x200: ADD V0, 0x01
+	//	0x202: JP 0x200
+	d = New(cpu.NewCpu(nil, []byte{0x70, 0x01, 0x12, 0x00}))
+	cond, err := ParseCondition("V0 == 0x05")
+	assert.NoError(err)
+	d.AddBreakpoint(0x200, cond)
+	stop = d.Continue(nil)
+	assert.Equal(StopBreakpoint, stop.Reason)
+	assert.Equal(uint16(0x200), d.Cpu.PC)
+	assert.Equal(uint8(5), d.Cpu.Registers[0])
+
+	// The loop never ends, so the only way out is an interrupt.
+	interrupt := make(chan struct{}, 1)
+	interrupt <- struct{}{}
+	stop = d.Continue(interrupt)
+	assert.Equal(StopInterrupt, stop.Reason)
+}
+
+// Test that watchpoints stop after the watched value changes.
+func TestWatchpoints(t *testing.T) {
+	assert := asrt.New(t)
+	d := newTestDebugger()
+
+	l, err := ParseLocation("v1")
+	assert.NoError(err)
+	d.AddWatchpoint(l)
+
+	stop := d.Continue(nil)
+	assert.Equal(StopWatchpoint, stop.Reason)
+	assert.Equal(uint16(0), stop.Old)
+	assert.Equal(uint16(2), stop.New)
+	assert.Equal(uint16(0x208), d.Cpu.PC)
+
+	// Changes made through the debugger don't trigger the watchpoint.
+	assert.NoError(d.Write(l, 0x10))
+	stop = d.Step()
+	assert.Equal(StopStep, stop.Reason)
+}
+
+// Test that next steps over calls and finish runs until the return.
+func TestNextAndFinish(t *testing.T) {
+	assert := asrt.New(t)
+	d := newTestDebugger()
+
+	stop := d.Next(nil)
+	assert.Equal(StopStep, stop.Reason)
+	assert.Equal(0, d.Cpu.StackPointer)
+	assert.Equal(uint8(2), d.Cpu.Registers[1])
+
+	_, err := d.Finish(nil)
+	assert.Error(err)
+
+	d = newTestDebugger()
+	d.Step()
+	assert.Equal(uint16(0x206), d.Cpu.PC)
+	assert.Equal(1, d.Cpu.StackPointer)
+	stop, err = d.Finish(nil)
+	assert.NoError(err)
+	assert.Equal(StopStep, stop.Reason)
+	assert.Equal(0, d.Cpu.StackPointer)
+	assert.Equal(uint8(2), d.Cpu.Registers[1])
+}
+
+// Test that the timers count down once per frame of instructions, not once
+// per instruction.
+func TestTimers(t *testing.T) {
+	assert := asrt.New(t)
+	d := newTestDebugger()
+	d.Cpu.SetClockSpeed(120)
+	d.Cpu.DelayTimer = 10
+
+	// The first instruction starts the first frame.
+	d.Step()
+	assert.Equal(uint8(9), d.Cpu.DelayTimer)
+	d.Step()
+	assert.Equal(uint8(9), d.Cpu.DelayTimer)
+	for i := 0; i < 4; i++ {
+		d.Step()
+	}
+	assert.Equal(uint8(7), d.Cpu.DelayTimer)
+}
+
+// Test that halting and faulting programs stop the debugger.
+func TestHaltAndFault(t *testing.T) {
+	assert := asrt.New(t)
+
+	d := New(cpu.NewCpu(nil, []byte{0x60, 0x01}))
+	assert.Equal(StopHalt, d.Continue(nil).Reason)
+
+	d = New(cpu.NewCpu(nil, []byte{0xFF, 0xFF}))
+	stop := d.Continue(nil)
+	assert.Equal(StopFault, stop.Reason)
+	assert.Error(stop.Err)
+}
+
+// Test parsing of locations and conditions.
+func TestParse(t *testing.T) {
+	assert := asrt.New(t)
+
+	for s, expected := range map[string]Location{
+		"VA":      {Kind: LocRegister, Index: 0xA},
+		"i":       {Kind: LocIndex},
+		"pc":      {Kind: LocPC},
+		"SP":      {Kind: LocSP},
+		"dt":      {Kind: LocDelayTimer},
+		"ST":      {Kind: LocSoundTimer},
+		"0x300":   {Kind: LocMemory, Index: 0x300},
+		"[0x300]": {Kind: LocMemory, Index: 0x300},
+		"512":     {Kind: LocMemory, Index: 0x200},
+	} {
+		l, err := ParseLocation(s)
+		assert.NoError(err, s)
+		assert.Equal(expected, l, s)
+	}
+
+	_, err := ParseLocation("VG")
+	assert.Error(err)
+	_, err = ParseLocation("0x1000")
+	assert.Error(err)
+
+	cond, err := ParseCondition("VA>=0x10")
+	assert.NoError(err)
+	assert.Equal(">=", cond.Op)
+	assert.Equal(uint16(0x10), cond.Value)
+
+	_, err = ParseCondition("VA")
+	assert.Error(err)
+}
+
+// Test a scripted REPL session.
+func TestREPL(t *testing.T) {
+	assert := asrt.New(t)
+
+	out := &bytes.Buffer{}
+	r := &REPL{
+		Debugger: newTestDebugger(),
+		In: strings.NewReader(strings.Join([]string{
+			"break 0x206",
+			"continue",
+			"stack",
+			"regs",
+			"set V5 0x42",
+			"x 0x200 4",
+			"disas 0x206 1",
+			"finish",
+			"",
+			"bogus",
+			"quit",
+		}, "\n")),
+		Out: out,
+	}
+
+	assert.NoError(r.Run())
+	assert.Equal(uint8(0x42), r.Debugger.Cpu.Registers[5])
+
+	s := out.String()
+	assert.Contains(s, "Breakpoint 1 at 0x206")
+	assert.Contains(s, "=> 0x206:  7102  ADD V1, 0x02")
+	assert.Contains(s, "#1  0x200")
+	assert.Contains(s, "I=000 PC=206 SP=1")
+	assert.Contains(s, "200: 22 06 12 02")
+	assert.Contains(s, "=>*0x206:  7102  ADD V1, 0x02")
+	assert.Contains(s, "Error: not inside a subroutine")
+	assert.Contains(s, "Error: unknown command \"bogus\"")
+}
@@ -0,0 +1,321 @@
+package debugger
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+const helpText = `Commands:
+  break ADDR [if COND]  Set a breakpoint, optionally conditional (e.g. "break 0x20A if V3 == 5")
+  watch LOC             Stop when a register (V0-VF, I, PC, SP, DT, ST) or memory address changes
+  delete ID             Remove a breakpoint or watchpoint
+  info                  List breakpoints and watchpoints
+  step [N]              Execute N instructions (default 1)
+  next                  Execute one instruction, stepping over subroutine calls
+  finish                Run until the current subroutine returns
+  continue              Run until a breakpoint, watchpoint, halt or interrupt
+  regs                  Show registers and timers
+  stack                 Show the call stack
+  x ADDR [LEN]          Hexdump LEN bytes of memory (default 64)
+  disas [ADDR] [N]      Disassemble N instructions around ADDR (default PC)
+  set LOC VALUE         Change a register or memory byte
+  quit                  Exit the debugger
+An empty line repeats the previous command.
+`
+
+// REPL reads debugger commands from In and writes results to Out.
+type REPL struct {
+	Debugger *Debugger
+	In       io.Reader
+	Out      io.Writer
+
+	// Interrupt is checked while the program is running, and stops execution
+	// when signalled.
+	Interrupt <-chan struct{}
+}
+
+// Run reads and executes commands until the input is exhausted or the user
+// quits.
+func (r *REPL) Run() error {
+	scanner := bufio.NewScanner(r.In)
+	last := ""
+
+	r.printLocation()
+	for {
+		fmt.Fprint(r.Out, "(chip8) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(r.Out)
+			return scanner.Err()
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			line = last
+		}
+		if line == "" {
+			continue
+		}
+		last = line
+
+		quit, err := r.Exec(line)
+		if err != nil {
+			fmt.Fprintln(r.Out, "Error: "+err.Error())
+		}
+		if quit {
+			return nil
+		}
+	}
+}
+
+// Exec runs a single command line. It returns true if the user asked to quit.
+func (r *REPL) Exec(line string) (bool, error) {
+	d := r.Debugger
+	fields := strings.Fields(line)
+	args := fields[1:]
+
+	switch fields[0] {
+	case "help", "h", "?":
+		fmt.Fprint(r.Out, helpText)
+
+	case "break", "b":
+		if len(args) == 0 {
+			return false, fmt.Errorf("usage: break ADDR [if COND]")
+		}
+		addr, err := ParseValue(args[0])
+		if err != nil {
+			return false, err
+		}
+		var cond *Condition
+		if len(args) > 1 {
+			if args[1] != "if" || len(args) < 3 {
+				return false, fmt.Errorf("usage: break ADDR [if COND]")
+			}
+			cond, err = ParseCondition(strings.Join(args[2:], " "))
+			if err != nil {
+				return false, err
+			}
+		}
+		bp := d.AddBreakpoint(addr, cond)
+		fmt.Fprintf(r.Out, "Breakpoint %d at 0x%03X\n", bp.ID, bp.Address)
+
+	case "watch", "w":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: watch LOC")
+		}
+		l, err := ParseLocation(args[0])
+		if err != nil {
+			return false, err
+		}
+		wp := d.AddWatchpoint(l)
+		fmt.Fprintf(r.Out, "Watchpoint %d: %s\n", wp.ID, wp.Location)
+
+	case "delete", "d":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: delete ID")
+		}
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return false, fmt.Errorf("invalid ID %q", args[0])
+		}
+		if !d.Delete(id) {
+			return false, fmt.Errorf("no breakpoint or watchpoint %d", id)
+		}
+
+	case "info", "i":
+		for _, bp := range d.SortedBreakpoints() {
+			fmt.Fprintf(r.Out, "%d\tbreakpoint\t0x%03X", bp.ID, bp.Address)
+			if bp.Condition != nil {
+				fmt.Fprintf(r.Out, " if %s", bp.Condition)
+			}
+			fmt.Fprintf(r.Out, "\thits=%d\n", bp.Hits)
+		}
+		for _, wp := range d.Watchpoints {
+			fmt.Fprintf(r.Out, "%d\twatchpoint\t%s\n", wp.ID, wp.Location)
+		}
+
+	case "step", "s":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			n, err = strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return false, fmt.Errorf("invalid count %q", args[0])
+			}
+		}
+		for i := 0; i < n; i++ {
+			stop := d.Step()
+			if stop.Reason != StopStep {
+				r.report(stop)
+				return false, nil
+			}
+		}
+		r.printLocation()
+
+	case "next", "n":
+		r.drainInterrupt()
+		r.report(d.Next(r.Interrupt))
+
+	case "finish", "f":
+		r.drainInterrupt()
+		stop, err := d.Finish(r.Interrupt)
+		if err != nil {
+			return false, err
+		}
+		r.report(stop)
+
+	case "continue", "c":
+		r.drainInterrupt()
+		r.report(d.Continue(r.Interrupt))
+
+	case "regs", "r":
+		r.printRegisters()
+
+	case "stack", "bt":
+		c := d.Cpu
+		fmt.Fprintf(r.Out, "#0  0x%03X\n", c.PC)
+		for i := c.StackPointer - 1; i >= 0; i-- {
+			fmt.Fprintf(r.Out, "#%d  0x%03X\n", c.StackPointer-i, c.Stack[i])
+		}
+
+	case "x":
+		if len(args) == 0 {
+			return false, fmt.Errorf("usage: x ADDR [LEN]")
+		}
+		addr, err := ParseValue(args[0])
+		if err != nil {
+			return false, err
+		}
+		length := uint16(64)
+		if len(args) > 1 {
+			length, err = ParseValue(args[1])
+			if err != nil {
+				return false, err
+			}
+		}
+		r.hexdump(addr, length)
+
+	case "disas", "l":
+		addr := d.Cpu.PC
+		n := 5
+		if len(args) > 0 {
+			var err error
+			addr, err = ParseValue(args[0])
+			if err != nil {
+				return false, err
+			}
+		}
+		if len(args) > 1 {
+			var err error
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 0 {
+				return false, fmt.Errorf("invalid count %q", args[1])
+			}
+		}
+		r.disassemble(addr, n)
+
+	case "set":
+		if len(args) != 2 {
+			return false, fmt.Errorf("usage: set LOC VALUE")
+		}
+		l, err := ParseLocation(args[0])
+		if err != nil {
+			return false, err
+		}
+		v, err := ParseValue(args[1])
+		if err != nil {
+			return false, err
+		}
+		return false, d.Write(l, v)
+
+	case "quit", "q", "exit":
+		return true, nil
+
+	default:
+		return false, fmt.Errorf("unknown command %q, try \"help\"", fields[0])
+	}
+
+	return false, nil
+}
+
+// drainInterrupt discards interrupts that arrived while waiting at the prompt.
+func (r *REPL) drainInterrupt() {
+	for {
+		select {
+		case <-r.Interrupt:
+		default:
+			return
+		}
+	}
+}
+
+func (r *REPL) report(stop Stop) {
+	if msg := stop.String(); msg != "" {
+		fmt.Fprintln(r.Out, msg)
+	}
+	r.printLocation()
+}
+
+func (r *REPL) printLocation() {
+	c := r.Debugger.Cpu
+	op := uint16(c.Memory[c.PC&0x0FFF])<<8 | uint16(c.Memory[(c.PC+1)&0x0FFF])
+	fmt.Fprintf(r.Out, "=> 0x%03X:  %04X  %s\n", c.PC, op, cpu.Disassemble(op))
+}
+
+func (r *REPL) printRegisters() {
+	c := r.Debugger.Cpu
+	for i, v := range c.Registers {
+		fmt.Fprintf(r.Out, "V%X=%02X", i, v)
+		if i%8 == 7 {
+			fmt.Fprintln(r.Out)
+		} else {
+			fmt.Fprint(r.Out, " ")
+		}
+	}
+	fmt.Fprintf(r.Out, "I=%03X PC=%03X SP=%d DT=%02X ST=%02X\n", c.IndexRegister, c.PC, c.StackPointer, c.DelayTimer, c.SoundTimer)
+}
+
+func (r *REPL) hexdump(addr, length uint16) {
+	c := r.Debugger.Cpu
+	for row := uint32(addr) &^ 0xF; row < uint32(addr)+uint32(length) && row < 0x1000; row += 16 {
+		fmt.Fprintf(r.Out, "%03X: ", row)
+		for col := uint32(0); col < 16; col++ {
+			a := row + col
+			if a < uint32(addr) || a >= uint32(addr)+uint32(length) || a >= 0x1000 {
+				fmt.Fprint(r.Out, "   ")
+				continue
+			}
+			fmt.Fprintf(r.Out, "%02X ", c.Memory[a])
+		}
+		fmt.Fprintln(r.Out)
+	}
+}
+
+func (r *REPL) disassemble(addr uint16, n int) {
+	c := r.Debugger.Cpu
+	start := int(addr) - 2*n
+	if start < 0 {
+		start = int(addr) % 2
+	}
+	end := int(addr) + 2*n
+	if end > 0x0FFE {
+		end = 0x0FFE
+	}
+
+	for a := start; a <= end; a += 2 {
+		op := uint16(c.Memory[a])<<8 | uint16(c.Memory[a+1])
+		marker := "  "
+		if uint16(a) == c.PC {
+			marker = "=>"
+		}
+		bp := " "
+		if _, ok := r.Debugger.Breakpoints[uint16(a)]; ok {
+			bp = "*"
+		}
+		fmt.Fprintf(r.Out, "%s%s0x%03X:  %04X  %s\n", marker, bp, a, op, cpu.Disassemble(op))
+	}
+}
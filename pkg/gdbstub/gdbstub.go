@@ -0,0 +1,471 @@
+// Package gdbstub implements the GDB Remote Serial Protocol so that existing
+// debuggers can attach to the emulator over TCP.
+//
+// Registers are exposed in the order V0-VF, I, PC, SP, DT, ST. CHIP-8 is a
+// big-endian machine, so the 16 bit registers are sent most significant byte
+// first; clients should "set endian big" before connecting.
+package gdbstub
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/debugger"
+)
+
+// TargetXML describes the register layout to the debugger.
+const TargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.cweagans.chip8.core">
+    <reg name="v0" bitsize="8" type="uint8" regnum="0"/>
+    <reg name="v1" bitsize="8" type="uint8"/>
+    <reg name="v2" bitsize="8" type="uint8"/>
+    <reg name="v3" bitsize="8" type="uint8"/>
+    <reg name="v4" bitsize="8" type="uint8"/>
+    <reg name="v5" bitsize="8" type="uint8"/>
+    <reg name="v6" bitsize="8" type="uint8"/>
+    <reg name="v7" bitsize="8" type="uint8"/>
+    <reg name="v8" bitsize="8" type="uint8"/>
+    <reg name="v9" bitsize="8" type="uint8"/>
+    <reg name="va" bitsize="8" type="uint8"/>
+    <reg name="vb" bitsize="8" type="uint8"/>
+    <reg name="vc" bitsize="8" type="uint8"/>
+    <reg name="vd" bitsize="8" type="uint8"/>
+    <reg name="ve" bitsize="8" type="uint8"/>
+    <reg name="vf" bitsize="8" type="uint8"/>
+    <reg name="i" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+    <reg name="sp" bitsize="8" type="uint8"/>
+    <reg name="dt" bitsize="8" type="uint8"/>
+    <reg name="st" bitsize="8" type="uint8"/>
+  </feature>
+</target>
+`
+
+// registers lists the locations behind each register number, along with
+// their size in bytes.
+var registers = []struct {
+	loc  debugger.Location
+	size int
+}{
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0x0}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0x1}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0x2}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0x3}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0x4}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0x5}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0x6}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0x7}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0x8}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0x9}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0xA}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0xB}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0xC}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0xD}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0xE}, 1},
+	{debugger.Location{Kind: debugger.LocRegister, Index: 0xF}, 1},
+	{debugger.Location{Kind: debugger.LocIndex}, 2},
+	{debugger.Location{Kind: debugger.LocPC}, 2},
+	{debugger.Location{Kind: debugger.LocSP}, 1},
+	{debugger.Location{Kind: debugger.LocDelayTimer}, 1},
+	{debugger.Location{Kind: debugger.LocSoundTimer}, 1},
+}
+
+// Server is a GDB remote stub for a single debugging session.
+type Server struct {
+	Debugger *debugger.Debugger
+
+	mu        sync.Mutex
+	w         *bufio.Writer
+	packets   chan string
+	interrupt chan struct{}
+	readErr   error
+
+	// done is closed when Serve returns, so that the reader stops waiting to
+	// hand over packets that will never be handled.
+	done chan struct{}
+}
+
+// NewServer returns a server that controls the given debugger.
+func NewServer(d *debugger.Debugger) *Server {
+	return &Server{Debugger: d}
+}
+
+// ListenAndServe accepts connections on addr and serves them one at a time
+// until the listener fails.
+func ListenAndServe(addr string, d *debugger.Debugger) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		err = NewServer(d).Serve(conn)
+		conn.Close()
+		if err != nil && err != io.EOF {
+			return err
+		}
+	}
+}
+
+// Serve handles packets from conn until the client detaches, kills the
+// target or disconnects.
+func (s *Server) Serve(conn io.ReadWriter) error {
+	s.w = bufio.NewWriter(conn)
+	s.packets = make(chan string, 16)
+	s.interrupt = make(chan struct{}, 1)
+	s.done = make(chan struct{})
+	defer close(s.done)
+	go s.read(bufio.NewReader(conn))
+
+	for pkt := range s.packets {
+		reply, done := s.handle(pkt)
+		if err := s.send(reply); err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+	return s.readErr
+}
+
+// read splits the incoming stream into packets, acknowledging each one, and
+// turns bare 0x03 bytes into interrupts. It returns when the connection is
+// closed or Serve has returned.
+func (s *Server) read(r *bufio.Reader) {
+	defer close(s.packets)
+	noAck := false
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			s.readErr = err
+			return
+		}
+
+		switch b {
+		case 0x03:
+			select {
+			case s.interrupt <- struct{}{}:
+			default:
+			}
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				s.readErr = err
+				return
+			}
+			data = strings.TrimSuffix(data, "#")
+			sum := make([]byte, 2)
+			if _, err := io.ReadFull(r, sum); err != nil {
+				s.readErr = err
+				return
+			}
+
+			if !noAck {
+				expected, _ := strconv.ParseUint(string(sum), 16, 8)
+				if byte(expected) != checksum(data) {
+					s.write("-")
+					continue
+				}
+				s.write("+")
+			}
+
+			// Acknowledgements stop straight after the request to do so.
+			if data == "QStartNoAckMode" {
+				noAck = true
+			}
+			select {
+			case s.packets <- data:
+			case <-s.done:
+				return
+			}
+		}
+	}
+}
+
+// write sends raw bytes and flushes them immediately.
+func (s *Server) write(raw string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.w.WriteString(raw); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *Server) send(data string) error {
+	return s.write(fmt.Sprintf("$%s#%02x", data, checksum(data)))
+}
+
+func checksum(data string) byte {
+	var sum byte
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+// handle processes a single packet and returns the reply. The second return
+// value is true when the session should end.
+func (s *Server) handle(pkt string) (string, bool) {
+	d := s.Debugger
+	if pkt == "" {
+		return "", false
+	}
+
+	switch pkt[0] {
+	case '?':
+		return "S05", false
+
+	case 'g':
+		var b strings.Builder
+		for _, r := range registers {
+			b.WriteString(encodeRegister(d.Read(r.loc), r.size))
+		}
+		return b.String(), false
+
+	case 'G':
+		data := pkt[1:]
+		for _, r := range registers {
+			if len(data) < r.size*2 {
+				return "E01", false
+			}
+			v, err := strconv.ParseUint(data[:r.size*2], 16, 16)
+			if err != nil {
+				return "E01", false
+			}
+			if err := d.Write(r.loc, uint16(v)); err != nil {
+				return "E02", false
+			}
+			data = data[r.size*2:]
+		}
+		return "OK", false
+
+	case 'p':
+		n, err := strconv.ParseUint(pkt[1:], 16, 8)
+		if err != nil || int(n) >= len(registers) {
+			return "E01", false
+		}
+		r := registers[n]
+		return encodeRegister(d.Read(r.loc), r.size), false
+
+	case 'P':
+		parts := strings.SplitN(pkt[1:], "=", 2)
+		if len(parts) != 2 {
+			return "E01", false
+		}
+		n, err := strconv.ParseUint(parts[0], 16, 8)
+		if err != nil || int(n) >= len(registers) {
+			return "E01", false
+		}
+		v, err := strconv.ParseUint(parts[1], 16, 16)
+		if err != nil {
+			return "E01", false
+		}
+		if err := d.Write(registers[n].loc, uint16(v)); err != nil {
+			return "E02", false
+		}
+		return "OK", false
+
+	case 'm':
+		addr, length, ok := parseAddrLength(pkt[1:])
+		if !ok || addr+length > 0x1000 {
+			return "E01", false
+		}
+		return hex.EncodeToString(d.Cpu.Memory[addr : addr+length]), false
+
+	case 'M':
+		parts := strings.SplitN(pkt[1:], ":", 2)
+		if len(parts) != 2 {
+			return "E01", false
+		}
+		addr, length, ok := parseAddrLength(parts[0])
+		if !ok || addr+length > 0x1000 {
+			return "E01", false
+		}
+		data, err := hex.DecodeString(parts[1])
+		if err != nil || len(data) != length {
+			return "E01", false
+		}
+		for i, b := range data {
+			d.Write(debugger.Location{Kind: debugger.LocMemory, Index: uint16(addr + i)}, uint16(b))
+		}
+		return "OK", false
+
+	case 'c', 's':
+		if len(pkt) > 1 {
+			addr, err := strconv.ParseUint(pkt[1:], 16, 16)
+			if err != nil || d.Write(debugger.Location{Kind: debugger.LocPC}, uint16(addr)) != nil {
+				return "E01", false
+			}
+		}
+
+		var stop debugger.Stop
+		if pkt[0] == 's' {
+			stop = d.Step()
+		} else {
+			stop = d.Continue(s.interrupt)
+		}
+		return stopReply(stop), false
+
+	case 'Z', 'z':
+		parts := strings.Split(pkt[1:], ",")
+		if len(parts) < 3 {
+			return "E01", false
+		}
+		addr, err := strconv.ParseUint(parts[1], 16, 16)
+		if err != nil || addr > 0x0FFF {
+			return "E01", false
+		}
+
+		switch parts[0] {
+		case "0", "1":
+			// Software and hardware breakpoints are the same thing here.
+			if pkt[0] == 'Z' {
+				d.AddBreakpoint(uint16(addr), nil)
+			} else if bp, ok := d.Breakpoints[uint16(addr)]; ok {
+				d.Delete(bp.ID)
+			}
+			return "OK", false
+
+		case "2":
+			// Write watchpoints cover each byte in the range.
+			length, err := strconv.ParseUint(parts[2], 16, 16)
+			if err != nil || addr+length > 0x1000 {
+				return "E01", false
+			}
+			for a := addr; a < addr+length; a++ {
+				l := debugger.Location{Kind: debugger.LocMemory, Index: uint16(a)}
+				if pkt[0] == 'Z' {
+					d.AddWatchpoint(l)
+					continue
+				}
+				for _, wp := range d.Watchpoints {
+					if wp.Location == l {
+						d.Delete(wp.ID)
+						break
+					}
+				}
+			}
+			return "OK", false
+		}
+
+		// Read and access watchpoints can't be detected.
+		return "", false
+
+	case 'H':
+		return "OK", false
+
+	case 'D':
+		return "OK", true
+
+	case 'k':
+		return "", true
+
+	case 'q', 'Q':
+		return s.query(pkt), false
+	}
+
+	return "", false
+}
+
+// query handles the general query packets.
+func (s *Server) query(pkt string) string {
+	switch {
+	case strings.HasPrefix(pkt, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+;swbreak+;hwbreak+"
+
+	case pkt == "QStartNoAckMode":
+		return "OK"
+
+	case strings.HasPrefix(pkt, "qXfer:features:read:target.xml:"):
+		offset, length, ok := parseAddrLength(strings.TrimPrefix(pkt, "qXfer:features:read:target.xml:"))
+		if !ok {
+			return "E01"
+		}
+		if offset >= len(TargetXML) {
+			return "l"
+		}
+		end := offset + length
+		if end >= len(TargetXML) {
+			return "l" + TargetXML[offset:]
+		}
+		return "m" + TargetXML[offset:end]
+
+	case pkt == "qAttached":
+		return "1"
+
+	case pkt == "qC":
+		return "QC1"
+
+	case pkt == "qfThreadInfo":
+		return "m1"
+
+	case pkt == "qsThreadInfo":
+		return "l"
+	}
+
+	return ""
+}
+
+// stopReply converts a debugger stop into a stop reply packet.
+func stopReply(stop debugger.Stop) string {
+	switch stop.Reason {
+	case debugger.StopBreakpoint:
+		return "T05swbreak:;"
+	case debugger.StopWatchpoint:
+		if stop.Watchpoint.Location.Kind == debugger.LocMemory {
+			return fmt.Sprintf("T05watch:%x;", stop.Watchpoint.Location.Index)
+		}
+		return "T05"
+	case debugger.StopHalt:
+		return "W00"
+	case debugger.StopFault:
+		// SIGSEGV for stack overflows and underflows, and SIGILL for
+		// unknown opcodes.
+		if _, ok := stop.Err.(*cpu.StackError); ok {
+			return "S0b"
+		}
+		return "S04"
+	case debugger.StopInterrupt:
+		return "S02"
+	}
+	return "S05"
+}
+
+func encodeRegister(v uint16, size int) string {
+	if size == 1 {
+		return fmt.Sprintf("%02x", v)
+	}
+	return fmt.Sprintf("%04x", v)
+}
+
+func parseAddrLength(s string) (int, int, bool) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	addr, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	length, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	return int(addr), int(length), true
+}
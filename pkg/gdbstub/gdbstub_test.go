@@ -0,0 +1,226 @@
+package gdbstub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/debugger"
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// client is a minimal scripted RSP client.
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	ack  bool
+}
+
+// call sends a packet and returns the reply.
+func (c *client) call(data string) string {
+	fmt.Fprintf(c.conn, "$%s#%02x", data, checksum(data))
+	if c.ack {
+		b, err := c.r.ReadByte()
+		if err != nil || b != '+' {
+			c.t.Fatalf("expected ack for %q, got %q (%v)", data, b, err)
+		}
+	}
+	return c.reply()
+}
+
+func (c *client) reply() string {
+	if _, err := c.r.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+	data, err := c.r.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	sum := make([]byte, 2)
+	if _, err := c.r.Read(sum); err != nil {
+		c.t.Fatal(err)
+	}
+	data = strings.TrimSuffix(data, "#")
+	if fmt.Sprintf("%02x", checksum(data)) != string(sum) {
+		c.t.Fatalf("bad checksum on %q", data)
+	}
+	if c.ack {
+		c.conn.Write([]byte("+"))
+	}
+	return data
+}
+
+// Start a stub on a local TCP port and connect to it.
+func dial(t *testing.T, rom []byte) (*client, *debugger.Debugger, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := debugger.New(cpu.NewCpu(nil, rom))
+	done := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		l.Close()
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- NewServer(d).Serve(conn)
+		conn.Close()
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &client{t: t, conn: conn, r: bufio.NewReader(conn), ack: true}, d, done
+}
+
+// Test a typical session: feature negotiation, target description, register
+// and memory access, breakpoints, stepping and detaching.
+func TestSession(t *testing.T) {
+	assert := asrt.New(t)
+
+	// 0x200: LD V1, 0x05
+	// 0x202: LD I, 0x300
+	// 0x204: ADD V1, 0x01
+	// 0x206: JP 0x204
+	c, d, done := dial(t, []byte{0x61, 0x05, 0xA3, 0x00, 0x71, 0x01, 0x12, 0x04})
+	defer c.conn.Close()
+
+	assert.Contains(c.call("qSupported:multiprocess+;swbreak+"), "qXfer:features:read+")
+	assert.Equal("OK", c.call("QStartNoAckMode"))
+	c.ack = false
+
+	// Read the target description in two chunks.
+	xml := c.call("qXfer:features:read:target.xml:0,64")
+	assert.Equal(byte('m'), xml[0])
+	rest := c.call("qXfer:features:read:target.xml:64,1000")
+	assert.Equal(byte('l'), rest[0])
+	assert.Equal(TargetXML, xml[1:]+rest[1:])
+
+	assert.Equal("S05", c.call("?"))
+
+	// All registers: V0-VF, I, PC, SP, DT, ST.
+	assert.Equal(strings.Repeat("00", 16)+"0000"+"0200"+"00"+"00"+"00", c.call("g"))
+
+	// Single step and read individual registers.
+	assert.Equal("S05", c.call("s"))
+	assert.Equal("05", c.call("p1"))
+	assert.Equal("0202", c.call("p11"))
+
+	// Breakpoints stop execution before the instruction.
+	assert.Equal("OK", c.call("Z0,206,2"))
+	assert.Equal("T05swbreak:;", c.call("c"))
+	assert.Equal("0206", c.call("p11"))
+	assert.Equal("0300", c.call("p10"))
+	assert.Equal("06", c.call("p1"))
+	assert.Equal("OK", c.call("z0,206,2"))
+
+	// Register writes.
+	assert.Equal("OK", c.call("P1=fe"))
+	assert.Equal(uint8(0xFE), d.Cpu.Registers[1])
+	assert.Equal("E01", c.call("P99=00"))
+	assert.Equal("E02", c.call("P1=1ff"))
+
+	// Memory reads and writes.
+	assert.Equal("6105a300", c.call("m200,4"))
+	assert.Equal("OK", c.call("M300,2:beef"))
+	assert.Equal(uint8(0xBE), d.Cpu.Memory[0x300])
+	assert.Equal("beef", c.call("m300,2"))
+	assert.Equal("E01", c.call("mfff,2"))
+
+	// Write watchpoints are set per byte.
+	assert.Equal("OK", c.call("Z2,300,2"))
+	assert.Len(d.Watchpoints, 2)
+	assert.Equal("OK", c.call("z2,300,2"))
+	assert.Len(d.Watchpoints, 0)
+	assert.Equal("", c.call("Z3,300,1"))
+
+	// Interrupt a running target.
+	fmt.Fprintf(c.conn, "$c#%02x", checksum("c"))
+	c.conn.Write([]byte{0x03})
+	assert.Equal("S02", c.reply())
+
+	// Unsupported packets get an empty reply.
+	assert.Equal("", c.call("vMustReplyEmpty"))
+
+	assert.Equal("OK", c.call("D"))
+	assert.NoError(<-done)
+}
+
+// Test that halting and faulting programs are reported.
+func TestStopReplies(t *testing.T) {
+	assert := asrt.New(t)
+
+	c, _, done := dial(t, []byte{0x61, 0x05})
+	assert.Equal("W00", c.call("c"))
+	c.call("k")
+	assert.NoError(<-done)
+	c.conn.Close()
+
+	c, _, done = dial(t, []byte{0xFF, 0xFF})
+	assert.Equal("S04", c.call("c"))
+	c.conn.Close()
+	<-done
+
+	// RET with an empty stack.
+	c, _, done = dial(t, []byte{0x00, 0xEE})
+	assert.Equal("S0b", c.call("c"))
+	c.conn.Close()
+	<-done
+}
+
+// failingConn reads from a script and fails every write.
+type failingConn struct {
+	io.Reader
+}
+
+func (failingConn) Write(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+// Test that the reader stops when Serve gives up, even with packets still
+// waiting to be handled.
+func TestServeStopsReader(t *testing.T) {
+	assert := asrt.New(t)
+
+	before := runtime.NumGoroutine()
+	var script strings.Builder
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&script, "$?#%02x", checksum("?"))
+	}
+	d := debugger.New(cpu.NewCpu(nil, nil))
+	assert.Error(NewServer(d).Serve(failingConn{strings.NewReader(script.String())}))
+
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(before, runtime.NumGoroutine())
+}
+
+// Test that corrupted packets are rejected.
+func TestChecksum(t *testing.T) {
+	assert := asrt.New(t)
+
+	c, _, done := dial(t, []byte{0x61, 0x05})
+	defer c.conn.Close()
+
+	c.conn.Write([]byte("$g#00"))
+	b, err := c.r.ReadByte()
+	assert.NoError(err)
+	assert.Equal(byte('-'), b)
+
+	assert.Equal("S05", c.call("?"))
+	c.call("D")
+	<-done
+}
@@ -0,0 +1,247 @@
+// Package golden runs a directory of ROMs headlessly and compares the final
+// display against stored golden images.
+//
+// Every NAME.ch8 in the directory is a test case. It runs for the default
+// number of frames, or the number in NAME.frames if that file exists, with
+// the key script in NAME.keys if there is one. The golden image is NAME.png.
+// When the display doesn't match, the difference is written to
+// NAME.diff.png: pixels that should be lit but aren't are red, pixels that
+// are lit but shouldn't be are green, and pixels that match are grey.
+package golden
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/headless"
+)
+
+// Options control how cases are run.
+type Options struct {
+	// Frames is the number of frames to run cases without a NAME.frames
+	// file for.
+	Frames int
+	// ClockSpeed is the CPU clock speed in Hertz. It defaults to 60.
+	ClockSpeed int
+	// Scale is the size of each pixel in golden images written by Update.
+	Scale int
+	// Update writes the current display as the golden image instead of
+	// comparing against it.
+	Update bool
+}
+
+// Case is a single ROM to run.
+type Case struct {
+	Name   string
+	Rom    string
+	Keys   string
+	Golden string
+	Frames int
+}
+
+// Outcome is the result of running a case.
+type Outcome struct {
+	Case   Case
+	Result headless.Result
+	Vram   [32]int64
+	// Match is set if the display matched the golden image.
+	Match bool
+	// Updated is set if the golden image was rewritten.
+	Updated bool
+	// Diff is the path of the diff image written on a mismatch.
+	Diff string
+	// Err is set if the case couldn't be run or the CPU faulted.
+	Err error
+}
+
+// Passed reports whether the case ran without faulting and matched (or
+// updated) its golden image.
+func (o Outcome) Passed() bool {
+	return o.Err == nil && (o.Match || o.Updated)
+}
+
+func (o Outcome) String() string {
+	switch {
+	case o.Err != nil:
+		return fmt.Sprintf("FAIL %s: %s", o.Case.Name, o.Err.Error())
+	case o.Updated:
+		return fmt.Sprintf("UPDATED %s", o.Case.Name)
+	case !o.Match:
+		return fmt.Sprintf("FAIL %s: display doesn't match %s, see %s", o.Case.Name, o.Case.Golden, o.Diff)
+	}
+	return fmt.Sprintf("ok %s", o.Case.Name)
+}
+
+// Discover finds the cases in a directory.
+func Discover(dir string, opts Options) ([]Case, error) {
+	roms, err := filepath.Glob(filepath.Join(dir, "*.ch8"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(roms)
+
+	var cases []Case
+	for _, rom := range roms {
+		base := strings.TrimSuffix(rom, ".ch8")
+		c := Case{
+			Name:   filepath.Base(base),
+			Rom:    rom,
+			Golden: base + ".png",
+			Frames: opts.Frames,
+		}
+
+		if _, err := os.Stat(base + ".keys"); err == nil {
+			c.Keys = base + ".keys"
+		}
+
+		if b, err := ioutil.ReadFile(base + ".frames"); err == nil {
+			c.Frames, err = strconv.Atoi(strings.TrimSpace(string(b)))
+			if err != nil || c.Frames < 0 {
+				return nil, fmt.Errorf("%s.frames: invalid frame count", base)
+			}
+		}
+
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// Run runs a single case and compares or updates its golden image.
+func Run(c Case, opts Options) Outcome {
+	o := Outcome{Case: c}
+
+	rom, err := ioutil.ReadFile(c.Rom)
+	if err != nil {
+		o.Err = err
+		return o
+	}
+	var keys headless.KeyScript
+	if c.Keys != "" {
+		keys, err = headless.LoadKeyScript(c.Keys)
+		if err != nil {
+			o.Err = err
+			return o
+		}
+	}
+
+	cp := cpu.NewCpu(nil, rom)
+	if opts.ClockSpeed > 0 {
+		cp.SetClockSpeed(opts.ClockSpeed)
+	}
+	o.Result = headless.Run(cp, c.Frames, keys)
+	o.Vram = cp.Vram
+	if o.Result.Err != nil {
+		o.Err = o.Result.Err
+		return o
+	}
+
+	if opts.Update {
+		o.Err = writePNG(c.Golden, headless.Image(o.Vram, opts.Scale))
+		o.Updated = o.Err == nil
+		return o
+	}
+
+	want, err := readGolden(c.Golden)
+	if err != nil {
+		o.Err = err
+		return o
+	}
+	o.Match = want == o.Vram
+	if !o.Match {
+		o.Diff = strings.TrimSuffix(c.Golden, ".png") + ".diff.png"
+		o.Err = writePNG(o.Diff, DiffImage(want, o.Vram, opts.Scale))
+	}
+	return o
+}
+
+// RunDir runs every case in a directory.
+func RunDir(dir string, opts Options) ([]Outcome, error) {
+	cases, err := Discover(dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	outcomes := make([]Outcome, len(cases))
+	for i, c := range cases {
+		outcomes[i] = Run(c, opts)
+	}
+	return outcomes, nil
+}
+
+// DiffImage draws the difference between two displays.
+func DiffImage(want, got [32]int64, scale int) *image.RGBA {
+	if scale < 1 {
+		scale = 1
+	}
+	img := image.NewRGBA(image.Rect(0, 0, headless.Width*scale, headless.Height*scale))
+	for y := 0; y < headless.Height*scale; y++ {
+		for x := 0; x < headless.Width*scale; x++ {
+			w := headless.Pixel(want, x/scale, y/scale)
+			g := headless.Pixel(got, x/scale, y/scale)
+			c := color.RGBA{A: 0xFF}
+			switch {
+			case w && !g:
+				c.R = 0xFF
+			case g && !w:
+				c.G = 0xFF
+			case w && g:
+				c.R, c.G, c.B = 0x80, 0x80, 0x80
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// readGolden loads a golden image back into display memory. The image may be
+// at any scale; each pixel is sampled from the middle of its square.
+func readGolden(filename string) ([32]int64, error) {
+	var vram [32]int64
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return vram, fmt.Errorf("missing golden image (run with -update to create it): %s", err.Error())
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return vram, fmt.Errorf("%s: %s", filename, err.Error())
+	}
+	b := img.Bounds()
+	scale := b.Dx() / headless.Width
+	if scale < 1 || b.Dx() != headless.Width*scale || b.Dy() != headless.Height*scale {
+		return vram, fmt.Errorf("%s: image is %dx%d, expected a multiple of %dx%d", filename, b.Dx(), b.Dy(), headless.Width, headless.Height)
+	}
+
+	for y := 0; y < headless.Height; y++ {
+		for x := 0; x < headless.Width; x++ {
+			c := color.GrayModel.Convert(img.At(b.Min.X+x*scale+scale/2, b.Min.Y+y*scale+scale/2)).(color.Gray)
+			if c.Y >= 0x80 {
+				vram[y] |= int64(uint64(1) << uint(63-x))
+			}
+		}
+	}
+	return vram, nil
+}
+
+func writePNG(filename string, img image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
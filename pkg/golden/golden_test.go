@@ -0,0 +1,56 @@
+package golden
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that a mismatch is reported with a diff image, and that -update fixes
+// it.
+func TestMismatch(t *testing.T) {
+	assert := asrt.New(t)
+
+	dir, err := ioutil.TempDir("", "golden")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	rom, err := ioutil.ReadFile("testdata/sprite.ch8")
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "sprite.ch8"), rom, 0644))
+	// Stop before the sprite is drawn.
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "sprite.frames"), []byte("3\n"), 0644))
+
+	golden, err := ioutil.ReadFile("testdata/sprite.png")
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "sprite.png"), golden, 0644))
+
+	outcomes, err := RunDir(dir, Options{Frames: 30})
+	assert.NoError(err)
+	assert.Len(outcomes, 1)
+	o := outcomes[0]
+	assert.Equal(3, o.Case.Frames)
+	assert.False(o.Passed())
+	assert.Equal(filepath.Join(dir, "sprite.diff.png"), o.Diff)
+
+	want, err := readGolden(filepath.Join(dir, "sprite.png"))
+	assert.NoError(err)
+	diff := DiffImage(want, o.Vram, 1)
+	assert.Equal(uint8(0xFF), diff.RGBAAt(5, 3).R)
+	assert.Equal(uint8(0x00), diff.RGBAAt(0, 0).R)
+	_, err = os.Stat(o.Diff)
+	assert.NoError(err)
+
+	o = Run(o.Case, Options{Update: true, Scale: 1})
+	assert.True(o.Updated)
+	o = Run(o.Case, Options{})
+	assert.True(o.Passed(), o.String())
+
+	// A missing golden image is an error.
+	assert.NoError(os.Remove(filepath.Join(dir, "sprite.png")))
+	o = Run(o.Case, Options{})
+	assert.Error(o.Err)
+}
@@ -0,0 +1,38 @@
+// Package goldentest runs golden image tests from Go tests. It is kept apart
+// from package golden so that programs using golden don't link in the
+// testing package.
+package goldentest
+
+import (
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/golden"
+)
+
+// Check runs every case in a directory as a subtest. Tests typically pass
+// Update from a -update flag of their own:
+//
+//	var update = flag.Bool("update", false, "update golden images")
+//
+//	func TestRoms(t *testing.T) {
+//		goldentest.Check(t, "testdata", golden.Options{Frames: 60, Update: *update})
+//	}
+func Check(t *testing.T, dir string, opts golden.Options) {
+	cases, err := golden.Discover(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("no ROMs found in %s", dir)
+	}
+
+	for _, c := range cases {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			o := golden.Run(c, opts)
+			if !o.Passed() {
+				t.Error(o.String())
+			}
+		})
+	}
+}
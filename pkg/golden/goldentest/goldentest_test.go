@@ -0,0 +1,15 @@
+package goldentest
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/golden"
+)
+
+var update = flag.Bool("update", false, "update golden images")
+
+// Test the ROMs in golden's testdata against their golden images.
+func TestRoms(t *testing.T) {
+	Check(t, "../testdata", golden.Options{Frames: 30, Scale: 4, Update: *update})
+}
@@ -0,0 +1,94 @@
+package headless
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+
+	"github.com/cweagans/chip8/pkg/phosphor"
+)
+
+// Display dimensions in pixels.
+const (
+	Width  = 64
+	Height = 32
+)
+
+// Pixel reports whether the pixel at (x, y) is lit. Each row of vram holds
+// 64 pixels, with x = 0 in the most significant bit.
+func Pixel(vram [32]int64, x, y int) bool {
+	return uint64(vram[y])>>uint(63-x)&1 != 0
+}
+
+// WriteText writes the display as 32 lines of 64 characters, # for lit
+// pixels and . for unlit ones.
+func WriteText(w io.Writer, vram [32]int64) error {
+	bw := bufio.NewWriter(w)
+	for y := 0; y < Height; y++ {
+		for x := 0; x < Width; x++ {
+			if Pixel(vram, x, y) {
+				bw.WriteByte('#')
+			} else {
+				bw.WriteByte('.')
+			}
+		}
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// Image returns the display as a black and white image, with each pixel
+// drawn as a scale x scale square.
+func Image(vram [32]int64, scale int) *image.Gray {
+	if scale < 1 {
+		scale = 1
+	}
+	img := image.NewGray(image.Rect(0, 0, Width*scale, Height*scale))
+	for y := 0; y < Height*scale; y++ {
+		for x := 0; x < Width*scale; x++ {
+			if Pixel(vram, x/scale, y/scale) {
+				img.SetGray(x, y, color.Gray{Y: 0xFF})
+			}
+		}
+	}
+	return img
+}
+
+// PaletteImage returns the display drawn in colour, with p[0] for unlit
+// pixels and p[1] for lit ones.
+func PaletteImage(vram [32]int64, scale int, p color.Palette) *image.Paletted {
+	if scale < 1 {
+		scale = 1
+	}
+	img := image.NewPaletted(image.Rect(0, 0, Width*scale, Height*scale), p)
+	for y := 0; y < Height*scale; y++ {
+		for x := 0; x < Width*scale; x++ {
+			if Pixel(vram, x/scale, y/scale) {
+				img.SetColorIndex(x, y, 1)
+			}
+		}
+	}
+	return img
+}
+
+// ShadeImage returns shaded pixels, such as a phosphor.Filter's output,
+// drawn with shade, which gives the colour of each brightness.
+func ShadeImage(s phosphor.Shades, scale int, shade func(uint8) color.RGBA) *image.RGBA {
+	if scale < 1 {
+		scale = 1
+	}
+	img := image.NewRGBA(image.Rect(0, 0, Width*scale, Height*scale))
+	for y := 0; y < Height*scale; y++ {
+		for x := 0; x < Width*scale; x++ {
+			img.SetRGBA(x, y, shade(s[y/scale][x/scale]))
+		}
+	}
+	return img
+}
+
+// WritePNG writes the display as a PNG image.
+func WritePNG(w io.Writer, vram [32]int64, scale int) error {
+	return png.Encode(w, Image(vram, scale))
+}
@@ -0,0 +1,150 @@
+// Package headless runs ROMs without a display, as fast as possible, for a
+// fixed number of frames. Key presses come from a script, and the final
+// display can be saved as text or PNG.
+package headless
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// KeyEvent presses or releases a key at the start of a frame.
+type KeyEvent struct {
+	Frame int
+	Key   uint8
+	Down  bool
+}
+
+// KeyScript is a list of key events, ordered by frame.
+type KeyScript []KeyEvent
+
+// ParseKeyScript reads a key script. Each line gives a frame number, an
+// action and a key (0-F):
+//
+//	# frame action key [frames]
+//	10 down 5
+//	20 up 5
+//	30 press A 3
+//
+// press holds the key down for the given number of frames, or one frame if
+// no count is given. Blank lines and lines starting with # are ignored.
+func ParseKeyScript(r io.Reader) (KeyScript, error) {
+	var s KeyScript
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected FRAME ACTION KEY", n)
+		}
+		frame, err := strconv.Atoi(fields[0])
+		if err != nil || frame < 0 {
+			return nil, fmt.Errorf("line %d: invalid frame %q", n, fields[0])
+		}
+		key, err := strconv.ParseUint(fields[2], 16, 8)
+		if err != nil || key > 0xF {
+			return nil, fmt.Errorf("line %d: invalid key %q", n, fields[2])
+		}
+
+		switch strings.ToLower(fields[1]) {
+		case "down":
+			s = append(s, KeyEvent{Frame: frame, Key: uint8(key), Down: true})
+		case "up":
+			s = append(s, KeyEvent{Frame: frame, Key: uint8(key)})
+		case "press":
+			duration := 1
+			if len(fields) > 3 {
+				duration, err = strconv.Atoi(fields[3])
+				if err != nil || duration < 1 {
+					return nil, fmt.Errorf("line %d: invalid duration %q", n, fields[3])
+				}
+			}
+			s = append(s, KeyEvent{Frame: frame, Key: uint8(key), Down: true}, KeyEvent{Frame: frame + duration, Key: uint8(key)})
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", n, fields[1])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(s, func(i, j int) bool { return s[i].Frame < s[j].Frame })
+	return s, nil
+}
+
+// LoadKeyScript reads a key script from a file.
+func LoadKeyScript(filename string) (KeyScript, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseKeyScript(f)
+}
+
+// Result describes how a headless run ended.
+type Result struct {
+	// Frames is the number of frames that were run to completion.
+	Frames int
+	// Halted is set if the program reached a 0x0000 opcode.
+	Halted bool
+	// Err is set if the CPU faulted.
+	Err error
+}
+
+// Run runs the CPU for the given number of frames, applying key events at the
+// start of the frame they belong to. It stops early if the program halts or
+// faults.
+func Run(c *cpu.Cpu, frames int, keys KeyScript) Result {
+	return RunEach(c, frames, keys, nil)
+}
+
+// RunEach is Run, calling each with the display at the end of every frame
+// that runs to completion. each may be nil.
+func RunEach(c *cpu.Cpu, frames int, keys KeyScript, each func(frame int, vram [32]int64)) Result {
+	for frame := 0; frame < frames; frame++ {
+		for len(keys) > 0 && keys[0].Frame <= frame {
+			c.SetKey(keys[0].Key, keys[0].Down)
+			keys = keys[1:]
+		}
+
+		for i := c.StartFrame(); i > 0; i-- {
+			if err := step(c); err != nil {
+				return Result{Frames: frame, Err: err}
+			}
+			if c.ShouldHalt {
+				return Result{Frames: frame, Halted: true}
+			}
+			c.ShouldDraw = false
+		}
+		if each != nil {
+			each(frame, c.Vram)
+		}
+	}
+	return Result{Frames: frames}
+}
+
+// step executes one instruction, turning a panic inside the CPU into an
+// error.
+func step(c *cpu.Cpu) (err error) {
+	pc := c.PC
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("CPU panicked at 0x%03X: %v", pc, r)
+		}
+	}()
+	return c.Step()
+}
@@ -0,0 +1,139 @@
+package headless
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/phosphor"
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// 0x200: LD I, 0x208
+// 0x202: DRW V0, V1, 1
+// 0x204: JP 0x204
+// 0x206: data
+// 0x208: sprite
+var drawRom = []byte{0xA2, 0x08, 0xD0, 0x11, 0x12, 0x04, 0x00, 0x00, 0xF0, 0x00}
+
+// Test parsing key scripts.
+func TestParseKeyScript(t *testing.T) {
+	assert := asrt.New(t)
+
+	s, err := ParseKeyScript(strings.NewReader("# test\n30 press A 3\n10 down 5\n\n20 up 5\n40 press f\n"))
+	assert.NoError(err)
+	assert.Equal(KeyScript{
+		{Frame: 10, Key: 0x5, Down: true},
+		{Frame: 20, Key: 0x5},
+		{Frame: 30, Key: 0xA, Down: true},
+		{Frame: 33, Key: 0xA},
+		{Frame: 40, Key: 0xF, Down: true},
+		{Frame: 41, Key: 0xF},
+	}, s)
+
+	for _, bad := range []string{"10 down", "x down 1", "10 hold 1", "10 down 10", "10 press 1 0"} {
+		_, err := ParseKeyScript(strings.NewReader(bad))
+		assert.Error(err, bad)
+	}
+}
+
+// Test running for a fixed number of frames with key presses.
+func TestRun(t *testing.T) {
+	assert := asrt.New(t)
+
+	c := cpu.NewCpu(nil, drawRom)
+	c.SetClockSpeed(120)
+	r := Run(c, 10, KeyScript{{Frame: 2, Key: 0x3, Down: true}, {Frame: 20, Key: 0x3}})
+	assert.Equal(Result{Frames: 10}, r)
+	assert.Equal(uint64(20), c.Cycles)
+	assert.Equal(uint8(1), c.Keys[0x3])
+
+	buf := &bytes.Buffer{}
+	assert.NoError(WriteText(buf, c.Vram))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Len(lines, Height+1)
+	assert.Equal("####"+strings.Repeat(".", Width-4), lines[0])
+	assert.Equal(strings.Repeat(".", Width), lines[1])
+
+	buf.Reset()
+	assert.NoError(WritePNG(buf, c.Vram, 2))
+	img, err := png.Decode(buf)
+	assert.NoError(err)
+	assert.Equal(128, img.Bounds().Dx())
+	r0, _, _, _ := img.At(7, 1).RGBA()
+	r1, _, _, _ := img.At(8, 0).RGBA()
+	assert.Equal(uint32(0xFFFF), r0)
+	assert.Equal(uint32(0), r1)
+}
+
+// Test that halts and faults end the run early.
+func TestRunStops(t *testing.T) {
+	assert := asrt.New(t)
+
+	// 0x200: LD V0, 0x01
+	// 0x202: halt
+	c := cpu.NewCpu(nil, []byte{0x60, 0x01})
+	assert.Equal(Result{Frames: 1, Halted: true}, Run(c, 10, nil))
+
+	// 0x200: LD V0, 0x01
+	// 0x202: invalid opcode
+	c = cpu.NewCpu(nil, []byte{0x60, 0x01, 0x80, 0x18})
+	r := Run(c, 10, nil)
+	assert.Equal(1, r.Frames)
+	assert.Error(r.Err)
+
+	// 0x200: RET with an empty stack
+	c = cpu.NewCpu(nil, []byte{0x00, 0xEE})
+	r = Run(c, 10, nil)
+	assert.Equal(0, r.Frames)
+	assert.IsType(&cpu.StackError{}, r.Err)
+}
+
+// Test that the display can be drawn in colour, and is scaled.
+func TestPaletteImage(t *testing.T) {
+	assert := asrt.New(t)
+
+	var vram [32]int64
+	vram[1] = -1 << 63 // pixel (0, 1)
+	p := color.Palette{color.RGBA{0x10, 0x20, 0x30, 0xFF}, color.RGBA{0xF0, 0xE0, 0xD0, 0xFF}}
+
+	img := PaletteImage(vram, 2, p)
+	assert.Equal(Width*2, img.Bounds().Dx())
+	assert.Equal(p[0], img.At(0, 0))
+	assert.Equal(p[1], img.At(1, 3))
+	assert.Equal(p[0], img.At(2, 3))
+}
+
+// Test that RunEach shows every frame that runs to completion.
+func TestRunEach(t *testing.T) {
+	assert := asrt.New(t)
+
+	c := cpu.NewCpu(nil, drawRom)
+	var frames []int
+	var lit []bool
+	r := RunEach(c, 3, nil, func(frame int, vram [32]int64) {
+		frames = append(frames, frame)
+		lit = append(lit, Pixel(vram, 0, 0))
+	})
+	assert.Equal(Result{Frames: 3}, r)
+	assert.Equal([]int{0, 1, 2}, frames)
+	assert.Equal([]bool{false, true, true}, lit)
+}
+
+// Test that shaded pixels are drawn in the colours they're given, and scaled.
+func TestShadeImage(t *testing.T) {
+	assert := asrt.New(t)
+
+	var s phosphor.Shades
+	s[1][0] = 0x80
+	shade := func(v uint8) color.RGBA { return color.RGBA{v, v, v, 0xFF} }
+
+	img := ShadeImage(s, 2, shade)
+	assert.Equal(Width*2, img.Bounds().Dx())
+	assert.Equal(shade(0), img.At(0, 0))
+	assert.Equal(shade(0x80), img.At(1, 3))
+	assert.Equal(shade(0), img.At(2, 3))
+}
@@ -0,0 +1,220 @@
+// Package phosphor hides the flicker of CHIP-8 games. Sprites are moved by
+// XOR-ing them off the screen and back on, so they spend frames half drawn or
+// missing. A Filter sits between the machine's framebuffer and whatever shows
+// it, and blends each frame with the ones before it, either by OR-ing the last
+// few frames together or by fading pixels out over several frames like the
+// phosphor of an old screen.
+package phosphor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Display dimensions in pixels.
+const (
+	Width  = 64
+	Height = 32
+)
+
+// Lit is the brightness of a fully lit pixel.
+const Lit = 0xFF
+
+// Shades is the brightness of each pixel, by row and column, from 0 for
+// unlit to Lit.
+type Shades [Height][Width]uint8
+
+// FromFrame returns a frame's pixels as fully lit or unlit. Each row of the
+// frame holds 64 pixels, with x = 0 in the most significant bit.
+func FromFrame(frame [Height]int64) Shades {
+	var s Shades
+	for y, row := range frame {
+		for x := 0; x < Width; x++ {
+			if uint64(row)>>uint(63-x)&1 != 0 {
+				s[y][x] = Lit
+			}
+		}
+	}
+	return s
+}
+
+// Frame returns the pixels that are at least half lit, for displays that
+// can't show shades.
+func (s Shades) Frame() [Height]int64 {
+	var frame [Height]int64
+	for y := range s {
+		for x, v := range s[y] {
+			if v >= Lit/2+1 {
+				frame[y] |= int64(uint64(1) << uint(63-x))
+			}
+		}
+	}
+	return frame
+}
+
+// Mode is how frames are blended.
+type Mode int
+
+const (
+	// Off shows each frame as it is.
+	Off Mode = iota
+	// Or shows the pixels lit in any of the last few frames.
+	Or
+	// Fade dims pixels over a few frames after they go out.
+	Fade
+)
+
+var modeNames = []string{"off", "or", "fade"}
+
+func (m Mode) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return "unknown"
+}
+
+// DefaultFrames is how many frames each mode blends when the number isn't
+// given.
+var DefaultFrames = map[Mode]int{Off: 1, Or: 2, Fade: 4}
+
+// Settings choose how a Filter blends frames.
+type Settings struct {
+	Mode Mode
+	// Frames is how many frames are OR-ed together, or how many frames a
+	// pixel takes to fade out.
+	Frames int
+}
+
+func (s Settings) String() string {
+	if s.Mode == Off {
+		return "off"
+	}
+	return fmt.Sprintf("%s:%d", s.Mode, s.Frames)
+}
+
+// Parse reads settings written as a mode with an optional number of frames,
+// e.g. "off", "or", "or:3" or "fade:6".
+func Parse(spec string) (Settings, error) {
+	name := strings.TrimSpace(spec)
+	count := ""
+	if i := strings.Index(name, ":"); i >= 0 {
+		name, count = name[:i], name[i+1:]
+	}
+
+	var s Settings
+	found := false
+	for m, n := range modeNames {
+		if strings.EqualFold(name, n) {
+			s.Mode, found = Mode(m), true
+		}
+	}
+	if !found {
+		return Settings{}, fmt.Errorf("unknown phosphor mode %q", name)
+	}
+
+	s.Frames = DefaultFrames[s.Mode]
+	if count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return Settings{}, fmt.Errorf("invalid number of frames %q", count)
+		}
+		s.Frames = n
+	}
+	return s, nil
+}
+
+// ROMSettings returns the settings kept next to a ROM, in a file named after
+// it with .phosphor added, e.g. pong.ch8.phosphor. It reports false if there
+// isn't one.
+func ROMSettings(romFile string) (Settings, bool, error) {
+	b, err := ioutil.ReadFile(romFile + ".phosphor")
+	if os.IsNotExist(err) {
+		return Settings{}, false, nil
+	}
+	if err != nil {
+		return Settings{}, false, err
+	}
+	s, err := Parse(string(b))
+	if err != nil {
+		return Settings{}, false, fmt.Errorf("%s.phosphor: %s", romFile, err)
+	}
+	return s, true, nil
+}
+
+// Filter blends each frame with the ones shown before it. It must be given
+// every frame, 60 times a second, for fading to take the right time.
+type Filter struct {
+	Settings
+
+	last   [Height]int64
+	recent [][Height]int64
+	shades Shades
+}
+
+// NewFilter returns a filter with the given settings.
+func NewFilter(s Settings) *Filter {
+	if s.Frames < 1 {
+		s.Frames = 1
+	}
+	return &Filter{Settings: s}
+}
+
+// Apply adds the next frame and returns what should be shown.
+func (f *Filter) Apply(frame [Height]int64) Shades {
+	f.last = frame
+
+	switch f.Mode {
+	case Or:
+		f.recent = append(f.recent, frame)
+		if len(f.recent) > f.Frames {
+			f.recent = append(f.recent[:0], f.recent[len(f.recent)-f.Frames:]...)
+		}
+		var or [Height]int64
+		for _, r := range f.recent {
+			for y := range r {
+				or[y] |= r[y]
+			}
+		}
+		f.shades = FromFrame(or)
+
+	case Fade:
+		step := uint8((Lit + f.Frames - 1) / f.Frames)
+		lit := FromFrame(frame)
+		for y := range f.shades {
+			for x, v := range f.shades[y] {
+				switch {
+				case lit[y][x] == Lit:
+					f.shades[y][x] = Lit
+				case v > step:
+					f.shades[y][x] = v - step
+				default:
+					f.shades[y][x] = 0
+				}
+			}
+		}
+
+	default:
+		f.shades = FromFrame(frame)
+	}
+	return f.shades
+}
+
+// Settled reports whether applying the last frame again would show the same
+// thing, i.e. nothing is still fading out.
+func (f *Filter) Settled() bool {
+	switch f.Mode {
+	case Or:
+		for _, r := range f.recent {
+			if r != f.last {
+				return false
+			}
+		}
+		return true
+	case Fade:
+		return f.shades == FromFrame(f.last)
+	}
+	return true
+}
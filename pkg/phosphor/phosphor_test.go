@@ -0,0 +1,121 @@
+package phosphor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// frameWith returns a frame with only the pixel at (x, y) lit.
+func frameWith(x, y int) [Height]int64 {
+	var frame [Height]int64
+	frame[y] = int64(uint64(1) << uint(63-x))
+	return frame
+}
+
+// Test that frames convert to shades and back.
+func TestShades(t *testing.T) {
+	assert := asrt.New(t)
+
+	frame := frameWith(0, 3)
+	frame[5] = 1
+	s := FromFrame(frame)
+	assert.Equal(uint8(Lit), s[3][0])
+	assert.Equal(uint8(Lit), s[5][63])
+	assert.Equal(uint8(0), s[3][1])
+	assert.Equal(frame, s.Frame())
+
+	s[3][0] = Lit / 2
+	assert.Equal(int64(0), s.Frame()[3])
+}
+
+// Test that settings are parsed with and without a number of frames.
+func TestParse(t *testing.T) {
+	assert := asrt.New(t)
+
+	s, err := Parse("fade")
+	assert.Nil(err)
+	assert.Equal(Settings{Fade, 4}, s)
+	s, err = Parse(" OR:3\n")
+	assert.Nil(err)
+	assert.Equal(Settings{Or, 3}, s)
+	assert.Equal("or:3", s.String())
+	s, err = Parse("off")
+	assert.Nil(err)
+	assert.Equal("off", s.String())
+
+	for _, spec := range []string{"blur", "fade:0", "or:x", ""} {
+		_, err = Parse(spec)
+		assert.NotNil(err, spec)
+	}
+}
+
+// Test that OR mode keeps pixels lit for the given number of frames.
+func TestFilterOr(t *testing.T) {
+	assert := asrt.New(t)
+
+	f := NewFilter(Settings{Or, 2})
+	a, b := frameWith(1, 1), frameWith(2, 2)
+	s := f.Apply(a)
+	assert.Equal(uint8(Lit), s[1][1])
+	assert.True(f.Settled())
+
+	s = f.Apply(b)
+	assert.Equal(uint8(Lit), s[1][1])
+	assert.Equal(uint8(Lit), s[2][2])
+	assert.False(f.Settled())
+
+	s = f.Apply(b)
+	assert.Equal(uint8(0), s[1][1])
+	assert.True(f.Settled())
+}
+
+// Test that fade mode dims pixels over the given number of frames.
+func TestFilterFade(t *testing.T) {
+	assert := asrt.New(t)
+
+	f := NewFilter(Settings{Fade, 3})
+	f.Apply(frameWith(4, 4))
+	assert.True(f.Settled())
+
+	var levels []uint8
+	for i := 0; i < 4; i++ {
+		s := f.Apply([Height]int64{})
+		levels = append(levels, s[4][4])
+	}
+	assert.Equal([]uint8{170, 85, 0, 0}, levels)
+	assert.True(f.Settled())
+
+	// Off shows frames unchanged.
+	f = NewFilter(Settings{})
+	f.Apply(frameWith(4, 4))
+	assert.Equal(Shades{}, f.Apply([Height]int64{}))
+	assert.True(f.Settled())
+}
+
+// Test that settings are read from beside a ROM.
+func TestROMSettings(t *testing.T) {
+	assert := asrt.New(t)
+
+	dir, err := ioutil.TempDir("", "phosphor")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	rom := filepath.Join(dir, "game.ch8")
+
+	_, ok, err := ROMSettings(rom)
+	assert.False(ok)
+	assert.Nil(err)
+
+	assert.Nil(ioutil.WriteFile(rom+".phosphor", []byte("fade:6\n"), 0644))
+	s, ok, err := ROMSettings(rom)
+	assert.True(ok)
+	assert.Nil(err)
+	assert.Equal(Settings{Fade, 6}, s)
+
+	assert.Nil(ioutil.WriteFile(rom+".phosphor", []byte("glow"), 0644))
+	_, _, err = ROMSettings(rom)
+	assert.NotNil(err)
+}
@@ -0,0 +1,132 @@
+package profile
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// HeatmapWidth is the number of addresses shown on each row of a heatmap.
+const HeatmapWidth = 64
+
+// heatmapShades go from cold to hot.
+const heatmapShades = " .:-=+*#%@"
+
+// hottest returns the execution count of the most executed address.
+func (p *Profiler) hottest() uint64 {
+	var max uint64
+	for _, n := range p.Addresses {
+		if n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// heat returns how hot an address is, from 0 (never executed) to 1 (the
+// hottest address, executed max times). A log scale keeps a single tight loop
+// from washing out the rest of the program.
+func (p *Profiler) heat(addr int, max uint64) float64 {
+	if max == 0 || p.Addresses[addr] == 0 {
+		return 0
+	}
+	return math.Log(float64(p.Addresses[addr])+1) / math.Log(float64(max)+1)
+}
+
+// WriteHeatmap draws the address space as text, one row per 64 bytes, with
+// hotter addresses drawn in denser characters. Instructions are two bytes
+// long, so only one of each pair of columns is normally lit.
+func (p *Profiler) WriteHeatmap(w io.Writer) error {
+	max := p.hottest()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "       %s\n", columnHeader())
+	for row := 0; row < len(p.Addresses); row += HeatmapWidth {
+		fmt.Fprintf(&b, "0x%03X  ", row)
+		for addr := row; addr < row+HeatmapWidth; addr++ {
+			shade := 0
+			if p.Addresses[addr] > 0 {
+				shade = 1 + int(p.heat(addr, max)*float64(len(heatmapShades)-2)+0.5)
+			}
+			b.WriteByte(heatmapShades[shade])
+		}
+		b.WriteByte('\n')
+	}
+	fmt.Fprintf(&b, "\nScale: '%s' (cold to hot)\n", heatmapShades[1:])
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func columnHeader() string {
+	var b bytes.Buffer
+	for col := 0; col < HeatmapWidth; col++ {
+		if col%16 == 0 {
+			fmt.Fprintf(&b, "%-16X", col)
+		}
+	}
+	return b.String()
+}
+
+type heatmapCell struct {
+	Title string
+	Color template.CSS
+}
+
+type heatmapRow struct {
+	Address string
+	Cells   []heatmapCell
+}
+
+var heatmapTemplate = template.Must(template.New("heatmap").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>CHIP-8 profile</title>
+<style>
+body { font-family: monospace; background: #111; color: #ddd; }
+table { border-collapse: collapse; }
+td { width: 10px; height: 10px; padding: 0; }
+td.addr { width: auto; padding-right: 8px; }
+</style>
+</head>
+<body>
+<table>
+{{range .Rows}}<tr><td class="addr">{{.Address}}</td>{{range .Cells}}<td title="{{.Title}}" style="background: {{.Color}}"></td>{{end}}</tr>
+{{end}}</table>
+<pre>{{.Report}}</pre>
+</body>
+</html>
+`))
+
+// WriteHeatmapHTML draws the address space as an HTML table, one row per 64
+// bytes. Hovering over an address shows its instruction and count, and the
+// text report is included below the map.
+func (p *Profiler) WriteHeatmapHTML(w io.Writer) error {
+	max := p.hottest()
+	var rows []heatmapRow
+	for row := 0; row < len(p.Addresses); row += HeatmapWidth {
+		r := heatmapRow{Address: fmt.Sprintf("0x%03X", row)}
+		for addr := row; addr < row+HeatmapWidth; addr++ {
+			c := heatmapCell{Title: fmt.Sprintf("0x%03X", addr), Color: "#222"}
+			if n := p.Addresses[addr]; n > 0 {
+				op := p.ops[addr]
+				c.Title = fmt.Sprintf("0x%03X  %04X  %s  %d", addr, op, cpu.Disassemble(op), n)
+				// Blue for cold through to red for hot.
+				c.Color = template.CSS(fmt.Sprintf("hsl(%d, 90%%, 50%%)", int(240*(1-p.heat(addr, max)))))
+			}
+			r.Cells = append(r.Cells, c)
+		}
+		rows = append(rows, r)
+	}
+
+	var report bytes.Buffer
+	p.WriteReport(&report, 20)
+
+	return heatmapTemplate.Execute(w, struct {
+		Rows   []heatmapRow
+		Report string
+	}{rows, report.String()})
+}
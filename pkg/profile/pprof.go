@@ -0,0 +1,192 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"sort"
+
+	"github.com/cweagans/chip8/pkg/srcmap"
+)
+
+// WritePprof writes the profile in the gzipped protocol buffer format read
+// by `go tool pprof`. Each sample is an instruction count and the equivalent
+// CPU time, with a call stack built from 2NNN calls. Functions are named
+// after the address of the subroutine. If m is not nil, locations are
+// annotated with the source lines it gives.
+func (p *Profiler) WritePprof(w io.Writer, m *srcmap.Map) error {
+	strs := &stringTable{index: map[string]int64{}}
+	strs.add("")
+
+	b := &protobuf{}
+
+	// sample_type
+	for _, vt := range [][2]string{{"instructions", "count"}, {"cpu", "nanoseconds"}} {
+		b.message(1, valueType(strs, vt[0], vt[1]))
+	}
+
+	type locKey struct{ addr, entry uint16 }
+	locations := map[locKey]uint64{}
+	functions := map[uint16]uint64{}
+	locs := &protobuf{}
+	funcs := &protobuf{}
+
+	function := func(entry uint16) uint64 {
+		if id, ok := functions[entry]; ok {
+			return id
+		}
+		id := uint64(len(functions) + 1)
+		functions[entry] = id
+
+		f := &protobuf{}
+		f.uint64(1, id)
+		name := strs.add(p.subroutineName(entry))
+		f.int64(2, name)
+		f.int64(3, name)
+		if m != nil {
+			if e, ok := m.Lookup(entry); ok {
+				f.int64(4, strs.add(e.File))
+				f.int64(5, int64(e.Line))
+			}
+		}
+		funcs.message(5, f)
+		return id
+	}
+
+	location := func(addr, entry uint16) uint64 {
+		k := locKey{addr, entry}
+		if id, ok := locations[k]; ok {
+			return id
+		}
+		id := uint64(len(locations) + 1)
+		locations[k] = id
+
+		line := &protobuf{}
+		line.uint64(1, function(entry))
+		if m != nil {
+			if e, ok := m.Lookup(addr); ok {
+				line.int64(2, int64(e.Line))
+			}
+		}
+
+		l := &protobuf{}
+		l.uint64(1, id)
+		l.uint64(3, uint64(addr))
+		l.message(4, line)
+		locs.message(4, l)
+		return id
+	}
+
+	// Samples are written in a stable order so that output is reproducible.
+	for _, key := range sortedKeys(p.samples) {
+		s := p.samples[key]
+		ids := make([]uint64, len(s.stack))
+		for i, f := range s.stack {
+			ids[i] = location(f.caller, f.entry)
+		}
+
+		sm := &protobuf{}
+		sm.packed(1, ids)
+		sm.packed(2, []uint64{s.count, uint64(p.Duration(s.count))})
+		b.message(2, sm)
+	}
+
+	b.b = append(b.b, locs.b...)
+	b.b = append(b.b, funcs.b...)
+
+	// duration_nanos, period_type and period
+	b.int64(10, int64(p.Duration(p.Instructions)))
+	b.message(11, valueType(strs, "cpu", "nanoseconds"))
+	b.int64(12, int64(p.Duration(1)))
+
+	// The string table has to come last, once every string has been added.
+	for _, s := range strs.strings {
+		b.string(6, s)
+	}
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(b.b); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func valueType(strs *stringTable, typ, unit string) *protobuf {
+	vt := &protobuf{}
+	vt.int64(1, strs.add(typ))
+	vt.int64(2, strs.add(unit))
+	return vt
+}
+
+// stringTable interns the strings referenced by a profile.
+type stringTable struct {
+	strings []string
+	index   map[string]int64
+}
+
+func (t *stringTable) add(s string) int64 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := int64(len(t.strings))
+	t.strings = append(t.strings, s)
+	t.index[s] = i
+	return i
+}
+
+// protobuf is just enough of a protocol buffer encoder for pprof profiles.
+type protobuf struct {
+	b []byte
+}
+
+func (p *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		p.b = append(p.b, byte(x)|0x80)
+		x >>= 7
+	}
+	p.b = append(p.b, byte(x))
+}
+
+func (p *protobuf) tag(field, wireType int) {
+	p.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (p *protobuf) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	p.tag(field, 0)
+	p.varint(x)
+}
+
+func (p *protobuf) int64(field int, x int64) {
+	p.uint64(field, uint64(x))
+}
+
+func (p *protobuf) string(field int, s string) {
+	p.tag(field, 2)
+	p.varint(uint64(len(s)))
+	p.b = append(p.b, s...)
+}
+
+func (p *protobuf) message(field int, m *protobuf) {
+	p.tag(field, 2)
+	p.varint(uint64(len(m.b)))
+	p.b = append(p.b, m.b...)
+}
+
+func (p *protobuf) packed(field int, xs []uint64) {
+	m := &protobuf{}
+	for _, x := range xs {
+		m.varint(x)
+	}
+	p.message(field, m)
+}
+
+func sortedKeys(m map[string]*sample) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
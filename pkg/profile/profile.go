@@ -0,0 +1,215 @@
+// Package profile records where a ROM spends its time: executions per address
+// and per opcode class, time spent in each subroutine, and how often the
+// screen is drawn. Profiles can be written in the pprof format or as a
+// heatmap over the 4 KB address space.
+package profile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// Profiler is a cpu.Tracer that counts every instruction it sees.
+type Profiler struct {
+	// ClockSpeed is the CPU clock speed in Hertz, used to convert
+	// instruction counts into time.
+	ClockSpeed int
+
+	Instructions uint64
+	Addresses    [4096]uint64
+	Classes      [16]uint64
+	DrawCalls    uint64
+	Subroutines  map[uint16]*Subroutine
+
+	// ops remembers the last opcode executed at each address, for labels.
+	ops [4096]uint16
+
+	// counted is the instruction each subroutine's Total was last counted
+	// for, by entry address, so that recursion only counts once.
+	counted [4096]uint64
+
+	// stack holds the active frames, outermost first.
+	stack   []frame
+	samples map[string]*sample
+}
+
+// Subroutine is the time spent in the subroutine starting at Address. Self
+// counts the instructions executed in the subroutine itself and Total also
+// includes the subroutines it called. The top level of the program is
+// recorded as a subroutine at the first address executed, with no calls.
+type Subroutine struct {
+	Address uint16
+	Calls   uint64
+	Self    uint64
+	Total   uint64
+}
+
+// frame is an active subroutine and the address it was called from.
+type frame struct {
+	entry  uint16
+	caller uint16
+}
+
+// sample is the number of instructions executed with a given call stack.
+type sample struct {
+	// stack lists address/subroutine pairs from the leaf outwards.
+	stack []frame
+	count uint64
+}
+
+// New returns an empty profiler for a CPU running at the given clock speed.
+func New(clockSpeed int) *Profiler {
+	return &Profiler{
+		ClockSpeed:  clockSpeed,
+		Subroutines: map[uint16]*Subroutine{},
+		samples:     map[string]*sample{},
+	}
+}
+
+func (p *Profiler) Trace(e *cpu.TraceEvent) {
+	pc := e.PC & 0x0FFF
+	p.Instructions++
+	p.Addresses[pc]++
+	p.Classes[e.Op>>12]++
+	p.ops[pc] = e.Op
+
+	if len(p.stack) == 0 {
+		p.stack = append(p.stack, frame{entry: pc})
+		p.Subroutines[pc] = &Subroutine{Address: pc}
+	}
+
+	// The instruction belongs to the innermost subroutine. Recursive calls
+	// only count once towards a subroutine's total.
+	p.Subroutines[p.stack[len(p.stack)-1].entry].Self++
+	for _, f := range p.stack {
+		if p.counted[f.entry] != p.Instructions {
+			p.counted[f.entry] = p.Instructions
+			p.Subroutines[f.entry].Total++
+		}
+	}
+	p.sample(pc)
+
+	switch {
+	case e.Op&0xF000 == 0xD000 || e.Op == 0x00E0:
+		p.DrawCalls++
+	case e.Op&0xF000 == 0x2000:
+		entry := e.Op & 0x0FFF
+		s, ok := p.Subroutines[entry]
+		if !ok {
+			s = &Subroutine{Address: entry}
+			p.Subroutines[entry] = s
+		}
+		s.Calls++
+		p.stack = append(p.stack, frame{entry: entry, caller: pc})
+	case e.Op == 0x00EE:
+		if len(p.stack) > 1 {
+			p.stack = p.stack[:len(p.stack)-1]
+		}
+	}
+}
+
+// sample records an instruction at pc against the current call stack.
+func (p *Profiler) sample(pc uint16) {
+	var key strings.Builder
+	stack := make([]frame, 0, len(p.stack))
+	addr := pc
+	for i := len(p.stack) - 1; i >= 0; i-- {
+		f := p.stack[i]
+		stack = append(stack, frame{entry: f.entry, caller: addr})
+		fmt.Fprintf(&key, "%03X:%03X;", f.entry, addr)
+		addr = f.caller
+	}
+
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &sample{stack: stack}
+		p.samples[key.String()] = s
+	}
+	s.count++
+}
+
+// Duration converts an instruction count into time at the profiler's clock
+// speed.
+func (p *Profiler) Duration(instructions uint64) time.Duration {
+	if p.ClockSpeed <= 0 {
+		return 0
+	}
+	return time.Duration(instructions) * time.Second / time.Duration(p.ClockSpeed)
+}
+
+// SortedSubroutines returns the subroutines ordered by total time, longest
+// first.
+func (p *Profiler) SortedSubroutines() []*Subroutine {
+	subs := make([]*Subroutine, 0, len(p.Subroutines))
+	for _, s := range p.Subroutines {
+		subs = append(subs, s)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Total != subs[j].Total {
+			return subs[i].Total > subs[j].Total
+		}
+		return subs[i].Address < subs[j].Address
+	})
+	return subs
+}
+
+// subroutineName returns the name used for a subroutine in reports.
+func (p *Profiler) subroutineName(entry uint16) string {
+	if len(p.stack) > 0 && entry == p.stack[0].entry {
+		if s := p.Subroutines[entry]; s != nil && s.Calls == 0 {
+			return "main"
+		}
+	}
+	return fmt.Sprintf("sub_%03X", entry)
+}
+
+// WriteReport writes a plain text summary of the profile, listing the top
+// addresses, opcode classes and subroutines.
+func (p *Profiler) WriteReport(w io.Writer, top int) {
+	pct := func(n uint64) float64 {
+		if p.Instructions == 0 {
+			return 0
+		}
+		return float64(n) * 100 / float64(p.Instructions)
+	}
+
+	fmt.Fprintf(w, "%d instructions (%s at %d Hz)\n", p.Instructions, p.Duration(p.Instructions), p.ClockSpeed)
+	fmt.Fprintf(w, "%d draw calls (%.1f%% of instructions", p.DrawCalls, pct(p.DrawCalls))
+	if d := p.Duration(p.Instructions); d > 0 {
+		fmt.Fprintf(w, ", %.1f per second", float64(p.DrawCalls)/d.Seconds())
+	}
+	fmt.Fprintln(w, ")")
+
+	fmt.Fprintln(w, "\nHot addresses:")
+	addrs := make([]int, 0)
+	for a, n := range p.Addresses {
+		if n > 0 {
+			addrs = append(addrs, a)
+		}
+	}
+	sort.SliceStable(addrs, func(i, j int) bool { return p.Addresses[addrs[i]] > p.Addresses[addrs[j]] })
+	if top > 0 && len(addrs) > top {
+		addrs = addrs[:top]
+	}
+	for _, a := range addrs {
+		fmt.Fprintf(w, "  0x%03X  %04X  %-16s %10d  %5.1f%%\n", a, p.ops[a], cpu.Disassemble(p.ops[a]), p.Addresses[a], pct(p.Addresses[a]))
+	}
+
+	fmt.Fprintln(w, "\nOpcode classes:")
+	for class, n := range p.Classes {
+		if n > 0 {
+			fmt.Fprintf(w, "  %XNNN  %10d  %5.1f%%\n", class, n, pct(n))
+		}
+	}
+
+	fmt.Fprintln(w, "\nSubroutines:")
+	fmt.Fprintf(w, "  %-10s %8s %10s %10s %7s\n", "", "calls", "self", "total", "total%")
+	for _, s := range p.SortedSubroutines() {
+		fmt.Fprintf(w, "  %-10s %8d %10d %10d %6.1f%%\n", p.subroutineName(s.Address), s.Calls, s.Self, s.Total, pct(s.Total))
+	}
+}
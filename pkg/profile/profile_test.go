@@ -0,0 +1,99 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// 0x200: CALL 0x206
+// 0x202: JP 0x202
+// 0x204: data
+// 0x206: CLS
+// 0x208: RET
+var testRom = []byte{0x22, 0x06, 0x12, 0x02, 0x00, 0x00, 0x00, 0xE0, 0x00, 0xEE}
+
+func profile(t *testing.T) *Profiler {
+	p := New(60)
+	c := cpu.NewCpu(nil, testRom)
+	c.Tracer = p
+	for i := 0; i < 3; i++ {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return p
+}
+
+// Test the counters.
+func TestProfiler(t *testing.T) {
+	assert := asrt.New(t)
+
+	p := profile(t)
+	assert.Equal(uint64(3), p.Instructions)
+	assert.Equal(uint64(1), p.Addresses[0x200])
+	assert.Equal(uint64(1), p.Addresses[0x206])
+	assert.Equal(uint64(2), p.Classes[0x0])
+	assert.Equal(uint64(1), p.Classes[0x2])
+	assert.Equal(uint64(1), p.DrawCalls)
+
+	assert.Equal(&Subroutine{Address: 0x200, Calls: 0, Self: 1, Total: 3}, p.Subroutines[0x200])
+	assert.Equal(&Subroutine{Address: 0x206, Calls: 1, Self: 2, Total: 2}, p.Subroutines[0x206])
+	assert.Equal("main", p.subroutineName(0x200))
+	assert.Equal("sub_206", p.subroutineName(0x206))
+
+	buf := &bytes.Buffer{}
+	p.WriteReport(buf, 10)
+	assert.Contains(buf.String(), "3 instructions (50ms at 60 Hz)")
+	assert.Contains(buf.String(), "0x206  00E0  CLS")
+	assert.Contains(buf.String(), "sub_206           1          2          2")
+}
+
+// Test that recursive calls count once towards a subroutine's total.
+func TestProfilerRecursion(t *testing.T) {
+	assert := asrt.New(t)
+
+	p := New(60)
+	p.Trace(&cpu.TraceEvent{PC: 0x200, Op: 0x2300})
+	p.Trace(&cpu.TraceEvent{PC: 0x300, Op: 0x2300})
+	p.Trace(&cpu.TraceEvent{PC: 0x300, Op: 0x6000})
+	assert.Equal(&Subroutine{Address: 0x200, Calls: 0, Self: 1, Total: 3}, p.Subroutines[0x200])
+	assert.Equal(&Subroutine{Address: 0x300, Calls: 2, Self: 2, Total: 2}, p.Subroutines[0x300])
+}
+
+// Test that the pprof output is a gzipped profile naming the subroutines.
+func TestPprof(t *testing.T) {
+	assert := asrt.New(t)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(profile(t).WritePprof(buf, nil))
+
+	gz, err := gzip.NewReader(buf)
+	assert.NoError(err)
+	data, err := ioutil.ReadAll(gz)
+	assert.NoError(err)
+	for _, s := range []string{"instructions", "nanoseconds", "main", "sub_206"} {
+		assert.Contains(string(data), s)
+	}
+}
+
+// Test the text and HTML heatmaps.
+func TestHeatmap(t *testing.T) {
+	assert := asrt.New(t)
+
+	p := profile(t)
+	buf := &bytes.Buffer{}
+	assert.NoError(p.WriteHeatmap(buf))
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal("0x200  @     @ @", strings.TrimRight(lines[1+0x200/HeatmapWidth], " "))
+	assert.Equal("0x000", strings.TrimSpace(lines[1]))
+
+	buf.Reset()
+	assert.NoError(p.WriteHeatmapHTML(buf))
+	assert.Contains(buf.String(), `title="0x206  00E0  CLS  1"`)
+}
@@ -0,0 +1,203 @@
+// Package reference is a model of the CHIP-8 instruction set, written to be
+// as simple as possible rather than fast, that the CPU is tested against.
+//
+// Execute is a pure function from one machine state to the next. Where the
+// CPU has quirks, it follows the ones it is given. Otherwise it follows the
+// original COSMAC VIP interpreter: shifts read VY, BNNN adds V0, FX0A takes
+// the lowest key that is down, and sprites wrap their starting position but
+// are clipped at the edges of the screen. The stack holds the address of
+// each CALL, so RET resumes at the instruction after it. Timers aren't
+// updated, since they aren't part of instruction execution.
+package reference
+
+import (
+	"fmt"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// Execute runs the instruction at s.PC with the quirks q and returns the new
+// state. random is the byte CXNN masks with NN. Invalid opcodes and stack
+// errors return an error and the state unchanged.
+func Execute(s cpu.State, q cpu.Quirks, random uint8) (cpu.State, error) {
+	op := Fetch(s)
+	x := (op >> 8) & 0xF
+	y := (op >> 4) & 0xF
+	n := op & 0xF
+	nn := uint8(op)
+	nnn := op & 0xFFF
+	vx, vy := s.Registers[x], s.Registers[y]
+
+	next := s
+	next.PC = s.PC + 2
+
+	switch {
+	case op == 0x00E0:
+		next.Vram = [32]int64{}
+	case op == 0x00EE:
+		if s.StackPointer == 0 {
+			return s, fmt.Errorf("RET with an empty stack")
+		}
+		next.StackPointer--
+		next.PC = s.Stack[next.StackPointer] + 2
+		next.Stack[next.StackPointer] = 0
+	case op&0xF000 == 0x1000:
+		next.PC = nnn
+	case op&0xF000 == 0x2000:
+		if s.StackPointer == len(s.Stack) {
+			return s, fmt.Errorf("CALL with a full stack")
+		}
+		next.Stack[s.StackPointer] = s.PC
+		next.StackPointer++
+		next.PC = nnn
+	case op&0xF000 == 0x3000:
+		if vx == nn {
+			next.PC += 2
+		}
+	case op&0xF000 == 0x4000:
+		if vx != nn {
+			next.PC += 2
+		}
+	case op&0xF00F == 0x5000:
+		if vx == vy {
+			next.PC += 2
+		}
+	case op&0xF000 == 0x6000:
+		next.Registers[x] = nn
+	case op&0xF000 == 0x7000:
+		next.Registers[x] = vx + nn
+	case op&0xF00F == 0x8000:
+		next.Registers[x] = vy
+	case op&0xF00F == 0x8001:
+		next.Registers[x] = vx | vy
+		if q.VFReset {
+			next.Registers[0xF] = 0
+		}
+	case op&0xF00F == 0x8002:
+		next.Registers[x] = vx & vy
+		if q.VFReset {
+			next.Registers[0xF] = 0
+		}
+	case op&0xF00F == 0x8003:
+		next.Registers[x] = vx ^ vy
+		if q.VFReset {
+			next.Registers[0xF] = 0
+		}
+	case op&0xF00F == 0x8004:
+		next.Registers[x] = vx + vy
+		next.Registers[0xF] = flag(int(vx)+int(vy) > 0xFF)
+	case op&0xF00F == 0x8005:
+		next.Registers[x] = vx - vy
+		next.Registers[0xF] = flag(vx >= vy)
+	case op&0xF00F == 0x8006:
+		next.Registers[x] = vy >> 1
+		next.Registers[0xF] = vy & 1
+	case op&0xF00F == 0x8007:
+		next.Registers[x] = vy - vx
+		next.Registers[0xF] = flag(vy >= vx)
+	case op&0xF00F == 0x800E:
+		next.Registers[x] = vy << 1
+		next.Registers[0xF] = vy >> 7
+	case op&0xF00F == 0x9000:
+		if vx != vy {
+			next.PC += 2
+		}
+	case op&0xF000 == 0xA000:
+		next.IndexRegister = nnn
+	case op&0xF000 == 0xB000:
+		next.PC = nnn + uint16(s.Registers[0])
+	case op&0xF000 == 0xC000:
+		next.Registers[x] = random & nn
+	case op&0xF000 == 0xD000:
+		next.Registers[0xF] = 0
+		for row := 0; row < int(n); row++ {
+			sprite := s.Memory[(int(s.IndexRegister)+row)&0xFFF]
+			for col := 0; col < 8; col++ {
+				px := int(vx)%64 + col
+				py := int(vy)%32 + row
+				if sprite&(0x80>>uint(col)) == 0 || px >= 64 || py >= 32 {
+					continue
+				}
+				if Pixel(next.Vram, px, py) {
+					next.Registers[0xF] = 1
+				}
+				next.Vram = togglePixel(next.Vram, px, py)
+			}
+		}
+	case op&0xF0FF == 0xE09E:
+		if s.Keys[vx&0xF] != 0 {
+			next.PC += 2
+		}
+	case op&0xF0FF == 0xE0A1:
+		if s.Keys[vx&0xF] == 0 {
+			next.PC += 2
+		}
+	case op&0xF0FF == 0xF007:
+		next.Registers[x] = s.DelayTimer
+	case op&0xF0FF == 0xF00A:
+		// Keep executing this instruction until a key is down.
+		next.PC = s.PC
+		for key, down := range s.Keys {
+			if down != 0 {
+				next.Registers[x] = uint8(key)
+				next.PC = s.PC + 2
+				break
+			}
+		}
+	case op&0xF0FF == 0xF015:
+		next.DelayTimer = vx
+	case op&0xF0FF == 0xF018:
+		next.SoundTimer = vx
+	case op&0xF0FF == 0xF01E:
+		next.IndexRegister = (s.IndexRegister + uint16(vx)) & 0xFFF
+	case op&0xF0FF == 0xF029:
+		next.IndexRegister = uint16(vx&0xF) * 5
+	case op&0xF0FF == 0xF033:
+		next.Memory[s.IndexRegister&0xFFF] = vx / 100
+		next.Memory[(s.IndexRegister+1)&0xFFF] = vx / 10 % 10
+		next.Memory[(s.IndexRegister+2)&0xFFF] = vx % 10
+	case op&0xF0FF == 0xF055:
+		for r := uint16(0); r <= x; r++ {
+			next.Memory[(s.IndexRegister+r)&0xFFF] = s.Registers[r]
+		}
+		if q.IncrementI {
+			next.IndexRegister = (s.IndexRegister + x + 1) & 0xFFF
+		}
+	case op&0xF0FF == 0xF065:
+		for r := uint16(0); r <= x; r++ {
+			next.Registers[r] = s.Memory[(s.IndexRegister+r)&0xFFF]
+		}
+		if q.IncrementI {
+			next.IndexRegister = (s.IndexRegister + x + 1) & 0xFFF
+		}
+	default:
+		return s, fmt.Errorf("invalid opcode 0x%04X", op)
+	}
+
+	next.PC &= 0xFFF
+	return next, nil
+}
+
+// Fetch returns the opcode at s.PC. Addresses wrap around at the end of
+// memory.
+func Fetch(s cpu.State) uint16 {
+	return uint16(s.Memory[s.PC&0xFFF])<<8 | uint16(s.Memory[(s.PC+1)&0xFFF])
+}
+
+// Pixel reports whether the pixel at (x, y) is lit. x = 0 is the most
+// significant bit of each row.
+func Pixel(vram [32]int64, x, y int) bool {
+	return uint64(vram[y])&(1<<uint(63-x)) != 0
+}
+
+func togglePixel(vram [32]int64, x, y int) [32]int64 {
+	vram[y] = int64(uint64(vram[y]) ^ 1<<uint(63-x))
+	return vram
+}
+
+func flag(b bool) uint8 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,335 @@
+package reference
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/selftest"
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test a few opcodes against hand-worked results, so that the model isn't
+// only checked against the CPU.
+func TestExecute(t *testing.T) {
+	assert := asrt.New(t)
+
+	s := cpu.State{PC: 0x200}
+	s.Memory[0x200], s.Memory[0x201] = 0x81, 0x24 // ADD V1, V2
+	s.Registers[1], s.Registers[2] = 0xF0, 0x20
+	next, err := Execute(s, cpu.VIPQuirks, 0)
+	assert.NoError(err)
+	assert.Equal(uint8(0x10), next.Registers[1])
+	assert.Equal(uint8(1), next.Registers[0xF])
+	assert.Equal(uint16(0x202), next.PC)
+	assert.Equal(uint8(0xF0), s.Registers[1], "the input state must not change")
+
+	s.Memory[0x200], s.Memory[0x201] = 0x81, 0x21 // OR V1, V2
+	s.Registers[0xF] = 5
+	next, err = Execute(s, cpu.VIPQuirks, 0)
+	assert.NoError(err)
+	assert.Equal(uint8(0xF0), next.Registers[1])
+	assert.Equal(uint8(0), next.Registers[0xF])
+	next, err = Execute(s, cpu.Quirks{}, 0)
+	assert.NoError(err)
+	assert.Equal(uint8(5), next.Registers[0xF])
+	s.Registers[0xF] = 0
+
+	s.Memory[0x200], s.Memory[0x201] = 0xF1, 0x55 // LD [I], V1
+	s.IndexRegister = 0x300
+	next, err = Execute(s, cpu.VIPQuirks, 0)
+	assert.NoError(err)
+	assert.Equal([]uint8{0x00, 0xF0}, next.Memory[0x300:0x302])
+	assert.Equal(uint16(0x302), next.IndexRegister)
+	next, err = Execute(s, cpu.SuperChipQuirks, 0)
+	assert.NoError(err)
+	assert.Equal(uint16(0x300), next.IndexRegister)
+
+	s.Memory[0x200], s.Memory[0x201] = 0xD0, 0x11 // DRW V0, V1, 1
+	s.Registers[0], s.Registers[1] = 62, 33
+	s.IndexRegister = 0x300
+	s.Memory[0x300] = 0xFF
+	next, err = Execute(s, cpu.VIPQuirks, 0)
+	assert.NoError(err)
+	assert.Equal(int64(3), next.Vram[1])
+	assert.Equal(uint8(0), next.Registers[0xF])
+	next.PC = 0x200
+	next, err = Execute(next, cpu.VIPQuirks, 0)
+	assert.NoError(err)
+	assert.Equal(int64(0), next.Vram[1])
+	assert.Equal(uint8(1), next.Registers[0xF])
+
+	s.Memory[0x200], s.Memory[0x201] = 0x00, 0xEE // RET
+	_, err = Execute(s, cpu.VIPQuirks, 0)
+	assert.Error(err)
+	s.Stack[0], s.StackPointer = 0x300, 1
+	next, err = Execute(s, cpu.VIPQuirks, 0)
+	assert.NoError(err)
+	assert.Equal(uint16(0x302), next.PC)
+}
+
+// opForms are the valid opcodes, as a fixed part and a mask of the bits
+// that vary.
+var opForms = [][2]uint16{
+	{0x00E0, 0}, {0x00EE, 0}, {0x1000, 0xFFF}, {0x2000, 0xFFF}, {0x3000, 0xFFF},
+	{0x4000, 0xFFF}, {0x5000, 0xFF0}, {0x6000, 0xFFF}, {0x7000, 0xFFF},
+	{0x8000, 0xFF0}, {0x8001, 0xFF0}, {0x8002, 0xFF0}, {0x8003, 0xFF0},
+	{0x8004, 0xFF0}, {0x8005, 0xFF0}, {0x8006, 0xFF0}, {0x8007, 0xFF0},
+	{0x800E, 0xFF0}, {0x9000, 0xFF0}, {0xA000, 0xFFF}, {0xB000, 0xFFF},
+	{0xC000, 0xFFF}, {0xD000, 0xFFF}, {0xE09E, 0xF00}, {0xE0A1, 0xF00},
+	{0xF007, 0xF00}, {0xF00A, 0xF00}, {0xF015, 0xF00}, {0xF018, 0xF00},
+	{0xF01E, 0xF00}, {0xF029, 0xF00}, {0xF033, 0xF00}, {0xF055, 0xF00},
+	{0xF065, 0xF00},
+}
+
+// randomState returns a random machine state with a random opcode at PC.
+// Most opcodes are valid; the rest are any 16 bit value.
+func randomState(r *rand.Rand) cpu.State {
+	var s cpu.State
+	r.Read(s.Memory[:])
+	r.Read(s.Registers[:])
+	for i := range s.Vram {
+		s.Vram[i] = r.Int63() ^ r.Int63()<<1
+	}
+	for i := range s.Stack {
+		s.Stack[i] = uint16(r.Intn(0x1000))
+	}
+	for i := range s.Keys {
+		s.Keys[i] = uint8(r.Intn(2))
+	}
+	s.PC = uint16(r.Intn(0x1000))
+	s.IndexRegister = uint16(r.Intn(0x1000))
+	s.StackPointer = r.Intn(len(s.Stack) + 1)
+	s.DelayTimer = uint8(r.Intn(256))
+	s.SoundTimer = uint8(r.Intn(256))
+
+	op := uint16(r.Intn(0x10000))
+	if r.Intn(4) != 0 {
+		form := opForms[r.Intn(len(opForms))]
+		op = form[0] | uint16(r.Intn(0x10000))&form[1]
+	}
+	s.Memory[s.PC&0xFFF] = uint8(op >> 8)
+	s.Memory[(s.PC+1)&0xFFF] = uint8(op)
+	return s
+}
+
+// result is what the CPU did with a state. unsupported is set when the CPU
+// doesn't implement an opcode that the model does.
+type result struct {
+	diff        string
+	unsupported bool
+}
+
+// compare executes one instruction on the CPU and the model, giving both the
+// same random numbers, and describes any difference.
+func compare(s cpu.State, seed int64, profile selftest.Profile) (res result) {
+	c := cpu.NewCpu(nil, nil)
+	profile.Configure(c)
+	if err := c.Restore(s); err != nil {
+		return result{diff: err.Error()}
+	}
+	c.Random = rand.New(rand.NewSource(seed))
+	random := uint8(rand.New(rand.NewSource(seed)).Intn(256))
+
+	want, modelErr := Execute(s, c.Quirks, random)
+
+	var cpuErr error
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				cpuErr = fmt.Errorf("panic: %v", r)
+			}
+		}()
+		c.GetOp()
+		cpuErr = c.ProcessOpcode()
+	}()
+
+	if _, ok := cpuErr.(*cpu.UnknownOpcodeError); ok && modelErr == nil {
+		return result{unsupported: true}
+	}
+	switch {
+	case cpuErr != nil && modelErr != nil:
+		return result{}
+	case cpuErr != nil:
+		return result{diff: "cpu: " + cpuErr.Error() + "\nmodel: ok"}
+	case modelErr != nil:
+		return result{diff: "cpu: ok\nmodel: " + modelErr.Error()}
+	}
+	return result{diff: diffStates(c.State(), want)}
+}
+
+// diffStates lists the fields that differ between the CPU's state and the
+// model's.
+func diffStates(got, want cpu.State) string {
+	var diffs []string
+	add := func(name string, got, want interface{}) {
+		diffs = append(diffs, fmt.Sprintf("%s: cpu 0x%X, model 0x%X", name, got, want))
+	}
+
+	if got.PC != want.PC {
+		add("PC", got.PC, want.PC)
+	}
+	if got.IndexRegister != want.IndexRegister {
+		add("I", got.IndexRegister, want.IndexRegister)
+	}
+	for r := range got.Registers {
+		if got.Registers[r] != want.Registers[r] {
+			add(fmt.Sprintf("V%X", r), got.Registers[r], want.Registers[r])
+		}
+	}
+	if got.StackPointer != want.StackPointer {
+		add("SP", got.StackPointer, want.StackPointer)
+	}
+	for i := range got.Stack {
+		if got.Stack[i] != want.Stack[i] {
+			add(fmt.Sprintf("stack[%d]", i), got.Stack[i], want.Stack[i])
+		}
+	}
+	if got.DelayTimer != want.DelayTimer {
+		add("DT", got.DelayTimer, want.DelayTimer)
+	}
+	if got.SoundTimer != want.SoundTimer {
+		add("ST", got.SoundTimer, want.SoundTimer)
+	}
+	for y := range got.Vram {
+		if got.Vram[y] != want.Vram[y] {
+			diffs = append(diffs, fmt.Sprintf("row %d: cpu %064b, model %064b", y, uint64(got.Vram[y]), uint64(want.Vram[y])))
+		}
+	}
+	for a := range got.Memory {
+		if got.Memory[a] != want.Memory[a] {
+			add(fmt.Sprintf("[0x%03X]", a), got.Memory[a], want.Memory[a])
+		}
+	}
+	return strings.Join(diffs, "\n")
+}
+
+// shrink simplifies a failing state as far as possible while it keeps
+// failing, so that the report only shows what matters.
+func shrink(s cpu.State, fails func(cpu.State) bool) cpu.State {
+	var steps []func(*cpu.State)
+	steps = append(steps,
+		func(s *cpu.State) {
+			// Keep only the opcode.
+			pc := s.PC & 0xFFF
+			hi, lo := s.Memory[pc], s.Memory[(pc+1)&0xFFF]
+			s.Memory = [4096]uint8{}
+			s.Memory[pc], s.Memory[(pc+1)&0xFFF] = hi, lo
+		},
+		func(s *cpu.State) {
+			// Keep only the opcode and what I points at.
+			var mem [4096]uint8
+			for i := uint16(0); i < 16; i++ {
+				a := (s.IndexRegister + i) & 0xFFF
+				mem[a] = s.Memory[a]
+			}
+			pc := s.PC & 0xFFF
+			mem[pc], mem[(pc+1)&0xFFF] = s.Memory[pc], s.Memory[(pc+1)&0xFFF]
+			s.Memory = mem
+		},
+		func(s *cpu.State) { s.Vram = [32]int64{} },
+		func(s *cpu.State) { s.Stack = [16]uint16{} },
+		func(s *cpu.State) { s.StackPointer = 0 },
+		func(s *cpu.State) {
+			if s.StackPointer > 1 {
+				s.StackPointer--
+			}
+		},
+		func(s *cpu.State) { s.Keys = [16]uint8{} },
+		func(s *cpu.State) { s.DelayTimer, s.SoundTimer = 0, 0 },
+	)
+	for r := 0; r < 16; r++ {
+		r := r
+		steps = append(steps, func(s *cpu.State) { s.Registers[r] = 0 })
+	}
+	for y := 0; y < 32; y++ {
+		y := y
+		steps = append(steps, func(s *cpu.State) { s.Vram[y] = 0 })
+	}
+	for i := 0; i < 16; i++ {
+		i := i
+		steps = append(steps, func(s *cpu.State) { s.Stack[i] = 0 })
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for _, step := range steps {
+			next := s
+			step(&next)
+			if next != s && fails(next) {
+				s = next
+				changed = true
+			}
+		}
+	}
+	return s
+}
+
+// describe prints the parts of a state that aren't zero.
+func describe(s cpu.State) string {
+	op := Fetch(s)
+	lines := []string{fmt.Sprintf("0x%03X: %04X  %s", s.PC, op, cpu.Disassemble(op))}
+	var regs []string
+	for r, v := range s.Registers {
+		if v != 0 {
+			regs = append(regs, fmt.Sprintf("V%X=0x%02X", r, v))
+		}
+	}
+	regs = append(regs, fmt.Sprintf("I=0x%03X SP=%d DT=0x%02X ST=0x%02X", s.IndexRegister, s.StackPointer, s.DelayTimer, s.SoundTimer))
+	lines = append(lines, strings.Join(regs, " "))
+	for i := 0; i < s.StackPointer; i++ {
+		lines = append(lines, fmt.Sprintf("stack[%d]=0x%03X", i, s.Stack[i]))
+	}
+	for k, down := range s.Keys {
+		if down != 0 {
+			lines = append(lines, fmt.Sprintf("key %X down", k))
+		}
+	}
+	for y, row := range s.Vram {
+		if row != 0 {
+			lines = append(lines, fmt.Sprintf("row %d: %064b", y, uint64(row)))
+		}
+	}
+	for a, v := range s.Memory {
+		if v != 0 && uint16(a) != s.PC&0xFFF && uint16(a) != (s.PC+1)&0xFFF {
+			lines = append(lines, fmt.Sprintf("[0x%03X]=0x%02X", a, v))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Test the CPU against the model with random states and opcodes, under every
+// profile. Failures are shrunk to a minimal state before being reported.
+func TestCpuMatchesModel(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, profile := range selftest.Profiles {
+		unsupported := map[string]int{}
+		for i := 0; i < 20000; i++ {
+			s := randomState(r)
+			seed := r.Int63()
+
+			res := compare(s, seed, profile)
+			if res.unsupported {
+				op := Fetch(s)
+				unsupported[strings.Fields(cpu.Disassemble(op))[0]]++
+				continue
+			}
+			if res.diff == "" {
+				continue
+			}
+
+			s = shrink(s, func(s cpu.State) bool { return compare(s, seed, profile).diff != "" })
+			t.Fatalf("profile %s: CPU and model disagree on\n%s\n\n%s", profile.Name, describe(s), compare(s, seed, profile).diff)
+		}
+
+		var names []string
+		for name, n := range unsupported {
+			names = append(names, fmt.Sprintf("%s (%d)", name, n))
+		}
+		sort.Strings(names)
+		t.Logf("profile %s: skipped opcodes the CPU doesn't implement: %s", profile.Name, strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,119 @@
+package selftest
+
+import "fmt"
+
+// builder assembles a test program. Instructions are added as raw opcodes,
+// with labels resolved once the program is complete. Every program ends
+// with the pass and fail routines that report its result on screen.
+type builder struct {
+	ops    []uint16
+	labels map[string]uint16
+	fixups []fixup
+	checks int
+}
+
+// fixup patches the address of a label into the low 12 bits of an opcode.
+type fixup struct {
+	index  int
+	label  string
+	offset int
+}
+
+func newBuilder() *builder {
+	return &builder{labels: map[string]uint16{}}
+}
+
+// pc returns the address of the next instruction.
+func (b *builder) pc() uint16 {
+	return uint16(0x200 + 2*len(b.ops))
+}
+
+// emit adds instructions.
+func (b *builder) emit(ops ...uint16) {
+	b.ops = append(b.ops, ops...)
+}
+
+// label names the address of the next instruction.
+func (b *builder) label(name string) {
+	b.labels[name] = b.pc()
+}
+
+// ref adds an instruction whose address is that of a label plus offset,
+// e.g. ref(0x1000, "loop", 0) for JP loop.
+func (b *builder) ref(op uint16, label string, offset int) {
+	b.fixups = append(b.fixups, fixup{len(b.ops), label, offset})
+	b.emit(op)
+}
+
+// check adds a skip instruction that must be taken for the next check to
+// pass. If it isn't, the program fails with VE set to the check number.
+func (b *builder) check(skip uint16) {
+	b.checks++
+	b.emit(skip)
+	b.ref(0x1000, fmt.Sprintf("fail%d", b.checks), 0)
+}
+
+// checkNot adds a skip instruction that must not be taken for the next
+// check to pass.
+func (b *builder) checkNot(skip uint16) {
+	b.checks++
+	b.emit(skip)
+	b.emit(0x1000 | (b.pc()+4)&0x0FFF)
+	b.ref(0x1000, fmt.Sprintf("fail%d", b.checks), 0)
+}
+
+// fail adds a jump that fails the next check if it is ever reached.
+func (b *builder) fail() {
+	b.checks++
+	b.ref(0x1000, fmt.Sprintf("fail%d", b.checks), 0)
+}
+
+// data adds raw bytes, padded to a whole number of instructions.
+func (b *builder) data(bytes ...byte) {
+	if len(bytes)%2 != 0 {
+		bytes = append(bytes, 0)
+	}
+	for i := 0; i < len(bytes); i += 2 {
+		b.emit(uint16(bytes[i])<<8 | uint16(bytes[i+1]))
+	}
+}
+
+// assemble jumps to the pass routine, adds the result routines and returns
+// the ROM.
+func (b *builder) assemble() []byte {
+	b.ref(0x1000, "pass", 0)
+
+	for n := 1; n <= b.checks; n++ {
+		b.label(fmt.Sprintf("fail%d", n))
+		b.emit(0x6E00 | uint16(n))
+		b.ref(0x1000, "fail", 0)
+	}
+
+	// Both routines clear the screen and draw their pattern in the top left
+	// corner, then loop forever.
+	for _, result := range []string{"pass", "fail"} {
+		b.label(result)
+		b.emit(0x00E0, 0x6C00, 0x6D00)
+		b.ref(0xA000, result+"Sprite", 0)
+		b.emit(0xDCD1)
+		b.ref(0x1000, result, 10)
+	}
+	b.label("passSprite")
+	b.data(PassPattern)
+	b.label("failSprite")
+	b.data(FailPattern)
+
+	for _, f := range b.fixups {
+		addr, ok := b.labels[f.label]
+		if !ok {
+			panic("selftest: undefined label " + f.label)
+		}
+		b.ops[f.index] |= uint16(int(addr)+f.offset) & 0x0FFF
+	}
+
+	rom := make([]byte, 0, 2*len(b.ops))
+	for _, op := range b.ops {
+		rom = append(rom, byte(op>>8), byte(op))
+	}
+	return rom
+}
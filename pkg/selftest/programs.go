@@ -0,0 +1,325 @@
+package selftest
+
+import (
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/headless"
+)
+
+// Program is a test ROM along with how to run it. Checks describes each of
+// its numbered checks, so that a failure can be reported by name.
+type Program struct {
+	Name   string
+	Checks []string
+	Rom    []byte
+	Frames int
+	Keys   headless.KeyScript
+}
+
+// programs returns the conformance suite for an interpreter with the given
+// quirks. Everything else follows the original COSMAC VIP interpreter.
+func programs(q cpu.Quirks) []Program {
+	return []Program{
+		arithmetic(),
+		skips(),
+		subroutines(),
+		flags(),
+		quirks(q),
+		timers(),
+		keypad(),
+		display(),
+	}
+}
+
+func arithmetic() Program {
+	b := newBuilder()
+	b.emit(0x6F00, 0x6012, 0x7034) // LD VF, 0x00; LD V0, 0x12; ADD V0, 0x34
+	b.check(0x3046)                // SE V0, 0x46
+	b.emit(0x70C0)                 // ADD V0, 0xC0
+	b.check(0x3006)                // SE V0, 0x06
+	b.check(0x3F00)                // SE VF, 0x00
+	b.emit(0x61F0, 0x620F, 0x8121) // LD V1, 0xF0; LD V2, 0x0F; OR V1, V2
+	b.check(0x31FF)                // SE V1, 0xFF
+	b.emit(0x61F0, 0x8122)         // LD V1, 0xF0; AND V1, V2
+	b.check(0x3100)                // SE V1, 0x00
+	b.emit(0x61FF, 0x8123)         // LD V1, 0xFF; XOR V1, V2
+	b.check(0x31F0)                // SE V1, 0xF0
+	b.emit(0x8320)                 // LD V3, V2
+	b.check(0x330F)                // SE V3, 0x0F
+
+	return Program{
+		Name: "arithmetic",
+		Checks: []string{
+			"7XNN adds",
+			"7XNN wraps around",
+			"7XNN leaves VF alone",
+			"8XY1 ORs",
+			"8XY2 ANDs",
+			"8XY3 XORs",
+			"8XY0 copies",
+		},
+		Rom:    b.assemble(),
+		Frames: 60,
+	}
+}
+
+func skips() Program {
+	b := newBuilder()
+	b.emit(0x6005, 0x6105, 0x6206) // LD V0, 5; LD V1, 5; LD V2, 6
+	b.check(0x3005)                // SE V0, 5
+	b.checkNot(0x3006)             // SE V0, 6
+	b.check(0x4006)                // SNE V0, 6
+	b.checkNot(0x4005)             // SNE V0, 5
+	b.check(0x5010)                // SE V0, V1
+	b.checkNot(0x5020)             // SE V0, V2
+	b.check(0x9020)                // SNE V0, V2
+	b.checkNot(0x9010)             // SNE V0, V1
+
+	return Program{
+		Name: "skips",
+		Checks: []string{
+			"3XNN skips when equal",
+			"3XNN doesn't skip when not equal",
+			"4XNN skips when not equal",
+			"4XNN doesn't skip when equal",
+			"5XY0 skips when equal",
+			"5XY0 doesn't skip when not equal",
+			"9XY0 skips when not equal",
+			"9XY0 doesn't skip when equal",
+		},
+		Rom:    b.assemble(),
+		Frames: 60,
+	}
+}
+
+func subroutines() Program {
+	b := newBuilder()
+	b.emit(0x6000)          // LD V0, 0
+	b.ref(0x2000, "inc", 0) // CALL inc
+	b.check(0x3001)         // SE V0, 1
+	b.ref(0x2000, "twice", 0)
+	b.check(0x3003) // SE V0, 3
+	b.ref(0x1000, "done", 0)
+
+	// twice calls inc twice, checking that nested calls return correctly.
+	b.label("twice")
+	b.ref(0x2000, "inc", 0)
+	b.ref(0x2000, "inc", 0)
+	b.emit(0x00EE)
+
+	b.label("inc")
+	b.emit(0x7001, 0x00EE) // ADD V0, 1; RET
+
+	b.label("done")
+
+	return Program{
+		Name: "subroutines",
+		Checks: []string{
+			"00EE returns to the instruction after the call",
+			"nested calls return in order",
+		},
+		Rom:    b.assemble(),
+		Frames: 60,
+	}
+}
+
+func flags() Program {
+	b := newBuilder()
+	b.emit(0x60FF, 0x6101, 0x8014) // LD V0, 0xFF; LD V1, 1; ADD V0, V1
+	b.check(0x3000)                // SE V0, 0
+	b.check(0x3F01)                // SE VF, 1
+	b.emit(0x6001, 0x6102, 0x8014) // LD V0, 1; LD V1, 2; ADD V0, V1
+	b.check(0x3F00)                // SE VF, 0
+	b.emit(0x6005, 0x6103, 0x8015) // LD V0, 5; LD V1, 3; SUB V0, V1
+	b.check(0x3002)                // SE V0, 2
+	b.check(0x3F01)                // SE VF, 1
+	b.emit(0x6003, 0x6105, 0x8015) // LD V0, 3; LD V1, 5; SUB V0, V1
+	b.check(0x30FE)                // SE V0, 0xFE
+	b.check(0x3F00)                // SE VF, 0
+	b.emit(0x6003, 0x6105, 0x8017) // LD V0, 3; LD V1, 5; SUBN V0, V1
+	b.check(0x3002)                // SE V0, 2
+	b.check(0x3F01)                // SE VF, 1
+	b.emit(0x6005, 0x8006)         // LD V0, 5; SHR V0
+	b.check(0x3002)                // SE V0, 2
+	b.check(0x3F01)                // SE VF, 1
+	b.emit(0x6081, 0x800E)         // LD V0, 0x81; SHL V0
+	b.check(0x3002)                // SE V0, 2
+	b.check(0x3F01)                // SE VF, 1
+	b.emit(0x6FFF, 0x6101, 0x8F14) // LD VF, 0xFF; LD V1, 1; ADD VF, V1
+	b.check(0x3F01)                // SE VF, 1
+
+	return Program{
+		Name: "flags",
+		Checks: []string{
+			"8XY4 wraps around",
+			"8XY4 sets VF on carry",
+			"8XY4 clears VF without carry",
+			"8XY5 subtracts",
+			"8XY5 sets VF without borrow",
+			"8XY5 wraps around",
+			"8XY5 clears VF on borrow",
+			"8XY7 subtracts VX from VY",
+			"8XY7 sets VF without borrow",
+			"8XY6 shifts right",
+			"8XY6 sets VF to the bit shifted out",
+			"8XYE shifts left",
+			"8XYE sets VF to the bit shifted out",
+			"VF holds the flag when it is also the destination",
+		},
+		Rom:    b.assemble(),
+		Frames: 60,
+	}
+}
+
+func quirks(q cpu.Quirks) Program {
+	b := newBuilder()
+	b.emit(0x6F05, 0x6001, 0x6102, 0x8011) // LD VF, 5; LD V0, 1; LD V1, 2; OR V0, V1
+	vfReset := "8XY1 leaves VF alone"
+	if q.VFReset {
+		b.check(0x3F00) // SE VF, 0
+		vfReset = "8XY1 resets VF"
+	} else {
+		b.check(0x3F05) // SE VF, 5
+	}
+
+	// With the quirk, the load reads the byte after the one stored, and the
+	// second load the byte after that. Without it, they both read back the
+	// stored 0.
+	b.ref(0xA000, "scratch", 0)    // LD I, scratch
+	b.emit(0x6000, 0xF055, 0xF065) // LD V0, 0; LD [I], V0; LD V0, [I]
+	store, load := "FX55 leaves I alone", "FX65 leaves I alone"
+	if q.IncrementI {
+		b.check(0x30BB) // SE V0, 0xBB
+		b.emit(0xF065)  // LD V0, [I]
+		b.check(0x30CC) // SE V0, 0xCC
+		store, load = "FX55 increments I", "FX65 increments I"
+	} else {
+		b.check(0x3000) // SE V0, 0
+		b.emit(0x60FF)  // LD V0, 0xFF
+		b.emit(0xF065)  // LD V0, [I]
+		b.check(0x3000) // SE V0, 0
+	}
+
+	b.emit(0x6104, 0x60FF, 0x8016) // LD V1, 4; LD V0, 0xFF; SHR V0, V1
+	b.check(0x3002)                // SE V0, 2
+
+	b.emit(0x6002)              // LD V0, 2
+	b.ref(0xB000, "jumped", -2) // JP V0, jumped - 2
+	b.fail()
+	b.label("jumped")
+	b.ref(0x1000, "done", 0)
+
+	b.label("scratch")
+	b.data(0xAA, 0xBB, 0xCC)
+	b.label("done")
+
+	return Program{
+		Name: "quirks",
+		Checks: []string{
+			vfReset,
+			store,
+			load,
+			"8XY6 shifts VY into VX",
+			"BNNN jumps to NNN + V0",
+		},
+		Rom:    b.assemble(),
+		Frames: 60,
+	}
+}
+
+func timers() Program {
+	b := newBuilder()
+	b.emit(0x6010, 0xF015, 0xF018, 0xF107) // LD V0, 0x10; LD DT, V0; LD ST, V0; LD V1, DT
+	b.checkNot(0x3100)                     // SE V1, 0
+
+	// Wait for the delay timer to run down. If it never does, the program
+	// runs out of time without a result.
+	b.label("wait")
+	b.emit(0xF107, 0x3100) // LD V1, DT; SE V1, 0
+	b.ref(0x1000, "wait", 0)
+
+	return Program{
+		Name: "timers",
+		Checks: []string{
+			"FX07 reads back the delay timer",
+		},
+		Rom:    b.assemble(),
+		Frames: 120,
+	}
+}
+
+func keypad() Program {
+	b := newBuilder()
+	b.emit(0x6005, 0x6106) // LD V0, 5; LD V1, 6
+	b.check(0xE09E)        // SKP V0
+	b.checkNot(0xE0A1)     // SKNP V0
+	b.check(0xE1A1)        // SKNP V1
+	b.checkNot(0xE19E)     // SKP V1
+	b.emit(0xF20A)         // LD V2, K
+	b.check(0x3205)        // SE V2, 5
+
+	return Program{
+		Name: "keypad",
+		Checks: []string{
+			"EX9E skips when the key is down",
+			"EXA1 doesn't skip when the key is down",
+			"EXA1 skips when the key is up",
+			"EX9E doesn't skip when the key is up",
+			"FX0A waits for a key and stores it",
+		},
+		Rom:    b.assemble(),
+		Frames: 120,
+		Keys: headless.KeyScript{
+			{Frame: 0, Key: 0x5, Down: true},
+			{Frame: 30, Key: 0x5},
+		},
+	}
+}
+
+func display() Program {
+	b := newBuilder()
+	b.ref(0xA000, "pixel", 0)
+	b.emit(0x6000, 0x6100, 0xD011) // LD V0, 0; LD V1, 0; DRW V0, V1, 1
+	b.check(0x3F00)                // SE VF, 0
+	b.emit(0xD011)                 // DRW V0, V1, 1
+	b.check(0x3F01)                // SE VF, 1
+
+	// The starting coordinates wrap around the screen.
+	b.emit(0x6043, 0xD011, 0x6003, 0xD011) // LD V0, 67; DRW V0, V1, 1; LD V0, 3; DRW V0, V1, 1
+	b.check(0x3F01)                        // SE VF, 1
+
+	// Sprites are clipped at the right edge.
+	b.emit(0x00E0)
+	b.ref(0xA000, "line", 0)
+	b.emit(0x603C, 0xD011) // LD V0, 60; DRW V0, V1, 1
+	b.ref(0xA000, "pixel", 0)
+	b.emit(0x6000, 0xD011) // LD V0, 0; DRW V0, V1, 1
+	b.check(0x3F00)        // SE VF, 0
+
+	// Sprites are clipped at the bottom edge.
+	b.emit(0x00E0)
+	b.ref(0xA000, "line", 0)
+	b.emit(0x611F, 0xD012) // LD V1, 31; DRW V0, V1, 2
+	b.ref(0xA000, "pixel", 0)
+	b.emit(0x6100, 0xD011) // LD V1, 0; DRW V0, V1, 1
+	b.check(0x3F00)        // SE VF, 0
+	b.ref(0x1000, "done", 0)
+
+	b.label("pixel")
+	b.data(0x80)
+	b.label("line")
+	b.data(0xFF, 0xFF)
+	b.label("done")
+
+	return Program{
+		Name: "display",
+		Checks: []string{
+			"DXYN clears VF without a collision",
+			"DXYN XORs and sets VF on a collision",
+			"DXYN wraps the starting coordinates",
+			"DXYN clips at the right edge",
+			"DXYN clips at the bottom edge",
+		},
+		Rom:    b.assemble(),
+		Frames: 60,
+	}
+}
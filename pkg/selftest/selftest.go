@@ -0,0 +1,140 @@
+// Package selftest is a conformance suite for the interpreter: small test
+// programs covering arithmetic, skips, subroutines, flags, quirks, timers,
+// the keypad and the display.
+//
+// Each program runs a series of numbered checks. When they all pass, it
+// clears the screen and draws PassPattern in the top left corner. When a
+// check fails, it sets VE to the check's number and draws FailPattern
+// instead. A program that faults or runs out of frames without drawing
+// either pattern has failed too.
+package selftest
+
+import (
+	"fmt"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	"github.com/cweagans/chip8/pkg/headless"
+)
+
+// Patterns drawn as a single sprite row at (0, 0) to report a result.
+const (
+	PassPattern = 0xFF
+	FailPattern = 0x81
+)
+
+// Profile is the quirks of an interpreter, along with the programs that
+// check for them. An interpreter with those quirks passes every program.
+type Profile struct {
+	Name     string
+	Quirks   cpu.Quirks
+	Programs []Program
+}
+
+// newProfile returns a profile with the suite built for its quirks.
+func newProfile(name string, q cpu.Quirks) Profile {
+	return Profile{Name: name, Quirks: q, Programs: programs(q)}
+}
+
+// Profiles lists every profile the suite runs under, the COSMAC VIP first.
+var Profiles = []Profile{
+	newProfile("vip", cpu.VIPQuirks),
+	newProfile("schip", cpu.SuperChipQuirks),
+}
+
+// Configure sets the CPU's quirks to the profile's.
+func (p Profile) Configure(c *cpu.Cpu) {
+	c.Quirks = p.Quirks
+}
+
+// Status is the outcome of a single program.
+type Status int
+
+const (
+	Pass Status = iota
+	Fail
+	Fault
+	Timeout
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "PASS"
+	case Fail:
+		return "FAIL"
+	case Fault:
+		return "FAULT"
+	}
+	return "TIMEOUT"
+}
+
+// Result describes how a program did under a profile.
+type Result struct {
+	Program *Program
+	Profile string
+	Status  Status
+	// Check is the number of the failed check, counting from 1.
+	Check int
+	Err   error
+}
+
+func (r Result) String() string {
+	s := fmt.Sprintf("%-7s %s/%s", r.Status, r.Profile, r.Program.Name)
+	switch r.Status {
+	case Fail:
+		desc := "unknown check"
+		if r.Check >= 1 && r.Check <= len(r.Program.Checks) {
+			desc = r.Program.Checks[r.Check-1]
+		}
+		s += fmt.Sprintf(": check %d, %s", r.Check, desc)
+	case Fault:
+		s += ": " + r.Err.Error()
+	case Timeout:
+		s += fmt.Sprintf(": no result after %d frames", r.Program.Frames)
+	}
+	return s
+}
+
+// Run runs a program under a profile.
+func Run(p *Program, profile Profile) Result {
+	c := cpu.NewCpu(nil, p.Rom)
+	profile.Configure(c)
+
+	r := Result{Program: p, Profile: profile.Name}
+	res := headless.Run(c, p.Frames, p.Keys)
+	if res.Err != nil {
+		r.Status, r.Err = Fault, res.Err
+		return r
+	}
+
+	r.Status = Timeout
+	if rest := c.Vram[1:]; isBlank(rest) && uint64(c.Vram[0])<<8 == 0 {
+		switch uint64(c.Vram[0]) >> 56 {
+		case PassPattern:
+			r.Status = Pass
+		case FailPattern:
+			r.Status, r.Check = Fail, int(c.Registers[0xE])
+		}
+	}
+	return r
+}
+
+func isBlank(rows []int64) bool {
+	for _, row := range rows {
+		if row != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RunAll runs every program under every profile.
+func RunAll() []Result {
+	var results []Result
+	for _, profile := range Profiles {
+		for i := range profile.Programs {
+			results = append(results, Run(&profile.Programs[i], profile))
+		}
+	}
+	return results
+}
@@ -0,0 +1,70 @@
+package selftest
+
+import (
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that every program was assembled and describes its checks.
+func TestPrograms(t *testing.T) {
+	assert := asrt.New(t)
+
+	for _, profile := range Profiles {
+		for _, p := range profile.Programs {
+			assert.NotEmpty(p.Checks, p.Name)
+			assert.True(len(p.Rom) > 0 && len(p.Rom) <= 4096-0x200, p.Name)
+		}
+	}
+}
+
+// Test that the interpreter passes every program under every profile.
+func TestPassing(t *testing.T) {
+	assert := asrt.New(t)
+
+	for _, r := range RunAll() {
+		assert.Equal(Pass, r.Status, r.String())
+	}
+}
+
+// Test that the quirks program checks for its own profile's quirks, so it
+// fails under the other profile.
+func TestProfileQuirks(t *testing.T) {
+	assert := asrt.New(t)
+
+	for i, profile := range Profiles {
+		other := Profiles[len(Profiles)-1-i]
+		for j := range profile.Programs {
+			if profile.Programs[j].Name != "quirks" {
+				continue
+			}
+			r := Run(&profile.Programs[j], other)
+			assert.Equal(Fail, r.Status, r.String())
+			assert.Equal(1, r.Check, r.String())
+		}
+	}
+}
+
+// Test that failures, faults and timeouts are told apart.
+func TestResults(t *testing.T) {
+	assert := asrt.New(t)
+
+	b := newBuilder()
+	b.emit(0x6005)
+	b.check(0x3005)
+	b.check(0x3006)
+	p := Program{Name: "fail", Checks: []string{"first", "second"}, Rom: b.assemble(), Frames: 20}
+	r := Run(&p, Profiles[0])
+	assert.Equal(Fail, r.Status)
+	assert.Equal(2, r.Check)
+	assert.Equal("FAIL    vip/fail: check 2, second", r.String())
+
+	p = Program{Name: "fault", Rom: []byte{0x80, 0x1F}, Frames: 20}
+	r = Run(&p, Profiles[0])
+	assert.Equal(Fault, r.Status)
+
+	p = Program{Name: "loop", Rom: []byte{0x12, 0x00}, Frames: 20}
+	r = Run(&p, Profiles[0])
+	assert.Equal(Timeout, r.Status)
+	assert.Equal("TIMEOUT vip/loop: no result after 20 frames", r.String())
+}
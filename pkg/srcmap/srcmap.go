@@ -0,0 +1,130 @@
+// Package srcmap maps ROM addresses to lines of source code.
+//
+// Source maps are plain text, one instruction per line, giving the address and
+// the file and line it was assembled from:
+//
+//	0x200 game.asm:12
+//	0x202 game.asm:13
+//
+// Blank lines and lines starting with # are ignored.
+package srcmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// Entry ties a single address to a source line.
+type Entry struct {
+	Address uint16
+	File    string
+	Line    int
+}
+
+// Map is a set of entries, ordered by address.
+type Map struct {
+	Entries []Entry
+}
+
+// Parse reads a source map in the format described in the package
+// documentation.
+func Parse(r io.Reader) (*Map, error) {
+	m := &Map{}
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected ADDRESS FILE:LINE", n)
+		}
+		addr, err := strconv.ParseUint(fields[0], 0, 16)
+		if err != nil || addr > 0x0FFF {
+			return nil, fmt.Errorf("line %d: invalid address %q", n, fields[0])
+		}
+		i := strings.LastIndex(fields[1], ":")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected FILE:LINE", n)
+		}
+		srcLine, err := strconv.Atoi(fields[1][i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid line number %q", n, fields[1][i+1:])
+		}
+
+		m.Entries = append(m.Entries, Entry{Address: uint16(addr), File: fields[1][:i], Line: srcLine})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(m.Entries, func(i, j int) bool { return m.Entries[i].Address < m.Entries[j].Address })
+	return m, nil
+}
+
+// Load reads a source map from a file.
+func Load(filename string) (*Map, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Lookup returns the source line for the instruction at addr.
+func (m *Map) Lookup(addr uint16) (Entry, bool) {
+	i := sort.Search(len(m.Entries), func(i int) bool { return m.Entries[i].Address >= addr })
+	if i < len(m.Entries) && m.Entries[i].Address == addr {
+		return m.Entries[i], true
+	}
+	return Entry{}, false
+}
+
+// Addresses returns the addresses of all instructions assembled from the
+// given line. File names are compared by suffix so that relative paths in the
+// map match absolute paths from editors.
+func (m *Map) Addresses(file string, line int) []uint16 {
+	var addrs []uint16
+	for _, e := range m.Entries {
+		if e.Line == line && sameFile(e.File, file) {
+			addrs = append(addrs, e.Address)
+		}
+	}
+	return addrs
+}
+
+func sameFile(a, b string) bool {
+	a = strings.Replace(a, "\\", "/", -1)
+	b = strings.Replace(b, "\\", "/", -1)
+	return a == b || strings.HasSuffix(b, "/"+a) || strings.HasSuffix(a, "/"+b)
+}
+
+// Listing disassembles a ROM loaded at 0x200, one instruction per line, and
+// returns the text along with a map from each address to its line. It is
+// used when no assembler source is available.
+func Listing(name string, rom []byte) (string, *Map) {
+	var b strings.Builder
+	m := &Map{}
+	for i := 0; i < len(rom); i += 2 {
+		op := uint16(rom[i]) << 8
+		if i+1 < len(rom) {
+			op |= uint16(rom[i+1])
+		}
+		addr := uint16(0x200 + i)
+		fmt.Fprintf(&b, "0x%03X  %04X  %s\n", addr, op, cpu.Disassemble(op))
+		m.Entries = append(m.Entries, Entry{Address: addr, File: name, Line: i/2 + 1})
+	}
+	return b.String(), m
+}
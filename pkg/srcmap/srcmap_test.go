@@ -0,0 +1,64 @@
+package srcmap
+
+import (
+	"strings"
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that source maps are parsed and sorted by address, skipping comments
+// and blank lines.
+func TestParse(t *testing.T) {
+	assert := asrt.New(t)
+
+	m, err := Parse(strings.NewReader("# game\n\n0x202 src/game.asm:13\n0x200 src/game.asm:12\n  0x204 c:\\lib.asm:1\n"))
+	assert.Nil(err)
+	assert.Equal([]Entry{
+		{Address: 0x200, File: "src/game.asm", Line: 12},
+		{Address: 0x202, File: "src/game.asm", Line: 13},
+		{Address: 0x204, File: "c:\\lib.asm", Line: 1},
+	}, m.Entries)
+
+	e, ok := m.Lookup(0x202)
+	assert.True(ok)
+	assert.Equal(13, e.Line)
+	_, ok = m.Lookup(0x203)
+	assert.False(ok)
+
+	assert.Equal([]uint16{0x200}, m.Addresses("/home/me/src/game.asm", 12))
+	assert.Equal([]uint16{0x204}, m.Addresses("c:/lib.asm", 1))
+	assert.Nil(m.Addresses("other.asm", 12))
+}
+
+// Test that malformed lines are reported with their line number.
+func TestParseErrors(t *testing.T) {
+	assert := asrt.New(t)
+
+	for _, tc := range []struct{ src, err string }{
+		{"0x200\n", "line 1: expected ADDRESS FILE:LINE"},
+		{"\n0x1000 a.asm:1\n", "line 2: invalid address \"0x1000\""},
+		{"zz a.asm:1\n", "line 1: invalid address \"zz\""},
+		{"0x200 a.asm\n", "line 1: expected FILE:LINE"},
+		{"0x200 a.asm:x\n", "line 1: invalid line number \"x\""},
+	} {
+		_, err := Parse(strings.NewReader(tc.src))
+		if assert.NotNil(err, tc.src) {
+			assert.Equal(tc.err, err.Error())
+		}
+	}
+}
+
+// Test that a listing has a line per instruction, and a map from each
+// address to its line.
+func TestListing(t *testing.T) {
+	assert := asrt.New(t)
+
+	text, m := Listing("rom.lst", []byte{0x00, 0xE0, 0x60, 0x2A, 0x12})
+	assert.Equal("0x200  00E0  CLS\n0x202  602A  LD V0, 0x2A\n0x204  1200  JP 0x200\n", text)
+	assert.Equal([]Entry{
+		{Address: 0x200, File: "rom.lst", Line: 1},
+		{Address: 0x202, File: "rom.lst", Line: 2},
+		{Address: 0x204, File: "rom.lst", Line: 3},
+	}, m.Entries)
+}
@@ -0,0 +1,139 @@
+package trace
+
+import (
+	"fmt"
+	"io"
+)
+
+// Mismatch is the first point at which two traces disagree.
+type Mismatch struct {
+	// Index is the position of the differing instruction, counting from 0.
+	Index int
+	// Field names what differs, e.g. "PC", "V3" or "length".
+	Field  string
+	Ours   string
+	Theirs string
+}
+
+func (m *Mismatch) String() string {
+	return fmt.Sprintf("instruction %d: %s differs (ours %s, reference %s)", m.Index, m.Field, m.Ours, m.Theirs)
+}
+
+// Diff compares two traces instruction by instruction and returns the first
+// mismatch, or nil if they agree. Only fields present in both records are
+// compared. If one trace is a prefix of the other, the mismatch is reported
+// as a difference in length.
+func Diff(ours, theirs []Record) *Mismatch {
+	for i := 0; i < len(ours) && i < len(theirs); i++ {
+		if m := diffRecord(ours[i], theirs[i]); m != nil {
+			m.Index = i
+			return m
+		}
+	}
+
+	if len(ours) != len(theirs) {
+		n := len(ours)
+		if len(theirs) < n {
+			n = len(theirs)
+		}
+		return &Mismatch{
+			Index:  n,
+			Field:  "length",
+			Ours:   fmt.Sprintf("%d instructions", len(ours)),
+			Theirs: fmt.Sprintf("%d instructions", len(theirs)),
+		}
+	}
+	return nil
+}
+
+func diffRecord(a, b Record) *Mismatch {
+	both := a.Fields & b.Fields
+
+	hex := func(field string, width int, x, y uint16) *Mismatch {
+		return &Mismatch{Field: field, Ours: fmt.Sprintf("0x%0*X", width, x), Theirs: fmt.Sprintf("0x%0*X", width, y)}
+	}
+
+	if both&FieldPC != 0 && a.PC != b.PC {
+		return hex("PC", 3, a.PC, b.PC)
+	}
+	if both&FieldOp != 0 && a.Op != b.Op {
+		return hex("opcode", 4, a.Op, b.Op)
+	}
+	if both&FieldRegisters != 0 {
+		for r := range a.Registers {
+			if a.Registers[r] != b.Registers[r] {
+				return hex(fmt.Sprintf("V%X", r), 2, uint16(a.Registers[r]), uint16(b.Registers[r]))
+			}
+		}
+	}
+	if both&FieldI != 0 && a.I != b.I {
+		return hex("I", 3, a.I, b.I)
+	}
+	if both&FieldSP != 0 && a.SP != b.SP {
+		return hex("SP", 1, uint16(a.SP), uint16(b.SP))
+	}
+	if both&FieldDT != 0 && a.DT != b.DT {
+		return hex("DT", 2, uint16(a.DT), uint16(b.DT))
+	}
+	if both&FieldST != 0 && a.ST != b.ST {
+		return hex("ST", 2, uint16(a.ST), uint16(b.ST))
+	}
+	if both&FieldWrites != 0 {
+		if len(a.Writes) != len(b.Writes) {
+			return &Mismatch{Field: "memory writes", Ours: writeList(a), Theirs: writeList(b)}
+		}
+		for i := range a.Writes {
+			if a.Writes[i] != b.Writes[i] {
+				return &Mismatch{Field: "memory writes", Ours: writeList(a), Theirs: writeList(b)}
+			}
+		}
+	}
+	return nil
+}
+
+func writeList(r Record) string {
+	if len(r.Writes) == 0 {
+		return "none"
+	}
+	s := ""
+	for i, w := range r.Writes {
+		if i > 0 {
+			s += ","
+		}
+		s += fmt.Sprintf("[0x%03X]=0x%02X", w.Address, w.Value)
+	}
+	return s
+}
+
+// WriteReport describes a mismatch, followed by up to context instructions
+// either side of it from both traces so that the divergence can be seen in
+// context.
+func WriteReport(w io.Writer, ours, theirs []Record, m *Mismatch, context int) {
+	fmt.Fprintf(w, "Traces diverge at %s\n", m)
+
+	start := m.Index - context
+	if start < 0 {
+		start = 0
+	}
+	last := len(ours) - 1
+	if len(theirs) > len(ours) {
+		last = len(theirs) - 1
+	}
+	end := m.Index + context
+	if end > last {
+		end = last
+	}
+	for i := start; i <= end; i++ {
+		marker := " "
+		if i == m.Index {
+			marker = ">"
+		}
+		fmt.Fprintf(w, "\n%s %d\n", marker, i)
+		if i < len(ours) {
+			fmt.Fprintf(w, "  ours:      %s\n", ours[i])
+		}
+		if i < len(theirs) {
+			fmt.Fprintf(w, "  reference: %s\n", theirs[i])
+		}
+	}
+}
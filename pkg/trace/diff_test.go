@@ -0,0 +1,108 @@
+package trace
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+func records(t *testing.T, format string) []Record {
+	buf := &bytes.Buffer{}
+	sink, err := New(format, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, sink)
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := ReadRecords(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+// Test that every comparable format reads back to the same records.
+func TestReadRecords(t *testing.T) {
+	assert := asrt.New(t)
+
+	common := records(t, "common")
+	assert.Len(common, 4)
+	assert.Equal(uint16(0x202), common[1].PC)
+	assert.Equal(uint16(0x300), common[1].I)
+	assert.Equal(uint8(3), common[3].Registers[0xA])
+
+	assert.Nil(Diff(common, records(t, "json")))
+	assert.Nil(Diff(common, records(t, "binary")))
+
+	_, err := ReadRecords(strings.NewReader("1        0x200  6A02  LD VA, 0x02      VA:0->2\n"))
+	assert.Error(err)
+}
+
+// Test that a binary trace claiming more memory writes than it holds is
+// rejected, even when the count is big enough to overflow.
+func TestReadBinaryTruncated(t *testing.T) {
+	assert := asrt.New(t)
+
+	data := append([]byte(BinaryMagic), BinaryVersion, 1)
+	data = append(data, make([]byte, 25)...)
+	// 0xAAAAAAAAAAAAAAAB writes, which is 1 byte modulo 2^64.
+	data = append(data, 0xAB, 0xD5, 0xAA, 0xD5, 0xAA, 0xD5, 0xAA, 0xD5, 0xAA, 0x01)
+	data = append(data, 0x03, 0x00, 0x01)
+	_, err := ReadRecords(bytes.NewReader(data))
+	assert.EqualError(err, "truncated binary trace at record 1")
+}
+
+// Test that the first difference is found and reported.
+func TestDiff(t *testing.T) {
+	assert := asrt.New(t)
+
+	ours := records(t, "common")
+	theirs, err := ReadRecords(strings.NewReader(`# reference trace, no timers
+pc=200 op=6A02 i=000 v=00000000000000000000020000000000
+pc=202 op=A300 i=300 v=00000000000000000000020000000000
+pc=204 op=1206 i=300 v=00000000000000000000020000000000
+pc=206 op=7A01 i=300 v=00000000000000000000040000000000
+`))
+	assert.NoError(err)
+
+	m := Diff(ours, theirs)
+	assert.Equal(&Mismatch{Index: 3, Field: "VA", Ours: "0x03", Theirs: "0x04"}, m)
+
+	buf := &bytes.Buffer{}
+	WriteReport(buf, ours, theirs, m, 1)
+	out := buf.String()
+	assert.Contains(out, "Traces diverge at instruction 3: VA differs (ours 0x03, reference 0x04)")
+	assert.Contains(out, "  2\n")
+	assert.Contains(out, "> 3\n")
+	assert.NotContains(out, "  1\n")
+
+	// Instructions after the divergence are shown too.
+	buf.Reset()
+	WriteReport(buf, ours, theirs, &Mismatch{Index: 1, Field: "I", Ours: "0x300", Theirs: "0x301"}, 1)
+	out = buf.String()
+	assert.Contains(out, "  0\n")
+	assert.Contains(out, "> 1\n")
+	assert.Contains(out, "  2\n")
+	assert.NotContains(out, "  3\n")
+
+	// Fields missing from the reference aren't compared.
+	partial, err := ReadRecords(strings.NewReader("pc=200\npc=202\npc=204\npc=206\n"))
+	assert.NoError(err)
+	assert.Nil(Diff(ours, partial))
+
+	m = Diff(ours, partial[:2])
+	assert.Equal("length", m.Field)
+	assert.Equal(2, m.Index)
+
+	// Memory writes are compared when both traces record them.
+	withWrites, err := ReadRecords(strings.NewReader("pc=200\npc=202 w=300:01\npc=204\npc=206\n"))
+	assert.NoError(err)
+	m = Diff(ours, withWrites)
+	assert.Equal("memory writes", m.Field)
+	assert.Equal("[0x300]=0x01", m.Theirs)
+}
@@ -0,0 +1,84 @@
+package trace
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// Filter selects which instructions are traced. Instructions are traced if
+// their address is between Start and End (inclusive) and their opcode class
+// (the first hex digit of the opcode) is in Classes. An empty class set
+// matches every class.
+type Filter struct {
+	Start   uint16
+	End     uint16
+	Classes uint16
+}
+
+// AllAddresses returns a filter that matches every instruction.
+func AllAddresses() Filter {
+	return Filter{Start: 0x000, End: 0xFFF}
+}
+
+// Match reports whether the filter selects the event.
+func (f Filter) Match(e *cpu.TraceEvent) bool {
+	if e.PC < f.Start || e.PC > f.End {
+		return false
+	}
+	return f.Classes == 0 || f.Classes&(1<<(e.Op>>12)) != 0
+}
+
+// ParseRange parses an address range such as "0x200-0x2FF" into the filter.
+func (f *Filter) ParseRange(s string) error {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid address range %q, expected START-END", s)
+	}
+	start, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid address %q", parts[0])
+	}
+	end, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 0, 16)
+	if err != nil {
+		return fmt.Errorf("invalid address %q", parts[1])
+	}
+	if start > end {
+		return fmt.Errorf("invalid address range %q, start is after end", s)
+	}
+	f.Start, f.End = uint16(start), uint16(end)
+	return nil
+}
+
+// ParseClasses parses a comma separated list of opcode classes, given as the
+// first hex digit of the opcode (e.g. "1,2,D" for jumps, calls and draws).
+func (f *Filter) ParseClasses(s string) error {
+	f.Classes = 0
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		n, err := strconv.ParseUint(c, 16, 4)
+		if err != nil || len(c) != 1 {
+			return fmt.Errorf("invalid opcode class %q, expected a hex digit", c)
+		}
+		f.Classes |= 1 << n
+	}
+	return nil
+}
+
+// Filtered passes events that match Filter on to Sink.
+type Filtered struct {
+	Sink   Sink
+	Filter Filter
+}
+
+func (f *Filtered) Trace(e *cpu.TraceEvent) {
+	if f.Filter.Match(e) {
+		f.Sink.Trace(e)
+	}
+}
+
+func (f *Filtered) Flush() error {
+	return f.Sink.Flush()
+}
@@ -0,0 +1,324 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// Fields of a Record. Traces from other emulators may not include all of
+// them, and fields that are missing from either side aren't compared.
+const (
+	FieldPC = 1 << iota
+	FieldOp
+	FieldRegisters
+	FieldI
+	FieldSP
+	FieldDT
+	FieldST
+	FieldWrites
+)
+
+// Record is the machine state after a single instruction, as read back from
+// a trace.
+type Record struct {
+	Fields    int
+	PC        uint16
+	Op        uint16
+	Registers [16]uint8
+	I         uint16
+	SP        uint8
+	DT        uint8
+	ST        uint8
+	Writes    []cpu.MemoryWrite
+}
+
+func (r Record) String() string {
+	var parts []string
+	if r.Fields&FieldPC != 0 {
+		parts = append(parts, fmt.Sprintf("pc=%03X", r.PC))
+	}
+	if r.Fields&FieldOp != 0 {
+		parts = append(parts, fmt.Sprintf("op=%04X", r.Op))
+	}
+	if r.Fields&FieldI != 0 {
+		parts = append(parts, fmt.Sprintf("i=%03X", r.I))
+	}
+	if r.Fields&FieldSP != 0 {
+		parts = append(parts, fmt.Sprintf("sp=%X", r.SP))
+	}
+	if r.Fields&FieldDT != 0 {
+		parts = append(parts, fmt.Sprintf("dt=%02X", r.DT))
+	}
+	if r.Fields&FieldST != 0 {
+		parts = append(parts, fmt.Sprintf("st=%02X", r.ST))
+	}
+	if r.Fields&FieldRegisters != 0 {
+		parts = append(parts, fmt.Sprintf("v=%X", r.Registers[:]))
+	}
+	if r.Fields&FieldWrites != 0 {
+		ws := make([]string, len(r.Writes))
+		for i, w := range r.Writes {
+			ws[i] = fmt.Sprintf("%03X:%02X", w.Address, w.Value)
+		}
+		parts = append(parts, "w="+strings.Join(ws, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// Common writes traces in the common text format, which is simple enough for
+// other emulators to produce. Each line holds the state after an instruction
+// as space separated key=value pairs, all values in hex:
+//
+//	pc=200 op=6A02 i=000 sp=0 dt=00 st=00 v=00000000000000000000020000000000 w=
+//
+// pc is the address of the instruction, v is V0-VF as 32 hex digits, and w
+// lists memory writes as ADDR:VALUE, separated by commas ("w=" when there
+// were none). Any pair may be left out, in which case it isn't compared; if
+// any line has w, lines without it are taken to have written nothing. Blank
+// lines and lines starting with # are ignored.
+type Common struct {
+	w   *bufio.Writer
+	err error
+}
+
+// NewCommon returns a sink that writes the common text format to w.
+func NewCommon(w io.Writer) *Common {
+	return &Common{w: bufio.NewWriter(w)}
+}
+
+func (c *Common) Trace(e *cpu.TraceEvent) {
+	if c.err != nil {
+		return
+	}
+	_, c.err = c.w.WriteString(recordFromEvent(e).String() + "\n")
+}
+
+func (c *Common) Flush() error {
+	if c.err != nil {
+		return c.err
+	}
+	return c.w.Flush()
+}
+
+func recordFromEvent(e *cpu.TraceEvent) Record {
+	return Record{
+		Fields:    FieldPC | FieldOp | FieldRegisters | FieldI | FieldSP | FieldDT | FieldST | FieldWrites,
+		PC:        e.PC,
+		Op:        e.Op,
+		Registers: e.Registers,
+		I:         e.IndexRegister,
+		SP:        uint8(e.StackPointer),
+		DT:        e.DelayTimer,
+		ST:        e.SoundTimer,
+		Writes:    e.Writes,
+	}
+}
+
+// ReadRecords reads a trace in the binary, JSON lines or common text format,
+// detecting which one it is from the content.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(data, []byte(BinaryMagic)) {
+		return readBinary(data)
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		return readJSON(trimmed)
+	}
+	return readCommon(data)
+}
+
+func readBinary(data []byte) ([]Record, error) {
+	if len(data) < len(BinaryMagic)+1 || data[len(BinaryMagic)] != BinaryVersion {
+		return nil, fmt.Errorf("unsupported binary trace version")
+	}
+	data = data[len(BinaryMagic)+1:]
+
+	var records []Record
+	for len(data) > 0 {
+		_, n := binary.Uvarint(data)
+		if n <= 0 || len(data) < n+25 {
+			return nil, fmt.Errorf("truncated binary trace at record %d", len(records)+1)
+		}
+		data = data[n:]
+
+		r := Record{Fields: FieldPC | FieldOp | FieldRegisters | FieldI | FieldSP | FieldDT | FieldST | FieldWrites}
+		r.PC = binary.BigEndian.Uint16(data[0:])
+		r.Op = binary.BigEndian.Uint16(data[2:])
+		copy(r.Registers[:], data[4:20])
+		r.I = binary.BigEndian.Uint16(data[20:])
+		r.SP, r.DT, r.ST = data[22], data[23], data[24]
+		data = data[25:]
+
+		count, n := binary.Uvarint(data)
+		if n <= 0 || count > uint64(len(data)-n)/3 {
+			return nil, fmt.Errorf("truncated binary trace at record %d", len(records)+1)
+		}
+		data = data[n:]
+		for i := uint64(0); i < count; i++ {
+			r.Writes = append(r.Writes, cpu.MemoryWrite{Address: binary.BigEndian.Uint16(data), Value: data[2]})
+			data = data[3:]
+		}
+
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func readJSON(data []byte) ([]Record, error) {
+	var records []Record
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for dec.More() {
+		var e JSONEvent
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("record %d: %s", len(records)+1, err.Error())
+		}
+		r := Record{
+			Fields:    FieldPC | FieldOp | FieldRegisters | FieldI | FieldSP | FieldDT | FieldST | FieldWrites,
+			PC:        e.PC,
+			Op:        e.Op,
+			Registers: e.Registers,
+			I:         e.I,
+			SP:        uint8(e.SP),
+			DT:        e.DT,
+			ST:        e.ST,
+		}
+		for _, w := range e.Writes {
+			r.Writes = append(r.Writes, cpu.MemoryWrite{Address: w.Address, Value: w.Value})
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func readCommon(data []byte) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	n := 0
+	writes := false
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		r, err := parseCommon(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err.Error())
+		}
+		records = append(records, r)
+		writes = writes || r.Fields&FieldWrites != 0
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Lines without w= in a trace that records writes didn't write anything.
+	if writes {
+		for i := range records {
+			records[i].Fields |= FieldWrites
+		}
+	}
+	return records, nil
+}
+
+func parseCommon(line string) (Record, error) {
+	var r Record
+	for _, pair := range strings.Fields(line) {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return r, fmt.Errorf("expected key=value, got %q (text traces from -trace-format text can't be compared, use json or binary)", pair)
+		}
+		key, value := strings.ToLower(kv[0]), kv[1]
+
+		var err error
+		switch key {
+		case "pc":
+			r.PC, err = parseHex16(value)
+			r.Fields |= FieldPC
+		case "op":
+			r.Op, err = parseHex16(value)
+			r.Fields |= FieldOp
+		case "i":
+			r.I, err = parseHex16(value)
+			r.Fields |= FieldI
+		case "sp":
+			r.SP, err = parseHex8(value)
+			r.Fields |= FieldSP
+		case "dt":
+			r.DT, err = parseHex8(value)
+			r.Fields |= FieldDT
+		case "st":
+			r.ST, err = parseHex8(value)
+			r.Fields |= FieldST
+		case "v":
+			if len(value) != 32 {
+				return r, fmt.Errorf("v must be 32 hex digits")
+			}
+			for i := range r.Registers {
+				r.Registers[i], err = parseHex8(value[i*2 : i*2+2])
+				if err != nil {
+					break
+				}
+			}
+			r.Fields |= FieldRegisters
+		case "w":
+			r.Fields |= FieldWrites
+			if value == "" {
+				continue
+			}
+			for _, w := range strings.Split(value, ",") {
+				parts := strings.SplitN(w, ":", 2)
+				if len(parts) != 2 {
+					return r, fmt.Errorf("invalid memory write %q", w)
+				}
+				var mw cpu.MemoryWrite
+				if mw.Address, err = parseHex16(parts[0]); err != nil {
+					break
+				}
+				if mw.Value, err = parseHex8(parts[1]); err != nil {
+					break
+				}
+				r.Writes = append(r.Writes, mw)
+			}
+		default:
+			return r, fmt.Errorf("unknown key %q", key)
+		}
+
+		if err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+func parseHex16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hex value %q", s)
+	}
+	return uint16(v), nil
+}
+
+func parseHex8(s string) (uint8, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.ToLower(s), "0x"), 16, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hex value %q", s)
+	}
+	return uint8(v), nil
+}
@@ -0,0 +1,222 @@
+// Package trace provides sinks for the CPU's execution trace, in a human
+// readable text format, JSON lines, a compact binary format, or a common text
+// format that other emulators can produce, and compares traces against each
+// other.
+package trace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// Sink is a tracer that writes events somewhere. Flush must be called once
+// tracing is finished; it returns the first error encountered while writing.
+type Sink interface {
+	cpu.Tracer
+	Flush() error
+}
+
+// New returns a sink for the named format: text, json, binary or common.
+func New(format string, w io.Writer) (Sink, error) {
+	switch format {
+	case "text":
+		return NewText(w), nil
+	case "json":
+		return NewJSON(w), nil
+	case "binary":
+		return NewBinary(w), nil
+	case "common":
+		return NewCommon(w), nil
+	}
+	return nil, fmt.Errorf("unknown trace format %q", format)
+}
+
+// Text writes one line per instruction, showing only the registers and
+// memory that changed.
+type Text struct {
+	w   *bufio.Writer
+	err error
+}
+
+// NewText returns a sink that writes human readable lines to w.
+func NewText(w io.Writer) *Text {
+	return &Text{w: bufio.NewWriter(w)}
+}
+
+func (t *Text) Trace(e *cpu.TraceEvent) {
+	if t.err != nil {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-8d 0x%03X  %04X  %-16s", e.Cycle, e.PC, e.Op, e.Mnemonic)
+	for _, c := range e.Changes {
+		fmt.Fprintf(&b, " %s:%X->%X", c.Register, c.Old, c.New)
+	}
+	for _, w := range e.Writes {
+		fmt.Fprintf(&b, " [%03X]=%02X", w.Address, w.Value)
+	}
+
+	_, t.err = t.w.WriteString(strings.TrimRight(b.String(), " ") + "\n")
+}
+
+func (t *Text) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+	return t.w.Flush()
+}
+
+// JSONEvent is the structure of each line written by the JSON sink.
+type JSONEvent struct {
+	Cycle     uint64       `json:"cycle"`
+	PC        uint16       `json:"pc"`
+	Op        uint16       `json:"op"`
+	Mnemonic  string       `json:"mnemonic"`
+	Registers [16]uint8    `json:"v"`
+	I         uint16       `json:"i"`
+	SP        int          `json:"sp"`
+	DT        uint8        `json:"dt"`
+	ST        uint8        `json:"st"`
+	Changes   []JSONChange `json:"changes,omitempty"`
+	Writes    []JSONWrite  `json:"writes,omitempty"`
+}
+
+// JSONChange is a register change in a JSONEvent.
+type JSONChange struct {
+	Register string `json:"reg"`
+	Old      uint16 `json:"old"`
+	New      uint16 `json:"new"`
+}
+
+// JSONWrite is a memory write in a JSONEvent.
+type JSONWrite struct {
+	Address uint16 `json:"addr"`
+	Value   uint8  `json:"value"`
+}
+
+// JSON writes one JSON object per line for each instruction.
+type JSON struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error
+}
+
+// NewJSON returns a sink that writes JSON lines to w.
+func NewJSON(w io.Writer) *JSON {
+	bw := bufio.NewWriter(w)
+	return &JSON{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (j *JSON) Trace(e *cpu.TraceEvent) {
+	if j.err != nil {
+		return
+	}
+
+	je := JSONEvent{
+		Cycle:     e.Cycle,
+		PC:        e.PC,
+		Op:        e.Op,
+		Mnemonic:  e.Mnemonic,
+		Registers: e.Registers,
+		I:         e.IndexRegister,
+		SP:        e.StackPointer,
+		DT:        e.DelayTimer,
+		ST:        e.SoundTimer,
+	}
+	for _, c := range e.Changes {
+		je.Changes = append(je.Changes, JSONChange{c.Register, c.Old, c.New})
+	}
+	for _, w := range e.Writes {
+		je.Writes = append(je.Writes, JSONWrite{w.Address, w.Value})
+	}
+
+	j.err = j.enc.Encode(je)
+}
+
+func (j *JSON) Flush() error {
+	if j.err != nil {
+		return j.err
+	}
+	return j.w.Flush()
+}
+
+// BinaryMagic starts every binary trace, followed by a version byte.
+const BinaryMagic = "C8TR"
+
+// BinaryVersion is the version of the binary format written by this package.
+const BinaryVersion = 1
+
+// Binary writes a compact record for each instruction:
+//
+//	cycle   uvarint
+//	pc, op  uint16 each, big-endian
+//	V0-VF   16 bytes
+//	I       uint16, big-endian
+//	SP, DT, ST  one byte each
+//	writes  uvarint count, then address (uint16, big-endian) and value (byte) pairs
+//
+// Register changes aren't stored, as they can be recovered by comparing
+// consecutive records.
+type Binary struct {
+	w       *bufio.Writer
+	err     error
+	started bool
+	buf     []byte
+}
+
+// NewBinary returns a sink that writes the binary format to w.
+func NewBinary(w io.Writer) *Binary {
+	return &Binary{w: bufio.NewWriter(w)}
+}
+
+func (b *Binary) Trace(e *cpu.TraceEvent) {
+	if b.err != nil {
+		return
+	}
+
+	buf := b.buf[:0]
+	if !b.started {
+		buf = append(buf, BinaryMagic...)
+		buf = append(buf, BinaryVersion)
+		b.started = true
+	}
+
+	buf = appendUvarint(buf, e.Cycle)
+	buf = appendUint16(buf, e.PC)
+	buf = appendUint16(buf, e.Op)
+	buf = append(buf, e.Registers[:]...)
+	buf = appendUint16(buf, e.IndexRegister)
+	buf = append(buf, uint8(e.StackPointer), e.DelayTimer, e.SoundTimer)
+	buf = appendUvarint(buf, uint64(len(e.Writes)))
+	for _, w := range e.Writes {
+		buf = appendUint16(buf, w.Address)
+		buf = append(buf, w.Value)
+	}
+
+	b.buf = buf
+	_, b.err = b.w.Write(buf)
+}
+
+func (b *Binary) Flush() error {
+	if b.err != nil {
+		return b.err
+	}
+	return b.w.Flush()
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
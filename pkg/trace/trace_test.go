@@ -0,0 +1,146 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// 0x200: LD VA, 0x02
+// 0x202: LD I, 0x300
+// 0x204: JP 0x206
+// 0x206: ADD VA, 0x01
+var testRom = []byte{0x6A, 0x02, 0xA3, 0x00, 0x12, 0x06, 0x7A, 0x01}
+
+func run(t *testing.T, tracer cpu.Tracer) {
+	runRom(t, tracer, testRom)
+}
+
+func runRom(t *testing.T, tracer cpu.Tracer, rom []byte) {
+	c := cpu.NewCpu(nil, rom)
+	c.Tracer = tracer
+	for !c.ShouldHalt {
+		if err := c.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Test the human readable format.
+func TestText(t *testing.T) {
+	assert := asrt.New(t)
+
+	buf := &bytes.Buffer{}
+	sink, err := New("text", buf)
+	assert.NoError(err)
+	run(t, sink)
+	assert.NoError(sink.Flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 4)
+	assert.Equal("1        0x200  6A02  LD VA, 0x02      VA:0->2", lines[0])
+	assert.Equal("2        0x202  A300  LD I, 0x300      I:0->300", lines[1])
+	assert.Equal("3        0x204  1206  JP 0x206", lines[2])
+}
+
+// Test that JSON lines carry the full register state.
+func TestJSON(t *testing.T) {
+	assert := asrt.New(t)
+
+	buf := &bytes.Buffer{}
+	sink, err := New("json", buf)
+	assert.NoError(err)
+	run(t, sink)
+	assert.NoError(sink.Flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 4)
+
+	var e JSONEvent
+	assert.NoError(json.Unmarshal([]byte(lines[3]), &e))
+	assert.Equal(uint64(4), e.Cycle)
+	assert.Equal(uint16(0x206), e.PC)
+	assert.Equal("ADD VA, 0x01", e.Mnemonic)
+	assert.Equal(uint8(3), e.Registers[0xA])
+	assert.Equal(uint16(0x300), e.I)
+	assert.Equal([]JSONChange{{"VA", 2, 3}}, e.Changes)
+}
+
+// Test the layout of binary records.
+func TestBinary(t *testing.T) {
+	assert := asrt.New(t)
+
+	buf := &bytes.Buffer{}
+	sink, err := New("binary", buf)
+	assert.NoError(err)
+	run(t, sink)
+	assert.NoError(sink.Flush())
+
+	data := buf.Bytes()
+	assert.Equal([]byte("C8TR\x01"), data[:5])
+
+	// Each record is 1 (cycle) + 4 (pc, op) + 16 (V) + 2 (I) + 3 + 1 (writes).
+	assert.Len(data, 5+4*27)
+	first := data[5 : 5+27]
+	assert.Equal([]byte{0x01, 0x02, 0x00, 0x6A, 0x02}, first[:5])
+	assert.Equal(uint8(0x02), first[5+0xA])
+}
+
+// Test filtering by address range and opcode class.
+func TestFilter(t *testing.T) {
+	assert := asrt.New(t)
+
+	_, err := New("xml", nil)
+	assert.Error(err)
+
+	buf := &bytes.Buffer{}
+	f := &Filtered{Sink: NewText(buf), Filter: AllAddresses()}
+	assert.NoError(f.Filter.ParseRange("0x202-0x206"))
+	assert.NoError(f.Filter.ParseClasses("1,7"))
+	run(t, f)
+	assert.NoError(f.Flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 2)
+	assert.Contains(lines[0], "JP 0x206")
+	assert.Contains(lines[1], "ADD VA, 0x01")
+
+	assert.Error(f.Filter.ParseRange("0x300-0x200"))
+	assert.Error(f.Filter.ParseRange("0x300"))
+	assert.Error(f.Filter.ParseClasses("1,G"))
+	assert.Error(f.Filter.ParseClasses("12"))
+}
+
+// Test that memory written by instructions is traced in every format.
+func TestWrites(t *testing.T) {
+	assert := asrt.New(t)
+
+	// LD I, 0x300; LD V0, 0x12; LD V1, 0x34; LD [I], V1
+	rom := []byte{0xA3, 0x00, 0x60, 0x12, 0x61, 0x34, 0xF1, 0x55}
+	want := []cpu.MemoryWrite{{Address: 0x300, Value: 0x12}, {Address: 0x301, Value: 0x34}}
+
+	buf := &bytes.Buffer{}
+	sink := NewText(buf)
+	runRom(t, sink, rom)
+	assert.NoError(sink.Flush())
+	assert.Contains(buf.String(), "LD [I], V1       I:300->302 [300]=12 [301]=34\n")
+
+	for _, format := range []string{"json", "binary", "common"} {
+		buf := &bytes.Buffer{}
+		sink, err := New(format, buf)
+		assert.NoError(err)
+		runRom(t, sink, rom)
+		assert.NoError(sink.Flush())
+
+		rs, err := ReadRecords(buf)
+		assert.NoError(err, format)
+		if assert.Len(rs, 4, format) {
+			assert.Empty(rs[2].Writes, format)
+			assert.Equal(want, rs[3].Writes, format)
+		}
+	}
+}
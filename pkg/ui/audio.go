@@ -0,0 +1,180 @@
+package ui
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Audio plays the buzzer, which sounds while the sound timer is running.
+type Audio interface {
+	// Sound starts or stops the buzzer.
+	Sound(on bool)
+
+	// Close stops the buzzer and releases the audio device.
+	Close()
+}
+
+// Waveform is the shape of the buzzer's tone.
+type Waveform int
+
+const (
+	Square Waveform = iota
+	Triangle
+	Sine
+	Sawtooth
+)
+
+var waveformNames = []string{"square", "triangle", "sine", "sawtooth"}
+
+func (w Waveform) String() string {
+	if int(w) < len(waveformNames) {
+		return waveformNames[w]
+	}
+	return "unknown"
+}
+
+// ParseWaveform returns the waveform with the given name.
+func ParseWaveform(name string) (Waveform, error) {
+	for w, n := range waveformNames {
+		if strings.EqualFold(name, n) {
+			return Waveform(w), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown waveform %q", name)
+}
+
+// sample returns the waveform's level, from -1 to 1, at a point in its
+// cycle, from 0 to 1.
+func (w Waveform) sample(phase float64) float64 {
+	switch w {
+	case Triangle:
+		return 4*math.Abs(phase-0.5) - 1
+	case Sine:
+		return math.Sin(2 * math.Pi * phase)
+	case Sawtooth:
+		return 2*phase - 1
+	}
+	if phase < 0.5 {
+		return 1
+	}
+	return -1
+}
+
+// Tone describes the sound of the buzzer.
+type Tone struct {
+	// Pitch is the frequency in Hz.
+	Pitch float64
+	// Volume is from 0 (silent) to 1 (full scale).
+	Volume   float64
+	Waveform Waveform
+}
+
+// DefaultTone is a quiet 440 Hz square wave.
+var DefaultTone = Tone{Pitch: 440, Volume: 0.25, Waveform: Square}
+
+// Validate checks that the pitch and volume are in range.
+func (t Tone) Validate() error {
+	if t.Pitch <= 0 {
+		return fmt.Errorf("pitch must be above 0 Hz")
+	}
+	if t.Volume < 0 || t.Volume > 1 {
+		return fmt.Errorf("volume must be between 0 and 1")
+	}
+	return nil
+}
+
+// RampTime is how long the buzzer takes to fade in and out. Starting or
+// stopping a tone instantly makes an audible click.
+const RampTime = 5 * time.Millisecond
+
+// Synth generates the buzzer's tone as signed 16-bit samples.
+type Synth struct {
+	Tone Tone
+	Rate int
+
+	on    bool
+	gain  float64
+	phase float64
+}
+
+// NewSynth returns a silent synth for the given tone and sample rate.
+func NewSynth(t Tone, rate int) *Synth {
+	return &Synth{Tone: t, Rate: rate}
+}
+
+// SetOn starts or stops the tone. It fades in or out over RampTime.
+func (s *Synth) SetOn(on bool) {
+	s.on = on
+}
+
+// Fill writes the next samples to buf.
+func (s *Synth) Fill(buf []int16) {
+	ramp := 1 / (RampTime.Seconds() * float64(s.Rate))
+	step := s.Tone.Pitch / float64(s.Rate)
+	for i := range buf {
+		if s.on {
+			s.gain = math.Min(1, s.gain+ramp)
+		} else {
+			s.gain = math.Max(0, s.gain-ramp)
+		}
+		if s.gain == 0 {
+			// Start each tone at the beginning of its cycle.
+			buf[i] = 0
+			s.phase = 0
+			continue
+		}
+
+		v := s.Tone.Waveform.sample(s.phase) * s.gain * s.Tone.Volume
+		buf[i] = int16(v * math.MaxInt16)
+		s.phase += step
+		s.phase -= math.Floor(s.phase)
+	}
+}
+
+// Span is a period during which the buzzer was sounding. End is zero while
+// it's still going.
+type Span struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// NullAudio doesn't make any noise, but records when the buzzer sounded, for
+// headless runs and tests.
+type NullAudio struct {
+	// Clock returns the current time. If it's nil, the time since the first
+	// call to Sound is used. Headless runs can use emulated time instead.
+	Clock func() time.Duration
+
+	Spans []Span
+
+	on    bool
+	start time.Time
+}
+
+func (n *NullAudio) now() time.Duration {
+	if n.Clock != nil {
+		return n.Clock()
+	}
+	if n.start.IsZero() {
+		n.start = time.Now()
+	}
+	return time.Since(n.start)
+}
+
+func (n *NullAudio) Sound(on bool) {
+	if on == n.on {
+		return
+	}
+	n.on = on
+	if on {
+		n.Spans = append(n.Spans, Span{Start: n.now()})
+	} else {
+		n.Spans[len(n.Spans)-1].End = n.now()
+	}
+}
+
+func (n *NullAudio) Close() {
+	n.Sound(false)
+}
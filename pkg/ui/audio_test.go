@@ -0,0 +1,107 @@
+package ui
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test the shape of each waveform.
+func TestWaveforms(t *testing.T) {
+	assert := asrt.New(t)
+
+	assert.Equal(1.0, Square.sample(0.25))
+	assert.Equal(-1.0, Square.sample(0.75))
+	assert.Equal(1.0, Triangle.sample(0))
+	assert.Equal(-1.0, Triangle.sample(0.5))
+	assert.InDelta(1.0, Sine.sample(0.25), 1e-9)
+	assert.Equal(0.0, Sawtooth.sample(0.5))
+
+	for _, w := range []Waveform{Square, Triangle, Sine, Sawtooth} {
+		parsed, err := ParseWaveform(w.String())
+		assert.Nil(err)
+		assert.Equal(w, parsed)
+	}
+	_, err := ParseWaveform("noise")
+	assert.NotNil(err)
+}
+
+// Test that the synth is silent until started, and fades in and out rather
+// than jumping, so that the buzzer doesn't click.
+func TestSynth(t *testing.T) {
+	assert := asrt.New(t)
+
+	rate := 8000
+	s := NewSynth(Tone{Pitch: 50, Volume: 1, Waveform: Square}, rate)
+	buf := make([]int16, rate/10)
+
+	s.Fill(buf)
+	assert.Equal(make([]int16, len(buf)), buf)
+
+	// A square wave stays high for its first half cycle, so during the fade
+	// in each sample can only be a little louder than the last.
+	ramp := int(RampTime.Seconds() * float64(rate))
+	maxStep := math.MaxInt16/ramp + 1
+	s.SetOn(true)
+	s.Fill(buf)
+	for i := 1; i <= ramp; i++ {
+		assert.True(int(buf[i])-int(buf[i-1]) <= maxStep, "sample %d jumps", i)
+	}
+	assert.Equal(int16(math.MaxInt16), buf[ramp+1])
+
+	// 50 Hz at 8 kHz is 160 samples per cycle.
+	assert.True(buf[ramp+1+80] < 0)
+
+	s.SetOn(false)
+	s.Fill(buf)
+	assert.NotEqual(int16(0), buf[0])
+	for _, v := range buf[ramp+1:] {
+		assert.Equal(int16(0), v)
+	}
+}
+
+// Test that tones outside the allowed range are rejected.
+func TestToneValidate(t *testing.T) {
+	assert := asrt.New(t)
+
+	assert.Nil(DefaultTone.Validate())
+	assert.NotNil(Tone{Pitch: 0, Volume: 0.5}.Validate())
+	assert.NotNil(Tone{Pitch: 440, Volume: 1.5}.Validate())
+}
+
+// Test that the null driver records when the buzzer sounded.
+func TestNullAudio(t *testing.T) {
+	assert := asrt.New(t)
+
+	var now time.Duration
+	n := &NullAudio{Clock: func() time.Duration { return now }}
+
+	now = 10 * time.Millisecond
+	n.Sound(true)
+	now = 20 * time.Millisecond
+	n.Sound(true)
+	now = 30 * time.Millisecond
+	n.Sound(false)
+	n.Sound(false)
+	now = 50 * time.Millisecond
+	n.Sound(true)
+	assert.Equal([]Span{{10 * time.Millisecond, 30 * time.Millisecond}, {Start: 50 * time.Millisecond}}, n.Spans)
+
+	now = 60 * time.Millisecond
+	n.Close()
+	assert.Equal(60*time.Millisecond, n.Spans[1].End)
+}
+
+// Test that the host passes the buzzer on to its audio driver.
+func TestHostSound(t *testing.T) {
+	assert := asrt.New(t)
+
+	n := &NullAudio{Clock: func() time.Duration { return 0 }}
+	h := Host{UI: Noop{}, Audio: n}
+	h.Sound(true)
+	assert.Len(n.Spans, 1)
+
+	Host{UI: Noop{}}.Sound(true)
+}
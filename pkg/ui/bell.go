@@ -0,0 +1,57 @@
+package ui
+
+import (
+	"io"
+	"os"
+)
+
+// Bell rings the terminal bell each time the buzzer starts. Terminals can't
+// hold a tone, so how long the buzzer lasts is lost.
+type Bell struct {
+	// Out is where the BEL character is written. If it's nil, os.Stdout is
+	// used.
+	Out io.Writer
+}
+
+func (b Bell) Sound(on bool) {
+	if !on {
+		return
+	}
+	out := b.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	out.Write([]byte{'\a'})
+}
+
+func (b Bell) Close() {}
+
+// Flash is a visual bell for the termbox UI: the border around the display
+// lights up while the buzzer sounds.
+type Flash struct {
+	UI *Termbox
+}
+
+func (f Flash) Sound(on bool) {
+	f.UI.SetFlash(on)
+}
+
+func (f Flash) Close() {
+	f.UI.SetFlash(false)
+}
+
+// Audios plays the buzzer through several drivers at once, e.g. a Bell and a
+// Flash.
+type Audios []Audio
+
+func (as Audios) Sound(on bool) {
+	for _, a := range as {
+		a.Sound(on)
+	}
+}
+
+func (as Audios) Close() {
+	for _, a := range as {
+		a.Close()
+	}
+}
@@ -0,0 +1,35 @@
+package ui
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that the bell rings once each time the buzzer starts.
+func TestBell(t *testing.T) {
+	assert := asrt.New(t)
+
+	var out bytes.Buffer
+	b := Bell{Out: &out}
+	b.Sound(true)
+	b.Sound(false)
+	b.Sound(true)
+	b.Close()
+	assert.Equal("\a\a", out.String())
+}
+
+// Test that several drivers can play the buzzer at once.
+func TestAudios(t *testing.T) {
+	assert := asrt.New(t)
+
+	var out bytes.Buffer
+	n := &NullAudio{Clock: func() time.Duration { return time.Second }}
+	as := Audios{Bell{Out: &out}, n}
+	as.Sound(true)
+	as.Close()
+	assert.Equal("\a", out.String())
+	assert.Equal([]Span{{time.Second, time.Second}}, n.Spans)
+}
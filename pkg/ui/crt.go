@@ -0,0 +1,360 @@
+package ui
+
+import (
+	"fmt"
+	"image"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Effects are post-processing effects that imitate a CRT, drawn by the SDL
+// UI over the scaled display. They're done in software, so they work without
+// a GPU, but they take longer the bigger the window is.
+type Effects uint
+
+const (
+	// Scanlines darkens every other line.
+	Scanlines Effects = 1 << iota
+	// Bloom makes lit pixels glow onto their neighbours.
+	Bloom
+	// Curvature bulges the display out like the glass of a tube.
+	Curvature
+	// Vignette darkens the edges and corners.
+	Vignette
+
+	// AllEffects is every effect at once.
+	AllEffects = Scanlines | Bloom | Curvature | Vignette
+)
+
+var effectNames = []struct {
+	effect Effects
+	name   string
+}{
+	{Scanlines, "scanlines"},
+	{Bloom, "bloom"},
+	{Curvature, "curvature"},
+	{Vignette, "vignette"},
+}
+
+// String returns the effects' names separated by commas, or "none".
+func (e Effects) String() string {
+	var names []string
+	for _, n := range effectNames {
+		if e&n.effect != 0 {
+			names = append(names, n.name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// ParseEffects reads a list of effects separated by commas. "all" and "none"
+// are also accepted.
+func ParseEffects(list string) (Effects, error) {
+	var e Effects
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "", "none":
+			continue
+		case "all":
+			e |= AllEffects
+			continue
+		}
+
+		found := false
+		for _, n := range effectNames {
+			if name == n.name {
+				e |= n.effect
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown effect %q", name)
+		}
+	}
+	return e, nil
+}
+
+// Strengths of the effects, as fractions of 256 where they're levels.
+const (
+	scanlineLevel = 154  // brightness of the darkened lines
+	bloomLevel    = 154  // how much of the glow is added back
+	bulge         = 0.1  // how far the corners are pulled in
+	vignetteLevel = 0.45 // how much the corners are darkened
+)
+
+// applyEffects draws effects over an image of the scaled display. pixel is
+// the size of a display pixel in the image, which sets how far bloom
+// spreads.
+func applyEffects(img *image.RGBA, e Effects, pixel int) {
+	newCRT(img.Bounds().Dx(), img.Bounds().Dy()).apply(img, e, pixel)
+}
+
+// crt applies effects to images of one size. Working out where each pixel
+// of a curved screen comes from, and how dark the vignette makes it, is slow
+// enough to be worth keeping for the next frame.
+type crt struct {
+	w, h int
+
+	// curve is the offset in the image that each pixel is copied from, or
+	// -1 for black.
+	curve []int32
+	// shade is how bright the vignette leaves each pixel, out of 256.
+	shade []uint16
+	// src is a copy of the image for curving it.
+	src []uint8
+}
+
+func newCRT(w, h int) *crt {
+	return &crt{w: w, h: h}
+}
+
+func (c *crt) apply(img *image.RGBA, e Effects, pixel int) {
+	if e&Bloom != 0 {
+		c.bloom(img, pixel)
+	}
+	if e&Scanlines != 0 {
+		c.scanlines(img)
+	}
+	if e&Curvature != 0 {
+		c.curveImage(img)
+	}
+	if e&Vignette != 0 {
+		c.vignette(img)
+	}
+}
+
+// inParallel splits the lines from 0 to n between the CPUs, and calls f
+// with each share at the same time.
+func inParallel(n int, f func(from, to int)) {
+	workers := runtime.NumCPU()
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		f(0, n)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			f(from, to)
+		}(n*i/workers, n*(i+1)/workers)
+	}
+	wg.Wait()
+}
+
+func (c *crt) scanlines(img *image.RGBA) {
+	inParallel(c.h, func(from, to int) {
+		for y := from; y < to; y++ {
+			if y%2 == 0 {
+				continue
+			}
+			row := img.Pix[y*img.Stride : y*img.Stride+c.w*4]
+			for i := 0; i < len(row); i += 4 {
+				row[i] = uint8(int(row[i]) * scanlineLevel >> 8)
+				row[i+1] = uint8(int(row[i+1]) * scanlineLevel >> 8)
+				row[i+2] = uint8(int(row[i+2]) * scanlineLevel >> 8)
+			}
+		}
+	})
+}
+
+// bloom adds a blurred copy of the image back onto it. The glow is soft, so
+// it's blurred at a fraction of the size and scaled back up, and two box
+// blurs in a row are close enough to a gaussian.
+func (c *crt) bloom(img *image.RGBA, pixel int) {
+	d := pixel / 4
+	if d < 1 {
+		d = 1
+	}
+	radius := (pixel/2 + 1) / d
+	if radius < 1 {
+		radius = 1
+	}
+
+	// Sample the middle of each d x d block. The display is made of blocks
+	// at least that big, so nothing but the grid is missed.
+	sw, sh := (c.w+d-1)/d, (c.h+d-1)/d
+	small := make([]int, sw*sh*3)
+	for sy := 0; sy < sh; sy++ {
+		y := sy*d + d/2
+		if y >= c.h {
+			y = c.h - 1
+		}
+		for sx := 0; sx < sw; sx++ {
+			x := sx*d + d/2
+			if x >= c.w {
+				x = c.w - 1
+			}
+			i, j := y*img.Stride+x*4, (sy*sw+sx)*3
+			small[j], small[j+1], small[j+2] = int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2])
+		}
+	}
+	for i := 0; i < 2; i++ {
+		boxBlur(small, sh, sw, sw*3, 3, radius)
+		boxBlur(small, sw, sh, 3, sw*3, radius)
+	}
+
+	// Scale the glow back up, interpolating between the four nearest samples
+	// in fixed point: across each row of samples once, laid out like the
+	// image's pixels, and then between rows for each line of the image.
+	x0, x1, wx := upscale(c.w, sw, d)
+	wide := make([]uint8, sh*c.w*4)
+	for sy := 0; sy < sh; sy++ {
+		samples, row := small[sy*sw*3:], wide[sy*c.w*4:]
+		for x := 0; x < c.w; x++ {
+			l, r, w := x0[x]*3, x1[x]*3, wx[x]
+			for ch := 0; ch < 3; ch++ {
+				glow := (samples[l+ch]*(256-w) + samples[r+ch]*w) >> 8
+				row[x*4+ch] = uint8(glow * bloomLevel >> 8)
+			}
+		}
+	}
+
+	y0, y1, wy := upscale(c.h, sh, d)
+	n := c.w * 4
+	inParallel(c.h, func(from, to int) {
+		for y := from; y < to; y++ {
+			a, b, w := wide[y0[y]*n:y0[y]*n+n], wide[y1[y]*n:y1[y]*n+n], wy[y]
+			pix := img.Pix[y*img.Stride : y*img.Stride+n]
+			for i := range pix {
+				v := int(pix[i]) + (int(a[i])*(256-w)+int(b[i])*w)>>8
+				if v > 0xFF {
+					v = 0xFF
+				}
+				pix[i] = uint8(v)
+			}
+		}
+	})
+}
+
+// upscale returns, for each of n pixels scaled up by d from samples taken in
+// the middle of each block, the samples either side of it and how far it is
+// from the first to the second, out of 256.
+func upscale(n, samples, d int) (first, second, weight []int) {
+	first, second, weight = make([]int, n), make([]int, n), make([]int, n)
+	for i := 0; i < n; i++ {
+		// In 256ths of a sample, measured from the middle of the first.
+		pos := (i*256 - d/2*256) / d
+		if pos < 0 {
+			pos = 0
+		}
+		s := pos >> 8
+		if s >= samples-1 {
+			first[i], second[i] = samples-1, samples-1
+			continue
+		}
+		first[i], second[i], weight[i] = s, s+1, pos&0xFF
+	}
+	return first, second, weight
+}
+
+// boxBlur averages values over 2*radius+1 entries along each line, three
+// channels at a time. There are n lines of length entries; stride is the
+// distance between lines and step the distance between entries on a line,
+// so the same code blurs rows and columns.
+func boxBlur(v []int, n, length, stride, step, radius int) {
+	line := make([]int, length)
+	for l := 0; l < n; l++ {
+		start := l * stride
+		for c := 0; c < 3; c++ {
+			for i := range line {
+				line[i] = v[start+i*step+c]
+			}
+			sum := 0
+			for i := -radius; i <= radius; i++ {
+				if i >= 0 && i < length {
+					sum += line[i]
+				}
+			}
+			for i := 0; i < length; i++ {
+				v[start+i*step+c] = sum / (2*radius + 1)
+				if out := i - radius; out >= 0 {
+					sum -= line[out]
+				}
+				if in := i + radius + 1; in < length {
+					sum += line[in]
+				}
+			}
+		}
+	}
+}
+
+// curveImage bulges the image out from the centre, leaving black where the
+// corners have been pulled in.
+func (c *crt) curveImage(img *image.RGBA) {
+	if c.curve == nil {
+		c.curve = make([]int32, c.w*c.h)
+		for y := 0; y < c.h; y++ {
+			for x := 0; x < c.w; x++ {
+				u, v := centred(x, c.w), centred(y, c.h)
+				su, sv := u*(1+bulge*v*v), v*(1+bulge*u*u)
+				sx := int((su+1)/2*float64(c.w-1) + 0.5)
+				sy := int((sv+1)/2*float64(c.h-1) + 0.5)
+				if sx < 0 || sx >= c.w || sy < 0 || sy >= c.h {
+					c.curve[y*c.w+x] = -1
+				} else {
+					c.curve[y*c.w+x] = int32(sy*img.Stride + sx*4)
+				}
+			}
+		}
+		c.src = make([]uint8, len(img.Pix))
+	}
+
+	copy(c.src, img.Pix)
+	inParallel(c.h, func(from, to int) {
+		for y := from; y < to; y++ {
+			for x := 0; x < c.w; x++ {
+				i := y*img.Stride + x*4
+				j := c.curve[y*c.w+x]
+				if j < 0 {
+					img.Pix[i], img.Pix[i+1], img.Pix[i+2] = 0, 0, 0
+					continue
+				}
+				img.Pix[i], img.Pix[i+1], img.Pix[i+2] = c.src[j], c.src[j+1], c.src[j+2]
+			}
+		}
+	})
+}
+
+func (c *crt) vignette(img *image.RGBA) {
+	if c.shade == nil {
+		c.shade = make([]uint16, c.w*c.h)
+		for y := 0; y < c.h; y++ {
+			v := centred(y, c.h)
+			for x := 0; x < c.w; x++ {
+				u := centred(x, c.w)
+				r := (u*u + v*v) / 2
+				c.shade[y*c.w+x] = uint16((1 - vignetteLevel*r*r) * 256)
+			}
+		}
+	}
+
+	inParallel(c.h, func(from, to int) {
+		for y := from; y < to; y++ {
+			pix := img.Pix[y*img.Stride:]
+			shade := c.shade[y*c.w : (y+1)*c.w]
+			for x, f := range shade {
+				pix[x*4] = uint8(int(pix[x*4]) * int(f) >> 8)
+				pix[x*4+1] = uint8(int(pix[x*4+1]) * int(f) >> 8)
+				pix[x*4+2] = uint8(int(pix[x*4+2]) * int(f) >> 8)
+			}
+		}
+	})
+}
+
+// centred maps 0 to size-1 onto -1 to 1.
+func centred(i, size int) float64 {
+	if size < 2 {
+		return 0
+	}
+	return float64(i)*2/float64(size-1) - 1
+}
@@ -0,0 +1,81 @@
+package ui
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// grey returns a w x h image filled with one shade of grey.
+func grey(w, h int, v uint8) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{v, v, v, 0xFF}), image.Point{}, draw.Src)
+	return img
+}
+
+// Test that effects are parsed from a list and named.
+func TestParseEffects(t *testing.T) {
+	assert := asrt.New(t)
+
+	e, err := ParseEffects("Bloom, scanlines")
+	assert.Nil(err)
+	assert.Equal(Scanlines|Bloom, e)
+	assert.Equal("scanlines,bloom", e.String())
+
+	e, err = ParseEffects("all")
+	assert.Nil(err)
+	assert.Equal(AllEffects, e)
+	e, err = ParseEffects("none")
+	assert.Nil(err)
+	assert.Equal("none", e.String())
+
+	_, err = ParseEffects("scanlines,blur")
+	assert.NotNil(err)
+}
+
+// Test that scanlines darken every other line.
+func TestScanlines(t *testing.T) {
+	assert := asrt.New(t)
+
+	img := grey(4, 4, 200)
+	applyEffects(img, Scanlines, 2)
+	assert.Equal(color.RGBA{200, 200, 200, 0xFF}, img.At(1, 0))
+	assert.Equal(color.RGBA{120, 120, 120, 0xFF}, img.At(1, 1))
+	assert.Equal(color.RGBA{200, 200, 200, 0xFF}, img.At(1, 2))
+}
+
+// Test that bloom spreads light from a lit pixel without dimming it.
+func TestBloom(t *testing.T) {
+	assert := asrt.New(t)
+
+	img := grey(16, 16, 0)
+	draw.Draw(img, image.Rect(6, 6, 10, 10), image.NewUniform(color.White), image.Point{}, draw.Src)
+	applyEffects(img, Bloom, 4)
+
+	assert.Equal(uint8(0xFF), img.RGBAAt(8, 8).R)
+	assert.True(img.RGBAAt(5, 8).R > 0)
+	assert.True(img.RGBAAt(5, 8).R > img.RGBAAt(3, 8).R)
+	assert.Equal(uint8(0), img.RGBAAt(0, 0).R)
+	assert.Equal(img.RGBAAt(5, 8), img.RGBAAt(8, 5))
+}
+
+// Test that curvature keeps the centre and blacks out the corners, and that
+// the vignette darkens the corners more than the centre.
+func TestCurvatureAndVignette(t *testing.T) {
+	assert := asrt.New(t)
+
+	img := grey(65, 33, 200)
+	applyEffects(img, Curvature, 1)
+	assert.Equal(uint8(200), img.RGBAAt(32, 16).R)
+	assert.Equal(uint8(0), img.RGBAAt(0, 0).R)
+	assert.Equal(uint8(200), img.RGBAAt(32, 0).R)
+
+	img = grey(65, 33, 200)
+	applyEffects(img, Vignette, 1)
+	assert.Equal(uint8(200), img.RGBAAt(32, 16).R)
+	assert.True(img.RGBAAt(0, 0).R < img.RGBAAt(16, 8).R)
+	assert.True(img.RGBAAt(16, 8).R < 200)
+}
@@ -0,0 +1,71 @@
+package ui
+
+import (
+	"github.com/cweagans/chip8"
+	"github.com/cweagans/chip8/pkg/phosphor"
+)
+
+// Host runs a machine under a UI with chip8.Run. Escape quits.
+type Host struct {
+	UI UI
+
+	// Audio plays the buzzer. It may be nil.
+	Audio Audio
+
+	// Phosphor blends frames together to hide flicker. It may be nil.
+	Phosphor *phosphor.Filter
+}
+
+// ShadeDrawer is a UI that can draw pixels part way between lit and unlit.
+// UIs that can't are shown the pixels that are at least half lit.
+type ShadeDrawer interface {
+	DrawShades(phosphor.Shades)
+}
+
+func (h Host) Frame(fb chip8.Framebuffer) {
+	if h.Phosphor == nil {
+		h.UI.Draw([32]int64(fb))
+		return
+	}
+	shades := h.Phosphor.Apply([32]int64(fb))
+	if sd, ok := h.UI.(ShadeDrawer); ok {
+		sd.DrawShades(shades)
+	} else {
+		h.UI.Draw(shades.Frame())
+	}
+}
+
+// Animating reports whether the phosphor filter is still fading the display,
+// so that chip8.Run keeps showing frames.
+func (h Host) Animating() bool {
+	return h.Phosphor != nil && !h.Phosphor.Settled()
+}
+
+func (h Host) Sound(on bool) {
+	if h.Audio != nil {
+		h.Audio.Sound(on)
+	}
+}
+
+func (h Host) Input() ([16]bool, bool) {
+	i := h.UI.GetInput()
+	return i.Keys(), i.KeyEsc
+}
+
+// Keys returns the state of the sixteen keys, indexed by key.
+func (i Input) Keys() [16]bool {
+	return [16]bool{
+		i.Key0, i.Key1, i.Key2, i.Key3, i.Key4, i.Key5, i.Key6, i.Key7,
+		i.Key8, i.Key9, i.KeyA, i.KeyB, i.KeyC, i.KeyD, i.KeyE, i.KeyF,
+	}
+}
+
+// NewInput returns an Input with the given keys held down.
+func NewInput(keys [16]bool) Input {
+	return Input{
+		Key0: keys[0x0], Key1: keys[0x1], Key2: keys[0x2], Key3: keys[0x3],
+		Key4: keys[0x4], Key5: keys[0x5], Key6: keys[0x6], Key7: keys[0x7],
+		Key8: keys[0x8], Key9: keys[0x9], KeyA: keys[0xA], KeyB: keys[0xB],
+		KeyC: keys[0xC], KeyD: keys[0xD], KeyE: keys[0xE], KeyF: keys[0xF],
+	}
+}
@@ -0,0 +1,95 @@
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Keymap maps host key names, in lower case, to keys on the hex keypad. Key
+// names are the ones SDL uses, e.g. "q", "space", "up" or "keypad 5".
+type Keymap map[string]uint8
+
+// DefaultKeymap lays the hex keypad out on the left of a QWERTY keyboard:
+//
+//	1 2 3 C      1 2 3 4
+//	4 5 6 D  ->  Q W E R
+//	7 8 9 E      A S D F
+//	A 0 B F      Z X C V
+var DefaultKeymap = Keymap{
+	"1": 0x1, "2": 0x2, "3": 0x3, "4": 0xC,
+	"q": 0x4, "w": 0x5, "e": 0x6, "r": 0xD,
+	"a": 0x7, "s": 0x8, "d": 0x9, "f": 0xE,
+	"z": 0xA, "x": 0x0, "c": 0xB, "v": 0xF,
+}
+
+// Key returns the keypad key for a host key name.
+func (k Keymap) Key(name string) (uint8, bool) {
+	key, ok := k[strings.ToLower(name)]
+	return key, ok
+}
+
+// ParseKeymap reads a keymap. Each line gives a keypad key (0-F) and then
+// the name of the host key that presses it:
+//
+//	# keypad key, host key
+//	5 Up
+//	8 Down
+//	0 Keypad 0
+//
+// A keypad key can be given more than once. Names aren't case sensitive.
+// Blank lines and lines starting with # are ignored.
+func ParseKeymap(r io.Reader) (Keymap, error) {
+	k := Keymap{}
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// The name is the rest of the line, as it may contain spaces.
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected KEY NAME", n)
+		}
+		key, err := strconv.ParseUint(line[:i], 16, 8)
+		if err != nil || key > 0xF {
+			return nil, fmt.Errorf("line %d: invalid key %q", n, line[:i])
+		}
+		k[strings.ToLower(strings.TrimSpace(line[i:]))] = uint8(key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(k) == 0 {
+		return nil, fmt.Errorf("keymap is empty")
+	}
+	return k, nil
+}
+
+// LoadKeymap reads a keymap from a file.
+func LoadKeymap(filename string) (Keymap, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseKeymap(f)
+}
+
+// SetKeymap changes the keymap of UIs that read the keyboard, and leaves
+// others alone.
+func SetKeymap(u UI, k Keymap) {
+	switch u := u.(type) {
+	case *Sdl:
+		u.Keymap = k
+	case *Termbox:
+		u.Keymap = k
+	}
+}
@@ -0,0 +1,49 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that the default keymap covers the whole keypad.
+func TestDefaultKeymap(t *testing.T) {
+	assert := asrt.New(t)
+
+	var seen [16]bool
+	for _, key := range DefaultKeymap {
+		seen[key] = true
+	}
+	for key, ok := range seen {
+		assert.True(ok, "key %X isn't mapped", key)
+	}
+
+	key, ok := DefaultKeymap.Key("Q")
+	assert.True(ok)
+	assert.Equal(uint8(0x4), key)
+}
+
+// Test that keymaps are parsed, with names that contain spaces.
+func TestParseKeymap(t *testing.T) {
+	assert := asrt.New(t)
+
+	k, err := ParseKeymap(strings.NewReader("# arrows\n5 Up\n8\tDown\n\n0 Keypad 0\nf =\n5 W\n"))
+	assert.Nil(err)
+	assert.Equal(Keymap{"up": 0x5, "down": 0x8, "keypad 0": 0x0, "=": 0xF, "w": 0x5}, k)
+
+	for _, bad := range []string{"", "# nothing\n", "5\n", "G up\n", "10 up\n"} {
+		_, err := ParseKeymap(strings.NewReader(bad))
+		assert.NotNil(err, bad)
+	}
+}
+
+// Test that NewInput and Keys are inverses.
+func TestInputKeys(t *testing.T) {
+	assert := asrt.New(t)
+
+	keys := [16]bool{0x0: true, 0x7: true, 0xF: true}
+	i := NewInput(keys)
+	assert.True(i.Key7)
+	assert.Equal(keys, i.Keys())
+}
@@ -0,0 +1,98 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scaling is how the display is fitted into a window.
+type Scaling int
+
+const (
+	// ScaleInteger scales pixels by a whole number, so they're all the same
+	// size.
+	ScaleInteger Scaling = iota
+	// ScaleFit fills as much of the window as it can.
+	ScaleFit
+)
+
+var scalingNames = []string{"integer", "fit"}
+
+func (s Scaling) String() string {
+	if int(s) < len(scalingNames) {
+		return scalingNames[s]
+	}
+	return "unknown"
+}
+
+// ParseScaling returns the scaling with the given name.
+func ParseScaling(name string) (Scaling, error) {
+	for s, n := range scalingNames {
+		if strings.EqualFold(name, n) {
+			return Scaling(s), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown scaling %q", name)
+}
+
+// VideoOptions control how the SDL UI draws the display.
+type VideoOptions struct {
+	Scaling Scaling
+	// Grid draws lines between pixels, when they're big enough to leave room.
+	Grid       bool
+	Fullscreen bool
+	Effects    Effects
+}
+
+// layout is where the display goes in a window: centred, with the same
+// aspect ratio, and letterboxed.
+type layout struct {
+	x, y, w, h int
+	cols, rows int
+}
+
+// minGridScale is the smallest pixel size that gets grid lines.
+const minGridScale = 4
+
+// newLayout fits a display of cols x rows pixels into a window.
+func newLayout(winW, winH, cols, rows int, s Scaling) layout {
+	l := layout{cols: cols, rows: rows}
+	if cols <= 0 || rows <= 0 || winW <= 0 || winH <= 0 {
+		return l
+	}
+
+	if s == ScaleFit {
+		// Use the whole width if the window is relatively taller than the
+		// display, and the whole height otherwise.
+		if winW*rows <= winH*cols {
+			l.w, l.h = winW, winW*rows/cols
+		} else {
+			l.w, l.h = winH*cols/rows, winH
+		}
+	} else {
+		scale := winW / cols
+		if winH/rows < scale {
+			scale = winH / rows
+		}
+		if scale < 1 {
+			scale = 1
+		}
+		l.w, l.h = cols*scale, rows*scale
+	}
+
+	l.x, l.y = (winW-l.w)/2, (winH-l.h)/2
+	return l
+}
+
+// pixel returns the rectangle covered by a pixel. Edges are rounded so that
+// neighbouring pixels meet without gaps, even when the scale isn't whole.
+func (l layout) pixel(col, row int) (x, y, w, h int) {
+	x0, x1 := l.x+col*l.w/l.cols, l.x+(col+1)*l.w/l.cols
+	y0, y1 := l.y+row*l.h/l.rows, l.y+(row+1)*l.h/l.rows
+	return x0, y0, x1 - x0, y1 - y0
+}
+
+// grid reports whether pixels are big enough for grid lines.
+func (l layout) grid() bool {
+	return l.cols > 0 && l.w/l.cols >= minGridScale && l.h/l.rows >= minGridScale
+}
@@ -0,0 +1,61 @@
+package ui
+
+import (
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that integer scaling uses the largest whole scale that fits, and
+// letterboxes the rest.
+func TestLayoutInteger(t *testing.T) {
+	assert := asrt.New(t)
+
+	l := newLayout(800, 600, 64, 32, ScaleInteger)
+	assert.Equal(layout{x: 16, y: 108, w: 768, h: 384, cols: 64, rows: 32}, l)
+	x, y, w, h := l.pixel(1, 2)
+	assert.Equal([]int{28, 132, 12, 12}, []int{x, y, w, h})
+	assert.True(l.grid())
+
+	// Windows smaller than the display still draw it, cropped.
+	l = newLayout(32, 16, 64, 32, ScaleInteger)
+	assert.Equal(layout{x: -16, y: -8, w: 64, h: 32, cols: 64, rows: 32}, l)
+	assert.False(l.grid())
+}
+
+// Test that fit scaling fills one side of the window and keeps the aspect
+// ratio, with pixels that meet without gaps.
+func TestLayoutFit(t *testing.T) {
+	assert := asrt.New(t)
+
+	l := newLayout(1000, 1000, 64, 32, ScaleFit)
+	assert.Equal(layout{x: 0, y: 250, w: 1000, h: 500, cols: 64, rows: 32}, l)
+	l = newLayout(1000, 300, 64, 32, ScaleFit)
+	assert.Equal(layout{x: 200, y: 0, w: 600, h: 300, cols: 64, rows: 32}, l)
+
+	l = newLayout(1000, 1000, 64, 32, ScaleFit)
+	right := l.x
+	for col := 0; col < 64; col++ {
+		x, _, w, _ := l.pixel(col, 0)
+		assert.Equal(right, x)
+		right = x + w
+	}
+	assert.Equal(l.x+l.w, right)
+
+	// The layout follows the display's resolution.
+	l = newLayout(1000, 1000, 128, 64, ScaleFit)
+	_, _, w, h := l.pixel(0, 0)
+	assert.Equal([]int{7, 7}, []int{w, h})
+}
+
+// Test that scalings are found by name.
+func TestParseScaling(t *testing.T) {
+	assert := asrt.New(t)
+
+	s, err := ParseScaling("Fit")
+	assert.Nil(err)
+	assert.Equal(ScaleFit, s)
+	assert.Equal("integer", ScaleInteger.String())
+	_, err = ParseScaling("stretch")
+	assert.NotNil(err)
+}
@@ -0,0 +1,239 @@
+package ui
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/phosphor"
+)
+
+// Palette is the set of colours the display is drawn with. Colours 0 and 1
+// are the background and foreground. XO-CHIP's second bit plane needs two
+// more: 2 for pixels lit only in the second plane, and 3 for pixels lit in
+// both.
+type Palette struct {
+	Name   string
+	Colors [4]color.RGBA
+}
+
+// Background returns the colour of unlit pixels.
+func (p Palette) Background() color.RGBA {
+	return p.Colors[0]
+}
+
+// Foreground returns the colour of lit pixels.
+func (p Palette) Foreground() color.RGBA {
+	return p.Colors[1]
+}
+
+// Shade returns the colour of a pixel with the given brightness, from the
+// background at 0 to the foreground at phosphor.Lit.
+func (p Palette) Shade(v uint8) color.RGBA {
+	bg, fg := p.Background(), p.Foreground()
+	mix := func(b, f uint8) uint8 {
+		return uint8((int(b)*(phosphor.Lit-int(v)) + int(f)*int(v)) / phosphor.Lit)
+	}
+	return color.RGBA{R: mix(bg.R, fg.R), G: mix(bg.G, fg.G), B: mix(bg.B, fg.B), A: 0xFF}
+}
+
+// ColorPalette returns the palette's colours for use in images.
+func (p Palette) ColorPalette() color.Palette {
+	var cp color.Palette
+	for _, c := range p.Colors {
+		cp = append(cp, c)
+	}
+	return cp
+}
+
+func rgb(v uint32) color.RGBA {
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xFF}
+}
+
+// Palettes are the built-in themes, by name.
+var Palettes = map[string]Palette{
+	"classic": {"classic", [4]color.RGBA{
+		rgb(0x000000), rgb(0xFFFFFF), rgb(0xAAAAAA), rgb(0x555555),
+	}},
+	"amber": {"amber", [4]color.RGBA{
+		rgb(0x1A0F00), rgb(0xFFB000), rgb(0xCC7A00), rgb(0x7A4A00),
+	}},
+	"green": {"green", [4]color.RGBA{
+		rgb(0x001400), rgb(0x33FF33), rgb(0x1FAA1F), rgb(0x0F550F),
+	}},
+	"gameboy": {"gameboy", [4]color.RGBA{
+		rgb(0x9BBC0F), rgb(0x0F380F), rgb(0x306230), rgb(0x8BAC0F),
+	}},
+	"high-contrast": {"high-contrast", [4]color.RGBA{
+		rgb(0x000000), rgb(0xFFFF00), rgb(0x00FFFF), rgb(0xFF00FF),
+	}},
+}
+
+// DefaultPalette is white on black.
+var DefaultPalette = Palettes["classic"]
+
+// PaletteNames returns the names of the built-in themes, sorted.
+func PaletteNames() []string {
+	var names []string
+	for name := range Palettes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// paletteSlots are the names of the colours in a palette file.
+var paletteSlots = map[string]int{
+	"background": 0,
+	"foreground": 1,
+	"plane2":     2,
+	"both":       3,
+}
+
+// ParsePalette reads a palette. Each line names a colour and gives it in
+// hex:
+//
+//	# name color
+//	background #1A0F00
+//	foreground #FFB000
+//	plane2     #CC7A00
+//	both       #7A4A00
+//
+// The background and foreground are required. The XO-CHIP colours default
+// to blends of the two. Blank lines and lines starting with # are ignored.
+func ParsePalette(r io.Reader) (Palette, error) {
+	var p Palette
+	var seen [4]bool
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return Palette{}, fmt.Errorf("line %d: expected NAME COLOR", n)
+		}
+		slot, ok := paletteSlots[strings.ToLower(fields[0])]
+		if !ok {
+			return Palette{}, fmt.Errorf("line %d: unknown color name %q", n, fields[0])
+		}
+		hex := strings.TrimPrefix(fields[1], "#")
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return Palette{}, fmt.Errorf("line %d: invalid color %q", n, fields[1])
+		}
+		p.Colors[slot] = rgb(uint32(v))
+		seen[slot] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return Palette{}, err
+	}
+	if !seen[0] || !seen[1] {
+		return Palette{}, fmt.Errorf("palette needs a background and a foreground")
+	}
+
+	if !seen[2] {
+		p.Colors[2] = blend(p.Colors[0], p.Colors[1], 2)
+	}
+	if !seen[3] {
+		p.Colors[3] = blend(p.Colors[0], p.Colors[1], 1)
+	}
+	return p, nil
+}
+
+// blend mixes two colours, returning the colour the given number of thirds
+// of the way from a to b.
+func blend(a, b color.RGBA, thirds int) color.RGBA {
+	mix := func(x, y uint8) uint8 {
+		return uint8((int(x)*(3-thirds) + int(y)*thirds) / 3)
+	}
+	return color.RGBA{R: mix(a.R, b.R), G: mix(a.G, b.G), B: mix(a.B, b.B), A: 0xFF}
+}
+
+// LoadPalette reads a palette from a file.
+func LoadPalette(filename string) (Palette, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Palette{}, err
+	}
+	defer f.Close()
+	p, err := ParsePalette(f)
+	if err != nil {
+		return Palette{}, err
+	}
+	p.Name = filename
+	return p, nil
+}
+
+// FindPalette returns the built-in theme with the given name, or else reads
+// the palette file it names.
+func FindPalette(name string) (Palette, error) {
+	if p, ok := Palettes[name]; ok {
+		return p, nil
+	}
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return Palette{}, fmt.Errorf("%q isn't a palette file or one of %s", name, strings.Join(PaletteNames(), ", "))
+	}
+	return LoadPalette(name)
+}
+
+// SetPalette changes the palette of UIs that draw in colour, and leaves
+// others alone.
+func SetPalette(u UI, p Palette) {
+	switch u := u.(type) {
+	case *Sdl:
+		u.Palette = p
+	case *Termbox:
+		u.Palette = p
+	}
+}
+
+// xterm256 returns the closest colour to c among the 240 colours of the
+// xterm 256 colour cube and grey ramp.
+func xterm256(c color.RGBA) int {
+	// The cube's levels on each axis.
+	levels := [6]int{0x00, 0x5F, 0x87, 0xAF, 0xD7, 0xFF}
+	nearestLevel := func(v uint8) int {
+		best := 0
+		for i, l := range levels {
+			if abs(int(v)-l) < abs(int(v)-levels[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	r, g, b := nearestLevel(c.R), nearestLevel(c.G), nearestLevel(c.B)
+	best := 16 + 36*r + 6*g + b
+	bestDist := distance(c, levels[r], levels[g], levels[b])
+
+	// The grey ramp runs from 0x08 to 0xEE in steps of 10.
+	for i := 0; i < 24; i++ {
+		v := 8 + 10*i
+		if d := distance(c, v, v, v); d < bestDist {
+			best, bestDist = 232+i, d
+		}
+	}
+	return best
+}
+
+func distance(c color.RGBA, r, g, b int) int {
+	dr, dg, db := int(c.R)-r, int(c.G)-g, int(c.B)-b
+	return dr*dr + dg*dg + db*db
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
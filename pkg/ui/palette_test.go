@@ -0,0 +1,74 @@
+package ui
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that the built-in themes are all there and named after their keys.
+func TestPalettes(t *testing.T) {
+	assert := asrt.New(t)
+
+	assert.Equal([]string{"amber", "classic", "gameboy", "green", "high-contrast"}, PaletteNames())
+	for name, p := range Palettes {
+		assert.Equal(name, p.Name)
+		assert.NotEqual(p.Background(), p.Foreground(), name)
+	}
+	assert.Equal(color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, DefaultPalette.Foreground())
+	assert.Len(DefaultPalette.ColorPalette(), 4)
+}
+
+// Test that palette files are parsed, and that the XO-CHIP colours default
+// to blends of the background and foreground.
+func TestParsePalette(t *testing.T) {
+	assert := asrt.New(t)
+
+	p, err := ParsePalette(strings.NewReader("# amber-ish\nbackground #000000\nForeground FF9900\n"))
+	assert.Nil(err)
+	assert.Equal(color.RGBA{0x00, 0x00, 0x00, 0xFF}, p.Colors[0])
+	assert.Equal(color.RGBA{0xFF, 0x99, 0x00, 0xFF}, p.Colors[1])
+	assert.Equal(color.RGBA{0xAA, 0x66, 0x00, 0xFF}, p.Colors[2])
+	assert.Equal(color.RGBA{0x55, 0x33, 0x00, 0xFF}, p.Colors[3])
+
+	p, err = ParsePalette(strings.NewReader("background #000000\nforeground #FFFFFF\nplane2 #FF0000\nboth #00FF00\n"))
+	assert.Nil(err)
+	assert.Equal(color.RGBA{0xFF, 0x00, 0x00, 0xFF}, p.Colors[2])
+
+	for _, bad := range []string{
+		"background #000000\n",
+		"background #000000\nforeground #FFF\n",
+		"background #000000\nforeground #GGGGGG\n",
+		"middle #000000\n",
+		"background\n",
+	} {
+		_, err := ParsePalette(strings.NewReader(bad))
+		assert.NotNil(err, bad)
+	}
+}
+
+// Test that built-in themes are found by name, and other names must be
+// files.
+func TestFindPalette(t *testing.T) {
+	assert := asrt.New(t)
+
+	p, err := FindPalette("amber")
+	assert.Nil(err)
+	assert.Equal("amber", p.Name)
+
+	_, err = FindPalette("no-such-theme")
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "gameboy")
+}
+
+// Test that colours map to the closest of the terminal's 256.
+func TestXterm256(t *testing.T) {
+	assert := asrt.New(t)
+
+	assert.Equal(16, xterm256(color.RGBA{0, 0, 0, 0xFF}))
+	assert.Equal(231, xterm256(color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}))
+	assert.Equal(196, xterm256(color.RGBA{0xFF, 0, 0, 0xFF}))
+	assert.Equal(244, xterm256(color.RGBA{0x80, 0x80, 0x80, 0xFF}))
+}
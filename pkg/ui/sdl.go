@@ -1,24 +1,67 @@
 package ui
 
 import (
-	"math"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
 
+	"github.com/cweagans/chip8/pkg/phosphor"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
-// Sdl will draw emulator output in a separate GUI window with SDL.
+// Sdl will draw emulator output in a separate, resizable GUI window with
+// SDL. F11 or Alt+Enter toggles fullscreen, and F5 to F8 toggle the
+// scanline, bloom, curvature and vignette effects.
 type Sdl struct {
-	Window *sdl.Window
+	Window   *sdl.Window
+	Renderer *sdl.Renderer
+
+	// Keymap maps keyboard keys to the hex keypad. Init sets it to
+	// DefaultKeymap if it's nil.
+	Keymap Keymap
+
+	// Palette is the colours the display is drawn in. Init sets it to
+	// DefaultPalette if it isn't set.
+	Palette Palette
+
+	// Video sets the scaling, pixel grid and effects. Use SetVideo to change
+	// it, so that fullscreen is applied.
+	Video VideoOptions
+
+	// held is the set of mapped keys that are down, by key name, and quit is
+	// set once the window has been closed or Escape pressed.
+	held map[string]bool
+	quit bool
+
+	// shades is the last frame drawn, kept to redraw the window when it's
+	// resized.
+	shades phosphor.Shades
+
+	// canvas is where the display is drawn when there are effects, before
+	// it's copied to texture and then the window.
+	canvas  *image.RGBA
+	texture *sdl.Texture
+	crt     *crt
 }
 
 func (s *Sdl) Init() {
+	if s.Keymap == nil {
+		s.Keymap = DefaultKeymap
+	}
+	if s.Palette.Name == "" {
+		s.Palette = DefaultPalette
+	}
+	s.held = map[string]bool{}
+
 	// Initialize SDL
 	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
 		panic(err)
 	}
 
-	// Create an SDL window.
-	window, err := sdl.CreateWindow("Chip8", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, 512, 256, sdl.WINDOW_SHOWN)
+	// Create an SDL window, 8x the size of the display to start with so that
+	// we will be able to see it on modern displays.
+	window, err := sdl.CreateWindow("Chip8", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, 64*8, 32*8, sdl.WINDOW_SHOWN|sdl.WINDOW_RESIZABLE)
 	if err != nil {
 		panic(err)
 	}
@@ -26,59 +69,234 @@ func (s *Sdl) Init() {
 	// Save the window handle for later.
 	s.Window = window
 
-	// Delay a bit to make sure that the window is ready for drawing before we
-	// start drawing.
-	sdl.Delay(100)
-
-	// Get the window surface,
-	surface, err := window.GetSurface()
+	// Draw with the GPU if there is one, and in software if not.
+	renderer, err := sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED)
 	if err != nil {
-		panic(err)
+		renderer, err = sdl.CreateRenderer(window, -1, 0)
+		if err != nil {
+			panic(err)
+		}
 	}
+	s.Renderer = renderer
+
+	s.render()
+}
+
+func (s *Sdl) Draw(buf [32]int64) {
+	s.DrawShades(phosphor.FromFrame(buf))
+}
 
-	// fill it black, and update the surface.
-	surface.FillRect(nil, 0)
-	window.UpdateSurface()
+// DrawShades draws pixels in between the background and foreground colours
+// for ones that are fading.
+func (s *Sdl) DrawShades(shades phosphor.Shades) {
+	s.shades = shades
+	s.render()
 }
 
-func (s Sdl) Draw(buf [32]int64) {
-	surface, err := s.Window.GetSurface()
+// render draws the last frame to fit the window as it is now.
+func (s *Sdl) render() {
+	w, h, err := s.Renderer.GetOutputSize()
 	if err != nil {
 		// @TODO: Maybe there's something better than skipping a frame?
 		return
 	}
 
-	pixels := ConvertVramToBools(buf)
+	// The display only comes in one size for now, but the layout follows the
+	// frame, so other resolutions would fit the window the same way.
+	l := newLayout(int(w), int(h), 64, 32, s.Video.Scaling)
+
+	// Letterbox in black.
+	s.Renderer.SetDrawColor(0, 0, 0, 0xFF)
+	s.Renderer.Clear()
+
+	if s.Video.Effects != 0 {
+		s.renderEffects(l)
+	} else {
+		for _, f := range s.fills(l) {
+			s.Renderer.SetDrawColor(f.color.R, f.color.G, f.color.B, 0xFF)
+			s.Renderer.FillRects(f.rects)
+		}
+	}
+
+	s.Renderer.Present()
+}
+
+// renderEffects draws the display into an image, applies the effects to it
+// and copies it to the window.
+func (s *Sdl) renderEffects(l layout) {
+	if l.w <= 0 || l.h <= 0 {
+		return
+	}
+
+	// Draw with the display at the image's origin, and put it in place when
+	// it's copied to the window.
+	at := l
+	at.x, at.y = 0, 0
+	if s.canvas == nil || s.canvas.Bounds().Dx() != l.w || s.canvas.Bounds().Dy() != l.h {
+		if s.texture != nil {
+			s.texture.Destroy()
+		}
+		texture, err := s.Renderer.CreateTexture(sdl.PIXELFORMAT_ABGR8888, sdl.TEXTUREACCESS_STREAMING, int32(l.w), int32(l.h))
+		if err != nil {
+			s.texture, s.canvas = nil, nil
+			return
+		}
+		s.texture = texture
+		s.canvas = image.NewRGBA(image.Rect(0, 0, l.w, l.h))
+		s.crt = newCRT(l.w, l.h)
+	}
+
+	for _, f := range s.fills(at) {
+		src := image.NewUniform(f.color)
+		for _, r := range f.rects {
+			rect := image.Rect(int(r.X), int(r.Y), int(r.X+r.W), int(r.Y+r.H))
+			draw.Draw(s.canvas, rect, src, image.Point{}, draw.Src)
+		}
+	}
+	s.crt.apply(s.canvas, s.Video.Effects, l.w/l.cols)
 
-	surface.FillRect(nil, 0)
+	s.texture.Update(nil, s.canvas.Pix, s.canvas.Stride)
+	s.Renderer.Copy(s.texture, nil, &sdl.Rect{X: int32(l.x), Y: int32(l.y), W: int32(l.w), H: int32(l.h)})
+}
 
-	for i := 0; i < 64*32; i++ {
-		if pixels[i] {
-			xrootpos := (i % 64)
-			yrootpos := math.Floor(float64((i - (i % 64)) / 64))
+// fill is a set of rectangles drawn in one colour.
+type fill struct {
+	color color.RGBA
+	rects []sdl.Rect
+}
 
-			// Everything is 8x so that we will be able to see it on modern displays.
-			yrootpos = yrootpos * 8
-			xrootpos = xrootpos * 8
+// fills returns what to draw for the display, in order: the background, the
+// lit pixels batched by brightness, and the grid.
+func (s *Sdl) fills(l layout) []fill {
+	fills := []fill{{
+		color: s.Palette.Background(),
+		rects: []sdl.Rect{{X: int32(l.x), Y: int32(l.y), W: int32(l.w), H: int32(l.h)}},
+	}}
 
-			rect := sdl.Rect{
-				X: int32(xrootpos),
-				Y: int32(yrootpos),
-				H: 8,
-				W: 8,
+	lit := map[uint8][]sdl.Rect{}
+	for row := 0; row < 32; row++ {
+		for col := 0; col < 64; col++ {
+			if v := s.shades[row][col]; v > 0 {
+				x, y, pw, ph := l.pixel(col, row)
+				lit[v] = append(lit[v], sdl.Rect{X: int32(x), Y: int32(y), W: int32(pw), H: int32(ph)})
 			}
-			surface.FillRect(&rect, 0xffffffff)
 		}
 	}
+	for v, rects := range lit {
+		fills = append(fills, fill{s.Palette.Shade(v), rects})
+	}
 
-	s.Window.UpdateSurface()
+	if s.Video.Grid && l.grid() {
+		// Lines along the top and left of each pixel, a little lighter
+		// than the background.
+		var lines []sdl.Rect
+		for col := 1; col < 64; col++ {
+			x, _, _, _ := l.pixel(col, 0)
+			lines = append(lines, sdl.Rect{X: int32(x), Y: int32(l.y), W: 1, H: int32(l.h)})
+		}
+		for row := 1; row < 32; row++ {
+			_, y, _, _ := l.pixel(0, row)
+			lines = append(lines, sdl.Rect{X: int32(l.x), Y: int32(y), W: int32(l.w), H: 1})
+		}
+		fills = append(fills, fill{gridColor(s.Palette), lines})
+	}
+	return fills
 }
 
-func (s Sdl) GetInput() Input {
-	return Input{}
+// gridColor is an eighth of the way from the background to the foreground.
+func gridColor(p Palette) color.RGBA {
+	bg, fg := p.Background(), p.Foreground()
+	mix := func(b, f uint8) uint8 {
+		return uint8((int(b)*7 + int(f)) / 8)
+	}
+	return color.RGBA{R: mix(bg.R, fg.R), G: mix(bg.G, fg.G), B: mix(bg.B, fg.B), A: 0xFF}
 }
 
-func (s Sdl) Shutdown() {
-	sdl.Quit()
+// SetFullscreen switches between a window and the whole screen.
+func (s *Sdl) SetFullscreen(on bool) {
+	var flags uint32
+	if on {
+		flags = sdl.WINDOW_FULLSCREEN_DESKTOP
+	}
+	s.Window.SetFullscreen(flags)
+	s.Video.Fullscreen = on
+}
+
+// effectKeys are the keys that turn each effect on and off.
+var effectKeys = map[sdl.Keycode]Effects{
+	sdl.K_F5: Scanlines,
+	sdl.K_F6: Bloom,
+	sdl.K_F7: Curvature,
+	sdl.K_F8: Vignette,
+}
+
+// GetInput handles the events SDL has queued up since the last call and
+// returns the keys that are held down. It must be called from the main
+// thread.
+func (s *Sdl) GetInput() Input {
+	for event := sdl.PollEvent(); event != nil; event = sdl.PollEvent() {
+		switch e := event.(type) {
+		case *sdl.QuitEvent:
+			s.quit = true
+
+		case *sdl.WindowEvent:
+			if e.Event == sdl.WINDOWEVENT_SIZE_CHANGED {
+				s.render()
+			}
+
+		case *sdl.KeyboardEvent:
+			if e.Keysym.Sym == sdl.K_ESCAPE {
+				s.quit = true
+				continue
+			}
+			if e.Type == sdl.KEYDOWN && e.Repeat == 0 && (e.Keysym.Sym == sdl.K_F11 ||
+				e.Keysym.Sym == sdl.K_RETURN && e.Keysym.Mod&sdl.KMOD_ALT != 0) {
+				s.SetFullscreen(!s.Video.Fullscreen)
+				continue
+			}
+			if effect, ok := effectKeys[e.Keysym.Sym]; ok {
+				if e.Type == sdl.KEYDOWN && e.Repeat == 0 {
+					s.Video.Effects ^= effect
+					s.render()
+				}
+				continue
+			}
+			name := strings.ToLower(sdl.GetKeyName(e.Keysym.Sym))
+			if _, ok := s.Keymap[name]; !ok {
+				continue
+			}
+			if e.Type == sdl.KEYDOWN {
+				s.held[name] = true
+			} else if e.Type == sdl.KEYUP {
+				delete(s.held, name)
+			}
+		}
+	}
+
+	var keys [16]bool
+	for name := range s.held {
+		keys[s.Keymap[name]] = true
+	}
+	i := NewInput(keys)
+	i.KeyEsc = s.quit
+	return i
+}
+
+func (s *Sdl) Shutdown() {
+	if s.texture != nil {
+		s.texture.Destroy()
+	}
+	s.Renderer.Destroy()
 	s.Window.Destroy()
+	sdl.Quit()
+}
+
+// SetVideo changes the video options of the SDL UI, and leaves other UIs
+// alone.
+func SetVideo(u UI, v VideoOptions) {
+	if s, ok := u.(*Sdl); ok {
+		s.Video = v
+		s.SetFullscreen(v.Fullscreen)
+		s.render()
+	}
 }
@@ -0,0 +1,108 @@
+package ui
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// SdlAudio plays the buzzer through SDL's audio queue. It works with any UI,
+// not just Sdl.
+type SdlAudio struct {
+	device sdl.AudioDeviceID
+	done   chan struct{}
+	wg     sync.WaitGroup
+
+	// mu guards synth, which is shared with the goroutine feeding the queue.
+	mu    sync.Mutex
+	synth *Synth
+}
+
+// sdlAudioLatency is how much audio is kept queued up. More makes the buzzer
+// slower to respond, less risks gaps if the feeding goroutine is held up.
+const sdlAudioLatency = 40 * time.Millisecond
+
+// NewSdlAudio opens the default audio device and starts feeding it.
+func NewSdlAudio(t Tone) (*SdlAudio, error) {
+	if err := sdl.InitSubSystem(sdl.INIT_AUDIO); err != nil {
+		return nil, err
+	}
+
+	want := sdl.AudioSpec{Freq: 44100, Format: sdl.AUDIO_S16LSB, Channels: 1, Samples: 512}
+	var got sdl.AudioSpec
+	device, err := sdl.OpenAudioDevice("", false, &want, &got, 0)
+	if err != nil {
+		sdl.QuitSubSystem(sdl.INIT_AUDIO)
+		return nil, err
+	}
+
+	a := &SdlAudio{
+		device: device,
+		done:   make(chan struct{}),
+		synth:  NewSynth(t, int(got.Freq)),
+	}
+	sdl.PauseAudioDevice(device, false)
+
+	a.wg.Add(1)
+	go a.feed()
+	return a, nil
+}
+
+// feed keeps the device's queue topped up with samples from the synth,
+// silent or not, so that the tone can start and stop smoothly.
+func (a *SdlAudio) feed() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(sdlAudioLatency / 4)
+	defer ticker.Stop()
+
+	target := int(sdlAudioLatency.Seconds() * float64(a.synth.Rate))
+	samples := make([]int16, target)
+	buf := make([]byte, 2*target)
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+
+		queued := int(sdl.GetQueuedAudioSize(a.device) / 2)
+		if queued >= target {
+			continue
+		}
+		n := target - queued
+
+		a.mu.Lock()
+		a.synth.Fill(samples[:n])
+		a.mu.Unlock()
+
+		for i, s := range samples[:n] {
+			binary.LittleEndian.PutUint16(buf[2*i:], uint16(s))
+		}
+		sdl.QueueAudio(a.device, buf[:2*n])
+	}
+}
+
+func (a *SdlAudio) Sound(on bool) {
+	a.mu.Lock()
+	a.synth.SetOn(on)
+	a.mu.Unlock()
+}
+
+func (a *SdlAudio) Close() {
+	// Let a tone that's still playing fade out, rather than cutting it off.
+	a.mu.Lock()
+	a.synth.SetOn(false)
+	playing := a.synth.gain > 0
+	a.mu.Unlock()
+	if playing {
+		time.Sleep(sdlAudioLatency + RampTime)
+	}
+
+	close(a.done)
+	a.wg.Wait()
+	sdl.CloseAudioDevice(a.device)
+	sdl.QuitSubSystem(sdl.INIT_AUDIO)
+}
@@ -1,24 +1,68 @@
 package ui
 
 import (
-	"math"
+	"image/color"
+	"time"
+	"unicode"
 
+	"github.com/cweagans/chip8/pkg/phosphor"
 	termbox "github.com/nsf/termbox-go"
 )
 
-// Termbox will eventually use termbox-go to draw emulator output in a terminal window.
+// DefaultKeyHold is how long a key stays down after the terminal reports a
+// press. Terminals don't report releases, so a key held down shows up as a
+// press followed by a stream of repeats once the terminal's repeat delay has
+// passed.
+const DefaultKeyHold = 150 * time.Millisecond
+
+// Termbox draws emulator output in a terminal window with termbox-go, inside
+// a border. Pixels are drawn as blocks or Braille dots to fit the terminal.
 type Termbox struct {
 	events chan termbox.Event
+
+	// Keymap maps keyboard keys to the hex keypad. Init sets it to
+	// DefaultKeymap if it's nil.
+	Keymap Keymap
+
+	// Palette is the colours the display is drawn in, as near as the
+	// terminal's 256 colours allow. Init sets it to DefaultPalette if it
+	// isn't set.
+	Palette Palette
+
+	// TextMode is how pixels are drawn. The zero value, TextAuto, picks the
+	// roomiest mode that fits the terminal each time the display is drawn.
+	TextMode TextMode
+
+	// KeyHold is how long each key press lasts. Init sets it to
+	// DefaultKeyHold if it's zero.
+	KeyHold time.Duration
+
+	keys   keyTimer
+	quit   bool
+	shades phosphor.Shades
+	flash  bool
 }
 
-func (t Termbox) Init() {
+func (t *Termbox) Init() {
 	err := termbox.Init()
 	if err != nil {
 		panic(err.Error())
 	}
 	termbox.SetInputMode(termbox.InputEsc)
+	termbox.SetOutputMode(termbox.Output256)
 
-	t.events = make(chan termbox.Event)
+	if t.Keymap == nil {
+		t.Keymap = DefaultKeymap
+	}
+	if t.Palette.Name == "" {
+		t.Palette = DefaultPalette
+	}
+	if t.KeyHold == 0 {
+		t.KeyHold = DefaultKeyHold
+	}
+
+	// GetInput only reads what's buffered, so that it never blocks.
+	t.events = make(chan termbox.Event, 64)
 	go func() {
 		for {
 			t.events <- termbox.PollEvent()
@@ -26,54 +70,157 @@ func (t Termbox) Init() {
 	}()
 }
 
-func (t Termbox) Draw(buf [32]int64) {
+func (t *Termbox) Draw(buf [32]int64) {
+	t.DrawShades(phosphor.FromFrame(buf))
+}
+
+// DrawShades draws pixels in between the background and foreground colours
+// for ones that are fading.
+func (t *Termbox) DrawShades(s phosphor.Shades) {
+	t.shades = s
+
 	termbox.Clear(termbox.ColorDefault, termbox.ColorDefault)
 	defer termbox.Flush()
 
-	pixels := ConvertVramToBools(buf)
-
-	for i := 0; i < 64*32; i++ {
-		if pixels[i] {
-			xrootpos := (i % 64)
-			yrootpos := math.Floor(float64((i - (i % 64)) / 64))
+	mode := t.TextMode
+	if mode == TextAuto {
+		w, h := termbox.Size()
+		mode = chooseTextMode(w, h, phosphor.Width, phosphor.Height)
+	}
 
-			xrootpos = xrootpos * 2
+	cells := textCells(s, mode, t.Palette)
+	t.drawBorder(len(cells[0])+1, len(cells)+1)
 
-			termbox.SetCell(int(xrootpos), int(yrootpos), ' ', termbox.ColorDefault, termbox.ColorWhite)
-			termbox.SetCell(int(xrootpos)+1, int(yrootpos), ' ', termbox.ColorDefault, termbox.ColorWhite)
+	for y, row := range cells {
+		for x, c := range row {
+			// Leave room for the border.
+			termbox.SetCell(x+1, y+1, c.ch, termboxColor(c.fg), termboxColor(c.bg))
 		}
 	}
 }
 
-func (t Termbox) GetInput() Input {
-	var curEvent termbox.Event
-
-	select {
-	case e, ok := <-t.events:
-		curEvent = e
-		if !ok {
-			return Input{}
-		}
+// drawBorder draws a box around the display, with its right edge in the
+// given column and its bottom edge in the given row, filled in while the
+// visual bell is flashing.
+func (t *Termbox) drawBorder(right, bottom int) {
+	fg, bg := termbox.ColorDefault, termbox.ColorDefault
+	if t.flash {
+		fg, bg = termbox.ColorYellow, termbox.ColorYellow
 	}
 
-	i := Input{}
+	for x := 1; x < right; x++ {
+		termbox.SetCell(x, 0, '─', fg, bg)
+		termbox.SetCell(x, bottom, '─', fg, bg)
+	}
+	for y := 1; y < bottom; y++ {
+		termbox.SetCell(0, y, '│', fg, bg)
+		termbox.SetCell(right, y, '│', fg, bg)
+	}
+	termbox.SetCell(0, 0, '┌', fg, bg)
+	termbox.SetCell(right, 0, '┐', fg, bg)
+	termbox.SetCell(0, bottom, '└', fg, bg)
+	termbox.SetCell(right, bottom, '┘', fg, bg)
+}
 
-	// If we've gotten to this point, we have an event that's ready to process.
-	switch curEvent.Type {
-	case termbox.EventKey:
-		switch curEvent.Key {
+// termboxColor returns the attribute for the closest colour to c in 256
+// colour mode, where colour n is attribute n+1.
+func termboxColor(c color.RGBA) termbox.Attribute {
+	return termbox.Attribute(xterm256(c) + 1)
+}
 
-		case termbox.KeyEsc:
-			i.KeyEsc = true
+// SetFlash turns the visual bell on or off.
+func (t *Termbox) SetFlash(on bool) {
+	if on == t.flash {
+		return
+	}
+	t.flash = on
+	t.DrawShades(t.shades)
+}
 
-		case termbox.KeyCtrlC:
-			i.KeyEsc = true
-		}
+// GetInput handles the events that have arrived since the last call, and
+// returns straight away if there are none.
+func (t *Termbox) GetInput() Input {
+	now := time.Now()
+	for len(t.events) > 0 {
+		t.handle(<-t.events, now)
 	}
 
+	i := NewInput(t.keys.down(now))
+	i.KeyEsc = t.quit
 	return i
 }
 
-func (t Termbox) Shutdown() {
+// handle processes a single terminal event.
+func (t *Termbox) handle(e termbox.Event, now time.Time) {
+	switch e.Type {
+	case termbox.EventKey:
+		if e.Ch == 0 && (e.Key == termbox.KeyEsc || e.Key == termbox.KeyCtrlC) {
+			t.quit = true
+			return
+		}
+		if key, ok := t.Keymap.Key(termboxKeyName(e)); ok {
+			t.keys.press(key, now.Add(t.KeyHold))
+		}
+
+	case termbox.EventResize:
+		// The terminal is cleared when it's resized, so draw the last frame
+		// again.
+		t.DrawShades(t.shades)
+	}
+}
+
+func (t *Termbox) Shutdown() {
 	termbox.Close()
 }
+
+// termboxKeyNames are SDL's names for the special keys termbox reports, so
+// that the same keymaps work with both UIs.
+var termboxKeyNames = map[termbox.Key]string{
+	termbox.KeyArrowUp:    "up",
+	termbox.KeyArrowDown:  "down",
+	termbox.KeyArrowLeft:  "left",
+	termbox.KeyArrowRight: "right",
+	termbox.KeySpace:      "space",
+	termbox.KeyEnter:      "return",
+	termbox.KeyTab:        "tab",
+	termbox.KeyBackspace:  "backspace",
+	termbox.KeyBackspace2: "backspace",
+	termbox.KeyInsert:     "insert",
+	termbox.KeyDelete:     "delete",
+	termbox.KeyHome:       "home",
+	termbox.KeyEnd:        "end",
+	termbox.KeyPgup:       "pageup",
+	termbox.KeyPgdn:       "pagedown",
+}
+
+// termboxKeyName returns the keymap name of the key in a key event, or "" if
+// it doesn't have one.
+func termboxKeyName(e termbox.Event) string {
+	if e.Ch != 0 {
+		return string(unicode.ToLower(e.Ch))
+	}
+	return termboxKeyNames[e.Key]
+}
+
+// keyTimer emulates key releases for terminals, which only report presses.
+// Each press holds the key down until a deadline, and repeats push the
+// deadline back.
+type keyTimer struct {
+	until [16]time.Time
+}
+
+// press holds a key down until the given time.
+func (k *keyTimer) press(key uint8, until time.Time) {
+	if until.After(k.until[key]) {
+		k.until[key] = until
+	}
+}
+
+// down returns the keys that are still held at the given time.
+func (k *keyTimer) down(now time.Time) [16]bool {
+	var keys [16]bool
+	for key, until := range k.until {
+		keys[key] = now.Before(until)
+	}
+	return keys
+}
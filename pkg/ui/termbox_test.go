@@ -0,0 +1,61 @@
+package ui
+
+import (
+	"testing"
+	"time"
+
+	termbox "github.com/nsf/termbox-go"
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that terminal keys get the same names as SDL gives them.
+func TestTermboxKeyName(t *testing.T) {
+	assert := asrt.New(t)
+
+	assert.Equal("q", termboxKeyName(termbox.Event{Type: termbox.EventKey, Ch: 'Q'}))
+	assert.Equal("1", termboxKeyName(termbox.Event{Type: termbox.EventKey, Ch: '1'}))
+	assert.Equal("up", termboxKeyName(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyArrowUp}))
+	assert.Equal("space", termboxKeyName(termbox.Event{Type: termbox.EventKey, Key: termbox.KeySpace}))
+	assert.Equal("", termboxKeyName(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyF1}))
+}
+
+// Test that pressed keys are released after the hold time, unless they
+// repeat.
+func TestKeyTimer(t *testing.T) {
+	assert := asrt.New(t)
+
+	start := time.Unix(0, 0)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	var k keyTimer
+	assert.Equal([16]bool{}, k.down(start))
+
+	k.press(0x5, at(100))
+	assert.True(k.down(at(50))[0x5])
+	assert.False(k.down(at(100))[0x5])
+
+	// A repeat extends the press, an earlier deadline doesn't shorten it.
+	k.press(0x5, at(180))
+	k.press(0x5, at(120))
+	assert.True(k.down(at(150))[0x5])
+	assert.False(k.down(at(150))[0x4])
+}
+
+// Test that events press keys through the keymap, Escape quits, and keys
+// are released on their own.
+func TestTermboxHandle(t *testing.T) {
+	assert := asrt.New(t)
+
+	tb := &Termbox{Keymap: DefaultKeymap, KeyHold: 100 * time.Millisecond}
+	now := time.Unix(0, 0)
+
+	tb.handle(termbox.Event{Type: termbox.EventKey, Ch: 'w'}, now)
+	tb.handle(termbox.Event{Type: termbox.EventKey, Ch: 'p'}, now)
+	keys := tb.keys.down(now.Add(50 * time.Millisecond))
+	assert.Equal([16]bool{0x5: true}, keys)
+	assert.False(tb.keys.down(now.Add(time.Second))[0x5])
+	assert.False(tb.quit)
+
+	tb.handle(termbox.Event{Type: termbox.EventKey, Key: termbox.KeyEsc}, now)
+	assert.True(tb.quit)
+}
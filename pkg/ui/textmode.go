@@ -0,0 +1,161 @@
+package ui
+
+import (
+	"fmt"
+	"image/color"
+	"strings"
+
+	"github.com/cweagans/chip8/pkg/phosphor"
+)
+
+// TextMode is how the termbox UI fits pixels into character cells.
+type TextMode int
+
+const (
+	// TextAuto picks the roomiest mode the terminal is big enough for.
+	TextAuto TextMode = iota
+	// TextWide draws each pixel as two spaces, so that pixels are roughly
+	// square. 64x32 needs a 130x34 terminal.
+	TextWide
+	// TextHalfBlocks draws two pixels, one above the other, in each cell
+	// with ▀, ▄ and █. 64x32 needs 66x18.
+	TextHalfBlocks
+	// TextBraille draws 2x4 pixels in each cell as Braille dots. 64x32
+	// needs 34x10.
+	TextBraille
+)
+
+var textModeNames = []string{"auto", "wide", "half", "braille"}
+
+func (m TextMode) String() string {
+	if int(m) < len(textModeNames) {
+		return textModeNames[m]
+	}
+	return "unknown"
+}
+
+// ParseTextMode returns the text mode with the given name.
+func ParseTextMode(name string) (TextMode, error) {
+	for m, n := range textModeNames {
+		if strings.EqualFold(name, n) {
+			return TextMode(m), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown text mode %q", name)
+}
+
+// SetTextMode changes how the termbox UI draws pixels, and leaves other UIs
+// alone.
+func SetTextMode(u UI, m TextMode) {
+	if t, ok := u.(*Termbox); ok {
+		t.TextMode = m
+	}
+}
+
+// cells returns how many character cells wide and high a display of cols x
+// rows pixels is in a mode, not counting the border.
+func (m TextMode) cells(cols, rows int) (w, h int) {
+	switch m {
+	case TextHalfBlocks:
+		return cols, (rows + 1) / 2
+	case TextBraille:
+		return (cols + 1) / 2, (rows + 3) / 4
+	}
+	return cols * 2, rows
+}
+
+// chooseTextMode returns the first of wide, half blocks and Braille that
+// fits a terminal, border and all. Braille is used if none of them do.
+func chooseTextMode(termW, termH, cols, rows int) TextMode {
+	for _, m := range []TextMode{TextWide, TextHalfBlocks} {
+		if w, h := m.cells(cols, rows); w+2 <= termW && h+2 <= termH {
+			return m
+		}
+	}
+	return TextBraille
+}
+
+// cell is a character cell to draw.
+type cell struct {
+	ch     rune
+	fg, bg color.RGBA
+}
+
+// textCells draws shaded pixels as rows of character cells in a mode, which
+// mustn't be TextAuto.
+func textCells(s phosphor.Shades, m TextMode, p Palette) [][]cell {
+	rows, cols := len(s), len(s[0])
+	// at returns the pixel at (x, y), or 0 past the edges.
+	at := func(x, y int) uint8 {
+		if x >= cols || y >= rows {
+			return 0
+		}
+		return s[y][x]
+	}
+
+	w, h := m.cells(cols, rows)
+	out := make([][]cell, h)
+	for cy := range out {
+		out[cy] = make([]cell, w)
+		for cx := range out[cy] {
+			switch m {
+			case TextHalfBlocks:
+				out[cy][cx] = halfBlock(at(cx, cy*2), at(cx, cy*2+1), p)
+			case TextBraille:
+				out[cy][cx] = braille(func(dx, dy int) uint8 { return at(cx*2+dx, cy*4+dy) }, p)
+			default:
+				out[cy][cx] = cell{' ', p.Foreground(), p.Shade(at(cx/2, cy))}
+			}
+		}
+	}
+	return out
+}
+
+// halfBlock draws a pixel over another. Terminals draw a character in the
+// foreground colour on the background, so one of them is always the
+// background if it can be.
+func halfBlock(top, bottom uint8, p Palette) cell {
+	bg := p.Background()
+	switch {
+	case top == bottom && top == 0:
+		return cell{' ', p.Foreground(), bg}
+	case top == bottom:
+		return cell{'█', p.Shade(top), bg}
+	case bottom == 0:
+		return cell{'▀', p.Shade(top), bg}
+	case top == 0:
+		return cell{'▄', p.Shade(bottom), bg}
+	}
+	return cell{'▀', p.Shade(top), p.Shade(bottom)}
+}
+
+// brailleDots are the bits of the Braille dots for each pixel in a 2x4
+// block, by row and column.
+var brailleDots = [4][2]rune{
+	{0x01, 0x08},
+	{0x02, 0x10},
+	{0x04, 0x20},
+	{0x40, 0x80},
+}
+
+// braille draws a 2x4 block of pixels as the dots of a Braille pattern.
+// Cells only have one foreground colour, so the dots are all drawn as bright
+// as the brightest.
+func braille(at func(dx, dy int) uint8, p Palette) cell {
+	var dots rune
+	var brightest uint8
+	for dy, row := range brailleDots {
+		for dx, bit := range row {
+			if v := at(dx, dy); v > 0 {
+				dots |= bit
+				if v > brightest {
+					brightest = v
+				}
+			}
+		}
+	}
+	if dots == 0 {
+		return cell{' ', p.Foreground(), p.Background()}
+	}
+	return cell{0x2800 + dots, p.Shade(brightest), p.Background()}
+}
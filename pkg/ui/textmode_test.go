@@ -0,0 +1,87 @@
+package ui
+
+import (
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/phosphor"
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that text modes are found by name, and picked to fit the terminal.
+func TestTextModes(t *testing.T) {
+	assert := asrt.New(t)
+
+	m, err := ParseTextMode("Braille")
+	assert.Nil(err)
+	assert.Equal(TextBraille, m)
+	assert.Equal("half", TextHalfBlocks.String())
+	_, err = ParseTextMode("sixel")
+	assert.NotNil(err)
+
+	assert.Equal(TextWide, chooseTextMode(130, 34, 64, 32))
+	assert.Equal(TextHalfBlocks, chooseTextMode(129, 50, 64, 32))
+	assert.Equal(TextHalfBlocks, chooseTextMode(66, 18, 64, 32))
+	assert.Equal(TextBraille, chooseTextMode(80, 17, 64, 32))
+	assert.Equal(TextBraille, chooseTextMode(10, 5, 64, 32))
+
+	// SCHIP's 128x64 fits a 130x34 terminal in half blocks.
+	assert.Equal(TextHalfBlocks, chooseTextMode(130, 34, 128, 64))
+}
+
+// Test that each mode lays out the right number of cells.
+func TestTextCellsSize(t *testing.T) {
+	assert := asrt.New(t)
+
+	for _, c := range []struct {
+		mode TextMode
+		w, h int
+	}{
+		{TextWide, 128, 32},
+		{TextHalfBlocks, 64, 16},
+		{TextBraille, 32, 8},
+	} {
+		cells := textCells(phosphor.Shades{}, c.mode, DefaultPalette)
+		assert.Len(cells, c.h, c.mode.String())
+		assert.Len(cells[0], c.w, c.mode.String())
+	}
+}
+
+// Test that half blocks draw the right character for each pair of pixels,
+// with the background behind lit pixels wherever it can be.
+func TestHalfBlocks(t *testing.T) {
+	assert := asrt.New(t)
+
+	var s phosphor.Shades
+	s[0][1] = phosphor.Lit // top
+	s[1][2] = phosphor.Lit // bottom
+	s[0][3], s[1][3] = phosphor.Lit, phosphor.Lit
+	s[0][4], s[1][4] = phosphor.Lit, 0x80
+
+	p := DefaultPalette
+	row := textCells(s, TextHalfBlocks, p)[0]
+	assert.Equal(cell{' ', p.Foreground(), p.Background()}, row[0])
+	assert.Equal(cell{'▀', p.Foreground(), p.Background()}, row[1])
+	assert.Equal(cell{'▄', p.Foreground(), p.Background()}, row[2])
+	assert.Equal(cell{'█', p.Foreground(), p.Background()}, row[3])
+	assert.Equal(cell{'▀', p.Foreground(), p.Shade(0x80)}, row[4])
+}
+
+// Test that Braille cells light the dot for each pixel.
+func TestBraille(t *testing.T) {
+	assert := asrt.New(t)
+
+	var s phosphor.Shades
+	s[0][0] = phosphor.Lit // dot 1
+	s[3][1] = 0x80         // dot 8
+	s[2][2] = phosphor.Lit // dot 3 of the next cell
+
+	p := DefaultPalette
+	row := textCells(s, TextBraille, p)[0]
+	assert.Equal(cell{'⢁', p.Foreground(), p.Background()}, row[0])
+	assert.Equal(cell{'⠄', p.Foreground(), p.Background()}, row[1])
+	assert.Equal(cell{' ', p.Foreground(), p.Background()}, row[2])
+
+	// A cell is as bright as its brightest dot.
+	s[0][0] = 0
+	assert.Equal(p.Shade(0x80), textCells(s, TextBraille, p)[0][0].fg)
+}
@@ -0,0 +1,105 @@
+package ui
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+)
+
+// WAVSampleRate is the sample rate of recorded audio.
+const WAVSampleRate = 44100
+
+// Recorder renders the buzzer against emulated time rather than wall time:
+// each frame takes 1/60 of a second, so the same run always gives the same
+// samples, however fast it actually ran.
+type Recorder struct {
+	Rate int
+
+	synth   *Synth
+	samples []int16
+}
+
+// NewRecorder returns a recorder that plays the buzzer with the given tone.
+func NewRecorder(t Tone) *Recorder {
+	return &Recorder{
+		Rate:  WAVSampleRate,
+		synth: NewSynth(t, WAVSampleRate),
+	}
+}
+
+// Attach records the CPU's buzzer until the returned function is called.
+func (r *Recorder) Attach(c *cpu.Cpu) (detach func()) {
+	return c.Subscribe(cpu.SoundStarted|cpu.SoundStopped, func(e cpu.Event) {
+		r.Sound(e.Frame, e.Kind == cpu.SoundStarted)
+	})
+}
+
+// Sound starts or stops the buzzer once the given number of frames have
+// run.
+func (r *Recorder) Sound(frame uint64, on bool) {
+	r.advance(frame)
+	r.synth.SetOn(on)
+}
+
+// Finish renders the audio up to the given number of frames, which should
+// be where the run ended.
+func (r *Recorder) Finish(frame uint64) {
+	r.advance(frame)
+}
+
+// advance renders samples up to the start of the given frame.
+func (r *Recorder) advance(frame uint64) {
+	end := int(frame * uint64(r.Rate) / cpu.FrameRate)
+	if end <= len(r.samples) {
+		return
+	}
+	start := len(r.samples)
+	r.samples = append(r.samples, make([]int16, end-start)...)
+	r.synth.Fill(r.samples[start:])
+}
+
+// Samples returns the audio rendered so far.
+func (r *Recorder) Samples() []int16 {
+	return r.samples
+}
+
+// WriteWAV writes the audio rendered so far as a WAV file.
+func (r *Recorder) WriteWAV(w io.Writer) error {
+	return WriteWAV(w, r.Rate, r.samples)
+}
+
+// WriteWAV writes mono 16-bit samples as a WAV file.
+func WriteWAV(w io.Writer, rate int, samples []int16) error {
+	bw := bufio.NewWriter(w)
+	size := uint32(2 * len(samples))
+
+	header := []interface{}{
+		[4]byte{'R', 'I', 'F', 'F'},
+		uint32(36 + size),
+		[4]byte{'W', 'A', 'V', 'E'},
+
+		// Format chunk: PCM, one channel.
+		[4]byte{'f', 'm', 't', ' '},
+		uint32(16),
+		uint16(1),
+		uint16(1),
+		uint32(rate),
+		uint32(2 * rate), // bytes per second
+		uint16(2),        // bytes per sample
+		uint16(16),       // bits per sample
+
+		[4]byte{'d', 'a', 't', 'a'},
+		size,
+	}
+	for _, v := range header {
+		if err := binary.Write(bw, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(bw, binary.LittleEndian, samples); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
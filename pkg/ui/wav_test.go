@@ -0,0 +1,83 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/cweagans/chip8/pkg/cpu"
+	asrt "github.com/stretchr/testify/assert"
+)
+
+// Test that the buzzer is placed by emulated time: each frame lasts 735
+// samples.
+func TestRecorder(t *testing.T) {
+	assert := asrt.New(t)
+
+	r := NewRecorder(Tone{Pitch: 441, Volume: 1, Waveform: Square})
+	r.Sound(2, true)
+	r.Sound(4, false)
+	r.Finish(6)
+
+	samples := r.Samples()
+	assert.Len(samples, 6*735)
+	assert.Equal(int16(0), samples[2*735-1])
+	assert.NotEqual(int16(0), samples[2*735+1])
+	assert.NotEqual(int16(0), samples[4*735])
+	assert.Equal(int16(0), samples[5*735])
+}
+
+// Test that recording a CPU gives the same audio every time, and that the
+// sound lasts as many frames as the sound timer was set to at any clock
+// speed.
+func TestRecorderAttach(t *testing.T) {
+	assert := asrt.New(t)
+
+	record := func(hz int) []int16 {
+		// LD V0, 3; LD ST, V0; JP 0x204
+		c := cpu.NewCpu(nil, []byte{0x60, 0x03, 0xF0, 0x18, 0x12, 0x04})
+		c.SetClockSpeed(hz)
+		r := NewRecorder(DefaultTone)
+		detach := r.Attach(c)
+		for frame := 0; frame < 6; frame++ {
+			for i := c.StartFrame(); i > 0; i-- {
+				c.Step()
+			}
+		}
+		detach()
+		r.Finish(c.Frames)
+		return r.Samples()
+	}
+
+	first := record(600)
+	assert.Len(first, 6*735)
+	assert.Equal(first, record(600))
+	assert.Equal(first, record(500))
+
+	// The timer is set in the first frame, so the tone starts with the
+	// second and starts fading out three frames later.
+	fade := int(RampTime.Seconds() * WAVSampleRate)
+	assert.Equal(int16(0), first[735-1])
+	assert.NotEqual(int16(0), first[735+1])
+	assert.NotEqual(int16(0), first[4*735+1])
+	assert.Equal(int16(0), first[4*735+fade+1])
+}
+
+// Test the layout of a WAV file.
+func TestWriteWAV(t *testing.T) {
+	assert := asrt.New(t)
+
+	var buf bytes.Buffer
+	assert.Nil(WriteWAV(&buf, 8000, []int16{1, -1, 256}))
+
+	b := buf.Bytes()
+	assert.Len(b, 44+6)
+	assert.Equal("RIFF", string(b[0:4]))
+	assert.Equal(uint32(36+6), binary.LittleEndian.Uint32(b[4:]))
+	assert.Equal("WAVEfmt ", string(b[8:16]))
+	assert.Equal(uint16(1), binary.LittleEndian.Uint16(b[22:]))
+	assert.Equal(uint32(8000), binary.LittleEndian.Uint32(b[24:]))
+	assert.Equal("data", string(b[36:40]))
+	assert.Equal(uint32(6), binary.LittleEndian.Uint32(b[40:]))
+	assert.Equal([]byte{1, 0, 0xFF, 0xFF, 0, 1}, b[44:])
+}